@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
+)
+
+type options struct {
+	ciOperatorConfigDir string
+	prowJobsDir         string
+
+	help bool
+}
+
+func bindOptions(flag *flag.FlagSet) *options {
+	opt := &options{}
+
+	flag.StringVar(&opt.ciOperatorConfigDir, "ci-operator-config-dir", "", "Path to a root of directory structure with ci-operator config files")
+	flag.StringVar(&opt.prowJobsDir, "prow-jobs-dir", "", "Path to a root of directory structure with Prow job config files")
+	flag.BoolVar(&opt.help, "h", false, "Show help for check-promotion-jobs")
+
+	return opt
+}
+
+func main() {
+	flagSet := flag.NewFlagSet("", flag.ExitOnError)
+	opt := bindOptions(flagSet)
+	flagSet.Parse(os.Args[1:])
+
+	if opt.help {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(opt.ciOperatorConfigDir) == 0 || len(opt.prowJobsDir) == 0 {
+		fmt.Fprintln(os.Stderr, "check-promotion-jobs needs both --ci-operator-config-dir and --prow-jobs-dir")
+		os.Exit(1)
+	}
+
+	configs, err := config.CompoundLoad(opt.ciOperatorConfigDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read ci-operator configs from %s (%v)\n", opt.ciOperatorConfigDir, err)
+		os.Exit(1)
+	}
+	jobConfig, err := jc.ReadFromDir(opt.prowJobsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read Prow jobs from %s (%v)\n", opt.prowJobsDir, err)
+		os.Exit(1)
+	}
+
+	filenames := jc.ConfigsWithoutPromotion(jobConfig, configs)
+	if len(filenames) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d ci-operator configs promote official images, but have no postsubmit promoting them:\n", len(filenames))
+	for _, filename := range filenames {
+		fmt.Fprintf(os.Stderr, "  %s\n", filename)
+	}
+	os.Exit(1)
+}
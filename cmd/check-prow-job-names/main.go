@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
+)
+
+type options struct {
+	prowJobsDir string
+
+	help bool
+}
+
+func bindOptions(flag *flag.FlagSet) *options {
+	opt := &options{}
+
+	flag.StringVar(&opt.prowJobsDir, "prow-jobs-dir", "", "Path to a root of directory structure with Prow job config files")
+	flag.BoolVar(&opt.help, "h", false, "Show help for check-prow-job-names")
+
+	return opt
+}
+
+func main() {
+	flagSet := flag.NewFlagSet("", flag.ExitOnError)
+	opt := bindOptions(flagSet)
+	flagSet.Parse(os.Args[1:])
+
+	if opt.help {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(opt.prowJobsDir) == 0 {
+		fmt.Fprintln(os.Stderr, "check-prow-job-names needs --prow-jobs-dir")
+		os.Exit(1)
+	}
+
+	jobConfig, err := jc.ReadFromDir(opt.prowJobsDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read Prow jobs from %s (%v)\n", opt.prowJobsDir, err)
+		os.Exit(1)
+	}
+
+	names := jc.LongJobNames(jobConfig)
+	if len(names) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%d job names are longer than %d characters:\n", len(names), jc.MaxJobNameLength)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %s (%d)\n", name, len(name))
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
+)
+
+// requiredContexts holds the contexts of generated presubmits that need to
+// pass before a PR can merge, keyed by org, repo and branch
+type requiredContexts map[string]map[string]map[string]sets.String
+
+// add records the contexts of all presubmits in the job config that run on
+// every PR and are not optional, so they should be required by branch protection
+func (r requiredContexts) add(jobConfig *prowconfig.JobConfig) {
+	for orgRepo, presubmits := range jobConfig.Presubmits {
+		parts := strings.SplitN(orgRepo, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		org, repo := parts[0], parts[1]
+		for _, presubmit := range presubmits {
+			if !presubmit.AlwaysRun || presubmit.Optional {
+				continue
+			}
+			for _, branch := range presubmit.Branches {
+				if _, ok := r[org]; !ok {
+					r[org] = map[string]map[string]sets.String{}
+				}
+				if _, ok := r[org][repo]; !ok {
+					r[org][repo] = map[string]sets.String{}
+				}
+				if _, ok := r[org][repo][branch]; !ok {
+					r[org][repo][branch] = sets.NewString()
+				}
+				r[org][repo][branch].Insert(presubmit.Context)
+			}
+		}
+	}
+}
+
+// branchProtection renders the collected contexts as a Prow branch protection
+// configuration, so a companion tool can keep required checks in sync
+func (r requiredContexts) branchProtection() prowconfig.BranchProtection {
+	protect := true
+	bp := prowconfig.BranchProtection{Orgs: map[string]prowconfig.Org{}}
+	for org, repos := range r {
+		orgPolicy := prowconfig.Org{Repos: map[string]prowconfig.Repo{}}
+		for repo, branches := range repos {
+			repoPolicy := prowconfig.Repo{Branches: map[string]prowconfig.Branch{}}
+			for branch, contexts := range branches {
+				repoPolicy.Branches[branch] = prowconfig.Branch{Policy: prowconfig.Policy{
+					Protect:              &protect,
+					RequiredStatusChecks: &prowconfig.ContextPolicy{Contexts: contexts.List()},
+				}}
+			}
+			orgPolicy.Repos[repo] = repoPolicy
+		}
+		bp.Orgs[org] = orgPolicy
+	}
+	return bp
+}
+
+// writeToFile writes the collected contexts as a `branch-protection` stanza
+func (r requiredContexts) writeToFile(path string, mode os.FileMode) error {
+	raw, err := yaml.Marshal(struct {
+		BranchProtection prowconfig.BranchProtection `json:"branch-protection"`
+	}{BranchProtection: r.branchProtection()})
+	if err != nil {
+		return fmt.Errorf("failed to marshal required contexts (%v)", err)
+	}
+	return jc.WriteFile(path, raw, mode)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestRequiredContexts(t *testing.T) {
+	protect := true
+	makePresubmit := func(context, branch string, alwaysRun, optional bool) prowconfig.Presubmit {
+		return prowconfig.Presubmit{
+			AlwaysRun: alwaysRun,
+			Optional:  optional,
+			Brancher:  prowconfig.Brancher{Branches: []string{branch}},
+			Reporter:  prowconfig.Reporter{Context: context},
+		}
+	}
+	jobConfigs := []*prowconfig.JobConfig{
+		{Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {
+			makePresubmit("ci/prow/unit", "master", true, false),
+			makePresubmit("ci/prow/images", "master", true, false),
+			makePresubmit("ci/prow/optional", "master", true, true),
+			makePresubmit("ci/prow/manual", "master", false, false),
+		}}},
+		{Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {
+			makePresubmit("ci/prow/unit", "release-4.1", true, false),
+		}}},
+		{Presubmits: map[string][]prowconfig.Presubmit{"org/other": {
+			makePresubmit("ci/prow/manual", "master", false, false),
+		}}},
+	}
+
+	expected := prowconfig.BranchProtection{Orgs: map[string]prowconfig.Org{
+		"org": {Repos: map[string]prowconfig.Repo{
+			"repo": {Branches: map[string]prowconfig.Branch{
+				"master": {Policy: prowconfig.Policy{
+					Protect:              &protect,
+					RequiredStatusChecks: &prowconfig.ContextPolicy{Contexts: []string{"ci/prow/images", "ci/prow/unit"}},
+				}},
+				"release-4.1": {Policy: prowconfig.Policy{
+					Protect:              &protect,
+					RequiredStatusChecks: &prowconfig.ContextPolicy{Contexts: []string{"ci/prow/unit"}},
+				}},
+			}},
+		}},
+	}}
+
+	contexts := requiredContexts{}
+	for _, jobConfig := range jobConfigs {
+		contexts.add(jobConfig)
+	}
+	if actual := contexts.branchProtection(); !equality.Semantic.DeepEqual(expected, actual) {
+		t.Errorf("expected branch protection differs:\n%s", diff.ObjectReflectDiff(expected, actual))
+	}
+}
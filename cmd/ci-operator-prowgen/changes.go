@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	"github.com/openshift/ci-operator-prowgen/pkg/diffs"
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
+	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
+)
+
+// diffChangedRepos regenerates the jobs for the repositories which have
+// ci-operator configuration files in `configDir` changed since `baseRev` and
+// returns how writing them would change the job files in `jobDir`. Nothing is
+// written to `jobDir`: the job files of the affected repositories are copied
+// aside and the jobs are written to the copies.
+func diffChangedRepos(configDir, jobDir, baseRev string, parallel int, opts *generatorOptions) (map[string]*diffs.RepoJobsDiff, error) {
+	repos, err := config.GetChangedCiopConfigRepos(configDir, baseRev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine changed ci-operator configuration files (%v)", err)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "prowgen-diff")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory (%v)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	beforeDir := filepath.Join(tmpDir, "before")
+	afterDir := filepath.Join(tmpDir, "after")
+
+	jobs := newJobsToDir(afterDir, nil, nil, opts)
+	for _, repo := range repos {
+		repoDir := filepath.Join(configDir, repo)
+		if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+			// all configuration files of the repository were removed
+			continue
+		}
+		if err := config.OperateOnCIOperatorConfigDirParallel(repoDir, parallel, jobs.generate); err != nil {
+			return nil, fmt.Errorf("failed to generate jobs for %s (%v)", repo, err)
+		}
+	}
+
+	for _, orgRepo := range jobs.orgRepos() {
+		for _, dir := range []string{beforeDir, afterDir} {
+			if err := copyJobFiles(filepath.Join(jobDir, orgRepo), filepath.Join(dir, orgRepo)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := jobs.write(); err != nil {
+		return nil, err
+	}
+
+	before, err := jc.ReadFromDir(beforeDir)
+	if err != nil {
+		return nil, err
+	}
+	after, err := jc.ReadFromDir(afterDir)
+	if err != nil {
+		return nil, err
+	}
+	return diffs.GetJobConfigDiffs(before, after), nil
+}
+
+// dryRunJobs generates jobs for the ci-operator configuration files `operate`
+// runs on and prints a unified diff of the job files in `jobDir` and the ones
+// writing the jobs would result in to `out`. It returns whether any job file
+// would change. Like diffChangedRepos, it writes the jobs to copies of the job
+// files of the affected repositories and leaves `jobDir` untouched.
+func dryRunJobs(jobDir string, opts *generatorOptions, operate func(func(*cioperatorapi.ReleaseBuildConfiguration, *config.Info) error) error, out io.Writer) (bool, error) {
+	tmpDir, err := ioutil.TempDir("", "prowgen-dry-run")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temporary directory (%v)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	jobs := newJobsToDir(tmpDir, nil, nil, opts)
+	if err := operate(jobs.generate); err != nil {
+		return false, fmt.Errorf("failed to generate jobs (%v)", err)
+	}
+	for _, orgRepo := range jobs.orgRepos() {
+		if err := copyJobFiles(filepath.Join(jobDir, orgRepo), filepath.Join(tmpDir, orgRepo)); err != nil {
+			return false, err
+		}
+	}
+	if err := jobs.write(); err != nil {
+		return false, err
+	}
+
+	changed := false
+	for _, orgRepo := range jobs.orgRepos() {
+		names := sets.NewString()
+		for _, dir := range []string{jobDir, tmpDir} {
+			entries, err := ioutil.ReadDir(filepath.Join(dir, orgRepo))
+			if err != nil && !os.IsNotExist(err) {
+				return false, fmt.Errorf("failed to list %s (%v)", filepath.Join(dir, orgRepo), err)
+			}
+			for _, entry := range entries {
+				if entry.Mode().IsRegular() {
+					names.Insert(entry.Name())
+				}
+			}
+		}
+		for _, name := range names.List() {
+			path := filepath.Join(jobDir, orgRepo, name)
+			before, fromName, err := readJobFile(path)
+			if err != nil {
+				return false, err
+			}
+			after, toName, err := readJobFile(filepath.Join(tmpDir, orgRepo, name))
+			if err != nil {
+				return false, err
+			}
+			if toName != os.DevNull {
+				toName = path
+			}
+			if unified := diffs.UnifiedDiff(fromName, toName, before, after); unified != "" {
+				changed = true
+				fmt.Fprint(out, unified)
+			}
+		}
+	}
+	return changed, nil
+}
+
+// readJobFile returns the content of the job file and the name it has in
+// unified diffs, which is /dev/null for files that do not exist
+func readJobFile(path string) (string, string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", os.DevNull, nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read %s (%v)", path, err)
+	}
+	return string(data), path, nil
+}
+
+// copyJobFiles copies the files in the `from` directory, if it exists, to the
+// `to` directory, which is created
+func copyJobFiles(from, to string) error {
+	if err := os.MkdirAll(to, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create %s (%v)", to, err)
+	}
+	entries, err := ioutil.ReadDir(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list %s (%v)", from, err)
+	}
+	for _, entry := range entries {
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(from, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to read %s (%v)", entry.Name(), err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(to, entry.Name()), data, 0664); err != nil {
+			return fmt.Errorf("failed to copy %s (%v)", entry.Name(), err)
+		}
+	}
+	return nil
+}
+
+// orgRepos returns the ORG/REPO names the generated jobs are set up for, sorted
+func (j *jobsToDir) orgRepos() []string {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	return sets.StringKeySet(j.jobs).List()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	"github.com/openshift/ci-operator-prowgen/pkg/diffs"
+	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
+)
+
+func TestDiffChangedRepos(t *testing.T) {
+	ciopConfig := `build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`
+	tmp, err := ioutil.TempDir("", "prowgen-diff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	configDir := filepath.Join(tmp, "ci-operator", "config")
+	jobDir := filepath.Join(tmp, "ci-operator", "jobs")
+	for _, path := range []string{"super/duper/super-duper-master.yaml", "super/other/super-other-master.yaml"} {
+		path = filepath.Join(configDir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(ciopConfig), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := &generatorOptions{readProwgenConfigs: true}
+	jobs := newJobsToDir(jobDir, nil, nil, opts)
+	if err := config.OperateOnCIOperatorConfigDir(configDir, jobs.generate); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	changed := ciopConfig + `- as: e2e
+  commands: make test-e2e
+  container:
+    from: src
+`
+	cmd := exec.Command("sh", "-ec", fmt.Sprintf(`
+git init --quiet .
+git config user.name test
+git config user.email test
+git add .
+git commit --quiet -m initial
+cat > ci-operator/config/super/duper/super-duper-master.yaml <<'EOF'
+%sEOF
+git commit --quiet --all --message changes
+git rev-parse HEAD^
+`, changed))
+	cmd.Dir = tmp
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%q failed, output:\n%s", cmd.Args, out)
+	}
+	jobFile := filepath.Join(jobDir, "super", "duper", "super-duper-master-presubmits.yaml")
+	jobsBefore, err := ioutil.ReadFile(jobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repoDiffs, err := diffChangedRepos(configDir, jobDir, strings.TrimSpace(string(out)), 1, opts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]*diffs.RepoJobsDiff{
+		"super/duper": {Added: []string{"pull-ci-super-duper-master-e2e"}},
+	}
+	if !reflect.DeepEqual(expected, repoDiffs) {
+		t.Errorf("unexpected diffs: %s", diff.ObjectReflectDiff(expected, repoDiffs))
+	}
+
+	jobsAfter, err := ioutil.ReadFile(jobFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(jobsBefore) != string(jobsAfter) {
+		t.Errorf("job file was modified:\n%s", diff.StringDiff(string(jobsBefore), string(jobsAfter)))
+	}
+}
+
+func TestDryRunJobs(t *testing.T) {
+	ciopConfig := `build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`
+	tmp, err := ioutil.TempDir("", "prowgen-dry-run")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	configDir := filepath.Join(tmp, "ci-operator", "config")
+	jobDir := filepath.Join(tmp, "ci-operator", "jobs")
+	configFile := filepath.Join(configDir, "super/duper/super-duper-master.yaml")
+	if err := os.MkdirAll(filepath.Dir(configFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(configFile, []byte(ciopConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := &generatorOptions{readProwgenConfigs: true}
+	operate := func(callback func(*cioperatorapi.ReleaseBuildConfiguration, *config.Info) error) error {
+		return config.OperateOnCIOperatorConfigDir(configDir, callback)
+	}
+
+	var out bytes.Buffer
+	changed, err := dryRunJobs(jobDir, opts, operate, &out)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jobFile := filepath.Join(jobDir, "super", "duper", "super-duper-master-presubmits.yaml")
+	if !changed || !strings.HasPrefix(out.String(), fmt.Sprintf("--- %s\n+++ %s\n@@ -0,0 +1,", os.DevNull, jobFile)) {
+		t.Errorf("expected a diff adding %s, got:\n%s", jobFile, out.String())
+	}
+	if _, err := os.Stat(jobFile); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to be written, got %v", jobFile, err)
+	}
+
+	jobs := newJobsToDir(jobDir, nil, nil, opts)
+	if err := operate(jobs.generate); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.write(); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if changed, err = dryRunJobs(jobDir, opts, operate, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed || out.Len() != 0 {
+		t.Errorf("expected no diff for up to date job files, got:\n%s", out.String())
+	}
+
+	if err := ioutil.WriteFile(configFile, []byte(ciopConfig+`- as: e2e
+  commands: make test-e2e
+  container:
+    from: src
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if changed, err = dryRunJobs(jobDir, opts, operate, &out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !changed || !strings.HasPrefix(out.String(), fmt.Sprintf("--- %s\n+++ %s\n@@ ", jobFile, jobFile)) {
+		t.Errorf("expected a diff changing %s, got:\n%s", jobFile, out.String())
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/ghodss/yaml"
+)
+
+// defaultsFileEnv is the environment variable which can name the defaults
+// file instead of the `--defaults-file` option
+const defaultsFileEnv = "PROWGEN_DEFAULTS_FILE"
+
+// applyDefaultsFile sets the flags which were not passed on the command line
+// to the values in the defaults file. The file maps flag names to values;
+// flags which can be passed multiple times take a list of values.
+func applyDefaultsFile(flagSet *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read defaults file (%v)", err)
+	}
+	var defaults map[string]interface{}
+	if err := yaml.Unmarshal(data, &defaults); err != nil {
+		return fmt.Errorf("failed to load defaults file (%v)", err)
+	}
+
+	passed := map[string]bool{}
+	flagSet.Visit(func(f *flag.Flag) {
+		passed[f.Name] = true
+	})
+	for name, value := range defaults {
+		if name == "defaults-file" || name == "h" || flagSet.Lookup(name) == nil {
+			return fmt.Errorf("defaults file: unknown option %q", name)
+		}
+		if passed[name] {
+			continue
+		}
+		values, isList := value.([]interface{})
+		if !isList {
+			values = []interface{}{value}
+		}
+		for _, value := range values {
+			var raw string
+			switch v := value.(type) {
+			case string:
+				raw = v
+			case bool:
+				raw = strconv.FormatBool(v)
+			case float64:
+				raw = strconv.FormatFloat(v, 'f', -1, 64)
+			default:
+				return fmt.Errorf("defaults file: option %q needs a string, number, boolean or a list of them", name)
+			}
+			if err := flagSet.Set(name, raw); err != nil {
+				return fmt.Errorf("defaults file: invalid value %q for option %q (%v)", raw, name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func TestApplyDefaultsFile(t *testing.T) {
+	testCases := []struct {
+		name          string
+		args          []string
+		defaults      string
+		check         func(t *testing.T, opt *options)
+		expectedError bool
+	}{
+		{
+			name:     "options are set from the file",
+			defaults: "to-dir: /jobs\nkustomize: true\nparallel: 4\ndecoration-timeout: 4h\nimage-pull-secret:\n- first\n- second\n",
+			check: func(t *testing.T, opt *options) {
+				if opt.toDir != "/jobs" || !opt.kustomize || opt.parallel != 4 || opt.generator.decorationTimeout != 4*time.Hour {
+					t.Errorf("options not set from the file: %#v", opt)
+				}
+				if expected := []string{"first", "second"}; !reflect.DeepEqual(expected, opt.imagePullSecrets.Strings()) {
+					t.Errorf("unexpected image pull secrets: %s", diff.ObjectReflectDiff(expected, opt.imagePullSecrets.Strings()))
+				}
+			},
+		},
+		{
+			name:     "options passed on the command line override the file",
+			args:     []string{"--to-dir=/other", "--image-pull-secret=cli"},
+			defaults: "to-dir: /jobs\nparallel: 4\nimage-pull-secret:\n- first\n",
+			check: func(t *testing.T, opt *options) {
+				if opt.toDir != "/other" || opt.parallel != 4 {
+					t.Errorf("unexpected options: %#v", opt)
+				}
+				if expected := []string{"cli"}; !reflect.DeepEqual(expected, opt.imagePullSecrets.Strings()) {
+					t.Errorf("unexpected image pull secrets: %s", diff.ObjectReflectDiff(expected, opt.imagePullSecrets.Strings()))
+				}
+			},
+		},
+		{
+			name:          "unknown option",
+			defaults:      "to-nowhere: /jobs\n",
+			expectedError: true,
+		},
+		{
+			name:          "defaults file cannot be set from itself",
+			defaults:      "defaults-file: /other.yaml\n",
+			expectedError: true,
+		},
+		{
+			name:          "invalid value",
+			defaults:      "parallel: many\n",
+			expectedError: true,
+		},
+		{
+			name:          "nested value",
+			defaults:      "to-dir:\n  path: /jobs\n",
+			expectedError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			file, err := ioutil.TempFile("", "prowgen-defaults")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(file.Name())
+			if _, err := file.WriteString(testCase.defaults); err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			flagSet := flag.NewFlagSet("", flag.ContinueOnError)
+			opt := bindOptions(flagSet)
+			if err := flagSet.Parse(testCase.args); err != nil {
+				t.Fatal(err)
+			}
+			err = applyDefaultsFile(flagSet, file.Name())
+			if err == nil && testCase.expectedError {
+				t.Errorf("expected an error, but got none")
+			}
+			if err != nil && !testCase.expectedError {
+				t.Errorf("expected no error, but got one: %v", err)
+			}
+			if testCase.check != nil {
+				testCase.check(t, opt)
+			}
+		})
+	}
+}
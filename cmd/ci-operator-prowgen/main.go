@@ -3,53 +3,389 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/openshift/ci-operator-prowgen/pkg/promotion"
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/flagutil"
 
 	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	"github.com/openshift/ci-operator-prowgen/pkg/diffs"
 	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
 	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
 	kubeapi "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
+	kutilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 	prowconfig "k8s.io/test-infra/prow/config"
 )
 
 const (
 	prowJobLabelVariant = "ci-operator.openshift.io/variant"
 
+	defaultConfigSpecEnv = "CONFIG_SPEC"
+	defaultArtifactDir   = "$(ARTIFACTS)"
+
+	defaultCiOperatorImage           = "ci-operator:latest"
+	defaultCiOperatorImagePullPolicy = kubeapi.PullAlways
+
 	sentryDsnMountName  = "sentry-dsn"
 	sentryDsnSecretName = "sentry-dsn"
 	sentryDsnMountPath  = "/etc/sentry-dsn"
 	sentryDsnSecretPath = "/etc/sentry-dsn/ci-operator"
+
+	leaseServerCredentialsMountName = "boskos"
+	leaseServerCredentialsMountPath = "/etc/boskos"
+	leaseServerPasswordPath         = "/etc/boskos/password"
+
+	prAuthorAccessArg = "--give-pr-author-access-to-namespace"
+
+	pullSecretMountName   = "pull-secret"
+	defaultPullSecretName = "registry-pull-credentials"
 )
 
 type options struct {
 	fromFile        string
 	fromDir         string
+	fromConfigMap   string
 	fromReleaseRepo bool
 
 	toDir         string
 	toReleaseRepo bool
 
+	toRequiredContexts string
+	toSummary          string
+
+	fileMode string
+
+	kustomize bool
+
+	tarStream bool
+
+	validateNotEmpty bool
+	listOrphans      bool
+	diffSince        string
+	dryRun           bool
+
+	parallel int
+
+	branchAliases    flagutil.Strings
+	orgRemaps        flagutil.Strings
+	imagePullSecrets flagutil.Strings
+	pullSecretPaths  flagutil.Strings
+	jobLabels        flagutil.Strings
+	keyFormat        string
+
+	generator generatorOptions
+
+	defaultsFile string
+
 	help bool
 }
 
+// generatorOptions holds settings which influence how jobs are generated
+type generatorOptions struct {
+	// branchAliases maps ORG/REPO to a mapping of branch names found in
+	// configuration file names to the names of branches the jobs should target
+	branchAliases map[string]map[string]string
+	// orgRemaps maps organizations found in configuration paths to the
+	// organizations the generated jobs should be set up for
+	orgRemaps map[string]string
+
+	// decorationTimeout and decorationGracePeriod are set in the decoration
+	// config of generated jobs when not zero; otherwise jobs inherit the
+	// defaults from the global Prow configuration
+	decorationTimeout     time.Duration
+	decorationGracePeriod time.Duration
+	// utilityImages are set in the decoration config of generated jobs when
+	// not empty, which process() ensures only happens with all of them
+	utilityImages v1.UtilityImages
+
+	// configSpecEnv is the name of the environment variable through which
+	// generated jobs pass the ci-operator configuration to the container
+	configSpecEnv string
+	// keyFormat determines the keys under which the configuration files are
+	// stored in ConfigMaps, which generated jobs refer to; nil is the default
+	keyFormat *config.ConfigMapKeyFormat
+
+	// imagePullSecrets are names of secrets used to pull the ci-operator image
+	imagePullSecrets []string
+	// ciOperatorImage and ciOperatorImagePullPolicy are the image generated
+	// jobs run ci-operator from and its pull policy, defaulting to
+	// defaultCiOperatorImage and defaultCiOperatorImagePullPolicy
+	ciOperatorImage           string
+	ciOperatorImagePullPolicy kubeapi.PullPolicy
+
+	// artifactDir is the directory where ci-operator puts artifacts
+	artifactDir string
+
+	// strictNames makes generation fail for job names longer than Prow supports
+	strictNames bool
+
+	// fileGrouping determines how the generated jobs are sharded into files
+	fileGrouping jc.FileGrouping
+	// fileMode is the mode of written files, as described in jc.WriteFile
+	fileMode os.FileMode
+
+	// leaseServer and leaseServerUsername are passed to ci-operator in jobs
+	// for tests which need leases, together with the password read from the
+	// `password` key of the leaseServerCredentialsSecret secret
+	leaseServer                  string
+	leaseServerUsername          string
+	leaseServerCredentialsSecret string
+
+	// prowConfig is the path to the Prow configuration with which the
+	// generated jobs are validated before they are written, when set
+	prowConfig string
+
+	// buildCluster is the alias of the cluster generated jobs run in, which
+	// selects the path at which the pullSecret secret is mounted from
+	// pullSecretPaths; no pull secret is mounted when the cluster has no path
+	buildCluster    string
+	pullSecret      string
+	pullSecretPaths map[string]string
+
+	// clusterTypeLabel is the key of the label holding the cluster type
+	// presubmits for tests run on, which is the CLUSTER_TYPE of the test,
+	// when set; the label is not set on jobs without a cluster type
+	clusterTypeLabel string
+	// jobLabels are set on all generated jobs, except for the labels which
+	// are already set by the generator or by the overrides of the repository
+	jobLabels map[string]string
+
+	// readProwgenConfigs makes the generator read per-repository settings
+	// from the directories holding the ci-operator configuration files
+	readProwgenConfigs bool
+}
+
+// artifactDirArg returns the directory where ci-operator should put artifacts,
+// defaulting to the one exposed by Prow decoration
+func (o *generatorOptions) artifactDirArg() string {
+	if o.artifactDir == "" {
+		return defaultArtifactDir
+	}
+	return o.artifactDir
+}
+
+// configSpecEnvName returns the name of the environment variable holding
+// the ci-operator configuration, defaulting to the one ci-operator reads
+func (o *generatorOptions) configSpecEnvName() string {
+	if o.configSpecEnv == "" {
+		return defaultConfigSpecEnv
+	}
+	return o.configSpecEnv
+}
+
+// ciOperatorImageName returns the image generated jobs run ci-operator from
+func (o *generatorOptions) ciOperatorImageName() string {
+	if o.ciOperatorImage == "" {
+		return defaultCiOperatorImage
+	}
+	return o.ciOperatorImage
+}
+
+// ciOperatorPullPolicy returns the pull policy of the ci-operator image
+func (o *generatorOptions) ciOperatorPullPolicy() kubeapi.PullPolicy {
+	if o.ciOperatorImagePullPolicy == "" {
+		return defaultCiOperatorImagePullPolicy
+	}
+	return o.ciOperatorImagePullPolicy
+}
+
+// decorationConfig returns the decoration config for generated jobs. Cloning is
+// always skipped because ci-operator clones the source code itself.
+func (o *generatorOptions) decorationConfig() *v1.DecorationConfig {
+	newTrue := true
+	decoration := &v1.DecorationConfig{SkipCloning: &newTrue}
+	if o.decorationTimeout != 0 {
+		decoration.Timeout = &v1.Duration{Duration: o.decorationTimeout}
+	}
+	if o.decorationGracePeriod != 0 {
+		decoration.GracePeriod = &v1.Duration{Duration: o.decorationGracePeriod}
+	}
+	if o.utilityImages != (v1.UtilityImages{}) {
+		utilityImages := o.utilityImages
+		decoration.UtilityImages = &utilityImages
+	}
+	return decoration
+}
+
+// jobInfo returns the information from which jobs generated for the
+// configuration described by `info` are named and set up, after applying
+// the branch aliases and organization remaps
+func (o *generatorOptions) jobInfo(info *config.Info) *config.Info {
+	jobInfo := *info
+	if alias, ok := o.branchAliases[fmt.Sprintf("%s/%s", info.Org, info.Repo)][info.Branch]; ok {
+		jobInfo.Branch = alias
+	}
+	if org, ok := o.orgRemaps[info.Org]; ok {
+		jobInfo.Org = org
+	}
+	return &jobInfo
+}
+
+// pullSecretPath returns the path at which jobs mount the pull secret in the
+// build cluster, or an empty string if they do not mount it
+func (o *generatorOptions) pullSecretPath() string {
+	if o.buildCluster == "" {
+		return ""
+	}
+	return o.pullSecretPaths[o.buildCluster]
+}
+
+// parsePullSecretPaths parses pull secret paths in the CLUSTER=PATH format
+func parsePullSecretPaths(values []string) (map[string]string, error) {
+	paths := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || !filepath.IsAbs(parts[1]) {
+			return nil, fmt.Errorf("invalid pull secret path %q, expected CLUSTER=PATH with an absolute PATH", value)
+		}
+		if existing, ok := paths[parts[0]]; ok && existing != parts[1] {
+			return nil, fmt.Errorf("conflicting pull secret paths for cluster %s: %s and %s", parts[0], existing, parts[1])
+		}
+		paths[parts[0]] = parts[1]
+	}
+	return paths, nil
+}
+
+// parseJobLabels parses labels in the KEY=VALUE format, which cannot use the
+// keys of the labels the generator sets itself
+func parseJobLabels(values []string, clusterTypeLabel string) (map[string]string, error) {
+	reserved := sets.NewString(jc.ProwJobLabelGenerated, prowJobLabelVariant)
+	if clusterTypeLabel != "" {
+		reserved.Insert(clusterTypeLabel)
+	}
+	labels := map[string]string{}
+	for _, value := range values {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid job label %q, expected KEY=VALUE", value)
+		}
+		if errs := append(validation.IsQualifiedName(parts[0]), validation.IsValidLabelValue(parts[1])...); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid job label %q: %s", value, strings.Join(errs, ", "))
+		}
+		if reserved.Has(parts[0]) {
+			return nil, fmt.Errorf("invalid job label %q: %s is set by the generator", value, parts[0])
+		}
+		if existing, ok := labels[parts[0]]; ok && existing != parts[1] {
+			return nil, fmt.Errorf("conflicting values for job label %s: %s and %s", parts[0], existing, parts[1])
+		}
+		labels[parts[0]] = parts[1]
+	}
+	return labels, nil
+}
+
+// parseOrgRemaps parses remaps in the FROM=TO format
+func parseOrgRemaps(values []string) (map[string]string, error) {
+	remaps := map[string]string{}
+	for _, value := range values {
+		orgs := strings.Split(value, "=")
+		if len(orgs) != 2 || orgs[0] == "" || orgs[1] == "" || strings.Contains(value, "/") {
+			return nil, fmt.Errorf("invalid organization remap %q, expected FROM=TO", value)
+		}
+		if existing, ok := remaps[orgs[0]]; ok && existing != orgs[1] {
+			return nil, fmt.Errorf("conflicting remaps for organization %s: %s and %s", orgs[0], existing, orgs[1])
+		}
+		remaps[orgs[0]] = orgs[1]
+	}
+	return remaps, nil
+}
+
+// parseBranchAliases parses aliases in the ORG/REPO:OLD=NEW format
+func parseBranchAliases(values []string) (map[string]map[string]string, error) {
+	aliases := map[string]map[string]string{}
+	for _, value := range values {
+		orgRepo, mapping := value, ""
+		if i := strings.Index(value, ":"); i != -1 {
+			orgRepo, mapping = value[:i], value[i+1:]
+		}
+		branches := strings.Split(mapping, "=")
+		if strings.Count(orgRepo, "/") != 1 || len(branches) != 2 || branches[0] == "" || branches[1] == "" {
+			return nil, fmt.Errorf("invalid branch alias %q, expected ORG/REPO:OLD=NEW", value)
+		}
+		if aliases[orgRepo] == nil {
+			aliases[orgRepo] = map[string]string{}
+		}
+		if existing, ok := aliases[orgRepo][branches[0]]; ok && existing != branches[1] {
+			return nil, fmt.Errorf("conflicting aliases for branch %s of %s: %s and %s", branches[0], orgRepo, existing, branches[1])
+		}
+		aliases[orgRepo][branches[0]] = branches[1]
+	}
+	return aliases, nil
+}
+
 func bindOptions(flag *flag.FlagSet) *options {
 	opt := &options{}
 
 	flag.StringVar(&opt.fromFile, "from-file", "", "Path to a ci-operator configuration file")
 	flag.StringVar(&opt.fromDir, "from-dir", "", "Path to a directory with a directory structure holding ci-operator configuration files for multiple components")
+	flag.StringVar(&opt.fromConfigMap, "from-configmap", "", "Path to a ConfigMap manifest holding ci-operator configuration files under ORG-REPO-BRANCH.yaml keys")
 	flag.BoolVar(&opt.fromReleaseRepo, "from-release-repo", false, "If set, it behaves like --from-dir=$GOPATH/src/github.com/openshift/release/ci-operator/config")
 
 	flag.StringVar(&opt.toDir, "to-dir", "", "Path to a directory with a directory structure holding Prow job configuration files for multiple components")
 	flag.BoolVar(&opt.toReleaseRepo, "to-release-repo", false, "If set, it behaves like --to-dir=$GOPATH/src/github.com/openshift/release/ci-operator/jobs")
 
+	flag.StringVar(&opt.toRequiredContexts, "to-required-contexts", "", "If set, write the branch protection contexts required by the generated presubmits to this file")
+	flag.StringVar(&opt.toSummary, "to-summary", "", "If set, write the counts of generated jobs by type and of repositories they were generated for to this file as JSON")
+
+	flag.StringVar(&opt.fileMode, "file-mode", "", "If set, written files get this octal mode (e.g. 0644) regardless of the umask and of their previous mode")
+
+	flag.BoolVar(&opt.kustomize, "kustomize", false, "If set, maintain a kustomization.yaml in every directory of the output, generating a ConfigMap from the job files and listing the subdirectories in it")
+
+	flag.BoolVar(&opt.tarStream, "tar-stream", false, "If set, read ci-operator configuration files as a tar stream from stdin and write the generated Prow job configuration files as a tar stream to stdout")
+
+	flag.BoolVar(&opt.validateNotEmpty, "validate-not-empty", false, "If set, do not write any jobs, but fail when no jobs are generated for some ci-operator configuration file")
+
+	flag.BoolVar(&opt.listOrphans, "list-orphans", false, "If set, do not write any jobs, but list generated jobs in the --to-* directory which are no longer generated from any ci-operator configuration file")
+
+	flag.StringVar(&opt.diffSince, "diff-since", "", "If set, do not write any jobs, but print how the job files in the --to-* directory would change for repositories whose ci-operator configuration files in the --from-* directory changed since this git revision, including uncommitted changes")
+
+	flag.BoolVar(&opt.dryRun, "dry-run", false, "If set, do not write any jobs, but print a unified diff of the job files in the --to-* directory and the ones that would be written and fail when they differ")
+
+	flag.IntVar(&opt.parallel, "parallel", 1, "Number of ci-operator configuration files processed concurrently when generating jobs from a directory")
+
+	flag.Var(&opt.branchAliases, "branch-alias", "Alias in the ORG/REPO:OLD=NEW format: jobs generated from configuration for the OLD branch of ORG/REPO will target the NEW branch instead. Can be passed multiple times")
+
+	flag.Var(&opt.orgRemaps, "org-remap", "Remap in the FROM=TO format: jobs generated from configuration for repositories in the FROM organization will be set up for the same repositories in the TO organization. Can be passed multiple times")
+
+	flag.DurationVar(&opt.generator.decorationTimeout, "decoration-timeout", 0, "If set, generated jobs are aborted after running for this long instead of the global Prow default")
+	flag.DurationVar(&opt.generator.decorationGracePeriod, "decoration-grace-period", 0, "If set, generated jobs are killed this long after being aborted instead of the global Prow default")
+
+	flag.StringVar(&opt.generator.utilityImages.CloneRefs, "clonerefs-image", "", "If set, generated jobs use this clonerefs image instead of the global Prow default; --clonerefs-image, --initupload-image, --entrypoint-image and --sidecar-image need to be passed together")
+	flag.StringVar(&opt.generator.utilityImages.InitUpload, "initupload-image", "", "If set, generated jobs use this initupload image instead of the global Prow default; --clonerefs-image, --initupload-image, --entrypoint-image and --sidecar-image need to be passed together")
+	flag.StringVar(&opt.generator.utilityImages.Entrypoint, "entrypoint-image", "", "If set, generated jobs use this entrypoint image instead of the global Prow default; --clonerefs-image, --initupload-image, --entrypoint-image and --sidecar-image need to be passed together")
+	flag.StringVar(&opt.generator.utilityImages.Sidecar, "sidecar-image", "", "If set, generated jobs use this sidecar image instead of the global Prow default; --clonerefs-image, --initupload-image, --entrypoint-image and --sidecar-image need to be passed together")
+
+	flag.StringVar(&opt.generator.artifactDir, "artifact-dir", defaultArtifactDir, "Directory where ci-operator in generated jobs puts artifacts")
+	flag.StringVar((*string)(&opt.generator.fileGrouping), "job-file-grouping", string(jc.GroupByBranch), "How generated jobs are sharded into files: 'branch' for ORG-REPO-BRANCH-TYPE.yaml, 'repo' for ORG-REPO-TYPE.yaml")
+	flag.BoolVar(&opt.generator.strictNames, "strict-names", false, "If set, fail when a generated job name is longer than 63 characters instead of warning")
+	flag.StringVar(&opt.generator.ciOperatorImage, "ci-operator-image", defaultCiOperatorImage, "Image generated jobs run ci-operator from, e.g. one mirrored to a different registry")
+	flag.StringVar((*string)(&opt.generator.ciOperatorImagePullPolicy), "ci-operator-image-pull-policy", string(defaultCiOperatorImagePullPolicy), "Pull policy of the ci-operator image in generated jobs: Always, IfNotPresent or Never")
+	flag.Var(&opt.imagePullSecrets, "image-pull-secret", "Name of a secret that generated jobs use to pull the ci-operator image. Can be passed multiple times")
+	flag.StringVar(&opt.generator.leaseServer, "lease-server", "", "Address of the lease server passed to ci-operator in jobs for tests which need leases")
+	flag.StringVar(&opt.generator.leaseServerUsername, "lease-server-username", "", "Username for the lease server passed to ci-operator in jobs for tests which need leases")
+	flag.StringVar(&opt.generator.leaseServerCredentialsSecret, "lease-server-credentials-secret", "", "Name of a secret holding the lease server password under the `password` key, mounted in jobs for tests which need leases")
+	flag.StringVar(&opt.generator.prowConfig, "validate-with-prow-config", "", "If set, check the generated jobs the way Prow does when loading them with the Prow configuration at this path and fail without writing any jobs if Prow would reject some")
+	flag.StringVar(&opt.generator.buildCluster, "build-cluster", "", "If set, generated jobs run in the build cluster with this alias instead of the default one")
+	flag.Var(&opt.pullSecretPaths, "pull-secret-path", "Path in the CLUSTER=PATH format: jobs generated with --build-cluster=CLUSTER mount the --pull-secret secret at PATH. Can be passed multiple times")
+	flag.StringVar(&opt.generator.pullSecret, "pull-secret", defaultPullSecretName, "Name of the secret mounted in generated jobs when --pull-secret-path has a path for the --build-cluster")
+	flag.StringVar(&opt.generator.clusterTypeLabel, "cluster-type-label", "", "If set, presubmits for tests running on a cluster get a label with this key and the cluster type (e.g. aws) as the value")
+	flag.Var(&opt.jobLabels, "job-label", "Label in the KEY=VALUE format set on all generated jobs, e.g. for cost attribution. Labels set by the generator or by job overrides are not replaced. Can be passed multiple times")
+	flag.StringVar(&opt.generator.configSpecEnv, "config-spec-env", defaultConfigSpecEnv, "Name of the environment variable through which generated jobs pass the ci-operator configuration")
+	flag.StringVar(&opt.keyFormat, "config-map-key-format", config.DefaultConfigMapKeyFormat, "Go template for the keys under which ci-operator config files are stored in ConfigMaps, executed with the org, repo, branch and variant")
+
+	flag.StringVar(&opt.defaultsFile, "defaults-file", os.Getenv(defaultsFileEnv), fmt.Sprintf("Path to a YAML file mapping option names to values used when the options are not passed (defaults to $%s)", defaultsFileEnv))
+
 	flag.BoolVar(&opt.help, "h", false, "Show help for ci-operator-prowgen")
 
 	return opt
@@ -58,6 +394,76 @@ func bindOptions(flag *flag.FlagSet) *options {
 func (o *options) process() error {
 	var err error
 
+	if o.generator.branchAliases, err = parseBranchAliases(o.branchAliases.Strings()); err != nil {
+		return err
+	}
+	if o.generator.orgRemaps, err = parseOrgRemaps(o.orgRemaps.Strings()); err != nil {
+		return err
+	}
+	o.generator.imagePullSecrets = o.imagePullSecrets.Strings()
+	if o.generator.pullSecretPaths, err = parsePullSecretPaths(o.pullSecretPaths.Strings()); err != nil {
+		return err
+	}
+	if o.generator.pullSecret == "" {
+		return fmt.Errorf("`--pull-secret` cannot be empty")
+	}
+	if o.generator.configSpecEnv == "" {
+		return fmt.Errorf("`--config-spec-env` cannot be empty")
+	}
+	if o.generator.ciOperatorImage == "" {
+		return fmt.Errorf("`--ci-operator-image` cannot be empty")
+	}
+	switch o.generator.ciOperatorImagePullPolicy {
+	case kubeapi.PullAlways, kubeapi.PullIfNotPresent, kubeapi.PullNever:
+	default:
+		return fmt.Errorf("`--ci-operator-image-pull-policy` must be one of %s, %s or %s, got %q", kubeapi.PullAlways, kubeapi.PullIfNotPresent, kubeapi.PullNever, o.generator.ciOperatorImagePullPolicy)
+	}
+	if o.generator.keyFormat, err = config.NewConfigMapKeyFormat(o.keyFormat); err != nil {
+		return fmt.Errorf("invalid `--config-map-key-format`: %v", err)
+	}
+	if label := o.generator.clusterTypeLabel; label != "" {
+		if errs := validation.IsQualifiedName(label); len(errs) > 0 {
+			return fmt.Errorf("`--cluster-type-label` must be a valid label key: %s", strings.Join(errs, ", "))
+		}
+	}
+	if o.generator.jobLabels, err = parseJobLabels(o.jobLabels.Strings(), o.generator.clusterTypeLabel); err != nil {
+		return err
+	}
+	if o.generator.artifactDir == "" {
+		return fmt.Errorf("`--artifact-dir` cannot be empty")
+	}
+	if o.generator.fileGrouping != jc.GroupByBranch && o.generator.fileGrouping != jc.GroupByRepo {
+		return fmt.Errorf("`--job-file-grouping` must be either %q or %q", jc.GroupByBranch, jc.GroupByRepo)
+	}
+	if o.generator.decorationTimeout < 0 || o.generator.decorationGracePeriod < 0 {
+		return fmt.Errorf("`--decoration-timeout` and `--decoration-grace-period` cannot be negative")
+	}
+	if images := o.generator.utilityImages; images != (v1.UtilityImages{}) &&
+		(images.CloneRefs == "" || images.InitUpload == "" || images.Entrypoint == "" || images.Sidecar == "") {
+		return fmt.Errorf("`--clonerefs-image`, `--initupload-image`, `--entrypoint-image` and `--sidecar-image` need to be passed together")
+	}
+	if o.parallel < 1 {
+		return fmt.Errorf("`--parallel` must be at least 1")
+	}
+	if o.generator.leaseServer != "" && (o.generator.leaseServerUsername == "" || o.generator.leaseServerCredentialsSecret == "") ||
+		o.generator.leaseServer == "" && (o.generator.leaseServerUsername != "" || o.generator.leaseServerCredentialsSecret != "") {
+		return fmt.Errorf("`--lease-server`, `--lease-server-username` and `--lease-server-credentials-secret` need to be passed together")
+	}
+	if o.fileMode != "" {
+		mode, err := strconv.ParseUint(o.fileMode, 8, 32)
+		if err != nil || mode == 0 || os.FileMode(mode)&^os.ModePerm != 0 {
+			return fmt.Errorf("`--file-mode` must be an octal permission mode like 0644, got %q", o.fileMode)
+		}
+		o.generator.fileMode = os.FileMode(mode)
+	}
+
+	if o.tarStream {
+		if o.fromFile != "" || o.fromDir != "" || o.fromConfigMap != "" || o.fromReleaseRepo || o.toDir != "" || o.toReleaseRepo || o.validateNotEmpty || o.listOrphans || o.diffSince != "" || o.dryRun {
+			return fmt.Errorf("`--tar-stream` cannot be combined with `--from-*`, `--to-{dir,release-repo}`, `--validate-not-empty`, `--list-orphans`, `--diff-since` and `--dry-run` options")
+		}
+		return nil
+	}
+
 	if o.fromReleaseRepo {
 		if o.fromDir, err = getReleaseRepoDir("ci-operator/config"); err != nil {
 			return fmt.Errorf("--from-release-repo error: %v", err)
@@ -70,21 +476,63 @@ func (o *options) process() error {
 		}
 	}
 
-	if (o.fromFile == "" && o.fromDir == "") || (o.fromFile != "" && o.fromDir != "") {
-		return fmt.Errorf("ci-operator-prowgen needs exactly one of `--from-{file,dir,release-repo}` options")
+	sources := 0
+	for _, source := range []string{o.fromFile, o.fromDir, o.fromConfigMap} {
+		if source != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("ci-operator-prowgen needs exactly one of `--from-{file,dir,configmap,release-repo}` options")
 	}
 
-	if o.toDir == "" {
+	if o.validateNotEmpty && o.listOrphans {
+		return fmt.Errorf("`--validate-not-empty` and `--list-orphans` cannot be combined")
+	}
+	if o.diffSince != "" {
+		if o.fromDir == "" {
+			return fmt.Errorf("`--diff-since` needs one of `--from-{dir,release-repo}` options")
+		}
+		if o.validateNotEmpty || o.listOrphans || o.kustomize || o.toRequiredContexts != "" || o.toSummary != "" {
+			return fmt.Errorf("`--diff-since` cannot be combined with `--validate-not-empty`, `--list-orphans`, `--kustomize`, `--to-required-contexts` and `--to-summary`")
+		}
+	}
+	if o.dryRun && (o.validateNotEmpty || o.listOrphans || o.diffSince != "" || o.kustomize || o.toRequiredContexts != "" || o.toSummary != "") {
+		return fmt.Errorf("`--dry-run` cannot be combined with `--validate-not-empty`, `--list-orphans`, `--diff-since`, `--kustomize`, `--to-required-contexts` and `--to-summary`")
+	}
+	if o.listOrphans && (o.toRequiredContexts != "" || o.toSummary != "") {
+		return fmt.Errorf("`--list-orphans` cannot be combined with `--to-required-contexts` and `--to-summary`")
+	}
+	if o.validateNotEmpty {
+		if o.toDir != "" || o.toRequiredContexts != "" || o.toSummary != "" {
+			return fmt.Errorf("`--validate-not-empty` cannot be combined with `--to-*` options")
+		}
+	} else if o.toDir == "" {
 		return fmt.Errorf("ci-operator-prowgen needs exactly one of `--to-{dir,release-repo}` options")
 	}
 
+	// ConfigMap manifests do not come with the repository directories
+	o.generator.readProwgenConfigs = o.fromConfigMap == ""
+
 	return nil
 }
 
+// operateOnCIOperatorConfigs runs the callback on the ci-operator configuration
+// files from the `--from-*` source
+func (o *options) operateOnCIOperatorConfigs(callback func(*cioperatorapi.ReleaseBuildConfiguration, *config.Info) error) error {
+	if len(o.fromFile) > 0 {
+		return config.OperateOnCIOperatorConfig(o.fromFile, callback)
+	}
+	if len(o.fromConfigMap) > 0 {
+		return config.OperateOnCIOperatorConfigMap(o.fromConfigMap, callback)
+	}
+	return config.OperateOnCIOperatorConfigDirParallel(o.fromDir, o.parallel, callback)
+}
+
 // Generate a PodSpec that runs `ci-operator`, to be used in Presubmit/Postsubmit
 // Various pieces are derived from `org`, `repo`, `branch` and `target`.
 // `additionalArgs` are passed as additional arguments to `ci-operator`
-func generatePodSpec(info *config.Info, target string, additionalArgs ...string) *kubeapi.PodSpec {
+func generatePodSpec(info *config.Info, target string, opts *generatorOptions, additionalArgs ...string) *kubeapi.PodSpec {
 	for _, arg := range additionalArgs {
 		if !strings.HasPrefix(arg, "--") {
 			panic(fmt.Sprintf("all args to ci-operator must be in the form --flag=value, not %s", arg))
@@ -96,26 +544,32 @@ func generatePodSpec(info *config.Info, target string, additionalArgs ...string)
 			LocalObjectReference: kubeapi.LocalObjectReference{
 				Name: info.ConfigMapName(),
 			},
-			Key: info.Basename(),
+			Key: opts.keyFormat.Key(info),
 		},
 	}
 
-	return &kubeapi.PodSpec{
+	var imagePullSecrets []kubeapi.LocalObjectReference
+	for _, secret := range opts.imagePullSecrets {
+		imagePullSecrets = append(imagePullSecrets, kubeapi.LocalObjectReference{Name: secret})
+	}
+
+	podSpec := &kubeapi.PodSpec{
 		ServiceAccountName: "ci-operator",
+		ImagePullSecrets:   imagePullSecrets,
 		Containers: []kubeapi.Container{
 			{
-				Image:           "ci-operator:latest",
-				ImagePullPolicy: kubeapi.PullAlways,
+				Image:           opts.ciOperatorImageName(),
+				ImagePullPolicy: opts.ciOperatorPullPolicy(),
 				Command:         []string{"ci-operator"},
 				Args: append([]string{
-					"--give-pr-author-access-to-namespace=true",
-					"--artifact-dir=$(ARTIFACTS)",
+					fmt.Sprintf("%s=true", prAuthorAccessArg),
+					fmt.Sprintf("--artifact-dir=%s", opts.artifactDirArg()),
 					fmt.Sprintf("--target=%s", target),
 					fmt.Sprintf("--sentry-dsn-path=%s", sentryDsnSecretPath),
 				}, additionalArgs...),
-				Env: []kubeapi.EnvVar{{Name: "CONFIG_SPEC", ValueFrom: &configMapKeyRef}},
+				Env: []kubeapi.EnvVar{{Name: opts.configSpecEnvName(), ValueFrom: &configMapKeyRef}},
 				Resources: kubeapi.ResourceRequirements{
-					Requests: kubeapi.ResourceList{"cpu": *resource.NewMilliQuantity(10, resource.DecimalSI)},
+					Requests: config.DefaultResourceRequests(),
 				},
 				VolumeMounts: []kubeapi.VolumeMount{{
 					Name:      sentryDsnMountName,
@@ -131,9 +585,26 @@ func generatePodSpec(info *config.Info, target string, additionalArgs ...string)
 			},
 		}},
 	}
+	if path := opts.pullSecretPath(); path != "" {
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, kubeapi.VolumeMount{
+			Name:      pullSecretMountName,
+			MountPath: path,
+			ReadOnly:  true,
+		})
+		podSpec.Volumes = append(podSpec.Volumes, kubeapi.Volume{
+			Name: pullSecretMountName,
+			VolumeSource: kubeapi.VolumeSource{
+				Secret: &kubeapi.SecretVolumeSource{SecretName: opts.pullSecret},
+			},
+		})
+	}
+	return podSpec
 }
 
-func generatePodSpecTemplate(info *config.Info, release string, test *cioperatorapi.TestStepConfiguration, additionalArgs ...string) *kubeapi.PodSpec {
+// generatePodSpecTemplate generates a PodSpec that runs ci-operator for a test
+// using a template. When `clusterProfileOverride` is set, it replaces the cluster
+// profile from the test configuration.
+func generatePodSpecTemplate(info *config.Info, release string, test *cioperatorapi.TestStepConfiguration, clusterProfileOverride cioperatorapi.ClusterProfile, opts *generatorOptions, additionalArgs ...string) *kubeapi.PodSpec {
 	var template string
 	var clusterProfile cioperatorapi.ClusterProfile
 	var needsReleaseRpms bool
@@ -172,6 +643,9 @@ func generatePodSpecTemplate(info *config.Info, release string, test *cioperator
 		template = "cluster-launch-installer-console"
 		clusterProfile = conf.ClusterProfile
 	}
+	if clusterProfile != "" && clusterProfileOverride != "" {
+		clusterProfile = clusterProfileOverride
+	}
 	var targetCloud string
 	switch clusterProfile {
 	case cioperatorapi.ClusterProfileAWS, cioperatorapi.ClusterProfileAWSAtomic, cioperatorapi.ClusterProfileAWSCentos, cioperatorapi.ClusterProfileAWSCentos40, cioperatorapi.ClusterProfileAWSGluster:
@@ -189,7 +663,7 @@ func generatePodSpecTemplate(info *config.Info, release string, test *cioperator
 	}
 	clusterProfilePath := fmt.Sprintf("/usr/local/%s-cluster-profile", test.As)
 	templatePath := fmt.Sprintf("/usr/local/%s", test.As)
-	podSpec := generatePodSpec(info, test.As, additionalArgs...)
+	podSpec := generatePodSpec(info, test.As, opts, additionalArgs...)
 	clusterProfileVolume := kubeapi.Volume{
 		Name: "cluster-profile",
 		VolumeSource: kubeapi.VolumeSource{
@@ -277,49 +751,248 @@ func generatePodSpecTemplate(info *config.Info, release string, test *cioperator
 	return podSpec
 }
 
-func generatePresubmitForTest(name string, info *config.Info, podSpec *kubeapi.PodSpec) *prowconfig.Presubmit {
+// variantTestName returns the name of a test used in names and contexts of
+// jobs generated from a configuration file for the variant, which prefixes
+// the test name unless `suffix` is set
+func variantTestName(name, variant string, suffix bool) string {
+	if suffix {
+		return fmt.Sprintf("%s-%s", name, variant)
+	}
+	return fmt.Sprintf("%s-%s", variant, name)
+}
+
+func generatePresubmitForTest(name string, info *config.Info, variantSuffix bool, podSpec *kubeapi.PodSpec, opts *generatorOptions) *prowconfig.Presubmit {
 	labels := map[string]string{jc.ProwJobLabelGenerated: jc.Generated}
 
-	jobPrefix := fmt.Sprintf("pull-ci-%s-%s-%s-", info.Org, info.Repo, info.Branch)
 	if len(info.Variant) > 0 {
-		name = fmt.Sprintf("%s-%s", info.Variant, name)
+		name = variantTestName(name, info.Variant, variantSuffix)
 		labels[prowJobLabelVariant] = info.Variant
 	}
-	jobName := fmt.Sprintf("%s%s", jobPrefix, name)
-	if len(jobName) > 63 && len(jobPrefix) < 53 {
-		// warn if the prefix gives people enough space to choose names and they've chosen something long
-		logrus.WithField("name", jobName).Warn("Generated job name is longer than 63 characters. This may cause issues when Prow attempts to label resources with job name. Consider a shorter name.")
-	}
-
-	newTrue := true
+	jobName := jc.PresubmitName(info.Org, info.Repo, branchToken(info), name)
+	jc.WarnLongJobName(jobName, name)
 
 	return &prowconfig.Presubmit{
 		JobBase: prowconfig.JobBase{
-			Agent:  "kubernetes",
-			Labels: labels,
-			Name:   jobName,
-			Spec:   podSpec,
+			Agent:   "kubernetes",
+			Cluster: opts.buildCluster,
+			Labels:  labels,
+			Name:    jobName,
+			Spec:    podSpec,
 			UtilityConfig: prowconfig.UtilityConfig{
-				DecorationConfig: &v1.DecorationConfig{SkipCloning: &newTrue},
+				DecorationConfig: opts.decorationConfig(),
 				Decorate:         true,
 			},
 		},
 		AlwaysRun: true,
-		Brancher:  prowconfig.Brancher{Branches: []string{info.Branch}},
+		Brancher:  prowconfig.Brancher{Branches: []string{info.BranchRegex()}},
 		Reporter: prowconfig.Reporter{
 			Context: fmt.Sprintf("ci/prow/%s", name),
 		},
 		RerunCommand: prowconfig.DefaultRerunCommandFor(name),
-		Trigger:      prowconfig.DefaultTriggerFor(name),
+		Trigger:      jc.TriggerFor(name),
+	}
+}
+
+// branchToken returns the branch of the configuration file as used in the
+// names of generated jobs. Globs are stripped of the characters which are not
+// allowed in names, the same way as regular expressions in `branches`.
+func branchToken(info *config.Info) string {
+	if !info.IsBranchGlob() {
+		return info.Branch
+	}
+	return jc.MakeRegexFilenameLabel(info.Branch)
+}
+
+// generatePeriodicForTest generates a periodic running the test on the given
+// schedule. The periodic has no refs to clone from the event that triggered
+// it, so the branch it tests is passed to ci-operator as an extra ref.
+func generatePeriodicForTest(name string, info *config.Info, variantSuffix bool, cron, interval string, podSpec *kubeapi.PodSpec, opts *generatorOptions) *prowconfig.Periodic {
+	labels := map[string]string{jc.ProwJobLabelGenerated: jc.Generated}
+
+	if len(info.Variant) > 0 {
+		name = variantTestName(name, info.Variant, variantSuffix)
+		labels[prowJobLabelVariant] = info.Variant
+	}
+	jobName := jc.PeriodicName(info.Org, info.Repo, branchToken(info), name)
+	jc.WarnLongJobName(jobName, name)
+
+	return &prowconfig.Periodic{
+		JobBase: prowconfig.JobBase{
+			Agent:   "kubernetes",
+			Cluster: opts.buildCluster,
+			Labels:  labels,
+			Name:    jobName,
+			Spec:    podSpec,
+			UtilityConfig: prowconfig.UtilityConfig{
+				DecorationConfig: opts.decorationConfig(),
+				Decorate:         true,
+				ExtraRefs:        []v1.Refs{{Org: info.Org, Repo: info.Repo, BaseRef: info.Branch}},
+			},
+		},
+		Cron:     cron,
+		Interval: interval,
+	}
+}
+
+// applyAlwaysRunPolicy sets `always_run` of the presubmit when the repository
+// has a policy for it, and marks the presubmit so that the value replaces the
+// one in the existing job file
+func applyAlwaysRunPolicy(presubmit *prowconfig.Presubmit, policy *bool) {
+	if policy == nil {
+		return
+	}
+	presubmit.AlwaysRun = *policy
+	markAlwaysRunPolicy(presubmit)
+}
+
+// markAlwaysRunPolicy marks the presubmit so that its `always_run` and
+// `run_if_changed` replace the ones in the existing job file
+func markAlwaysRunPolicy(presubmit *prowconfig.Presubmit) {
+	if presubmit.Annotations == nil {
+		presubmit.Annotations = map[string]string{}
+	}
+	presubmit.Annotations[jc.ProwJobAnnotationAlwaysRunPolicy] = "true"
+}
+
+// applyRunIfChanged makes a presubmit run only on PRs changing files matching
+// `regex`, when set, and marks the presubmit like applyAlwaysRunPolicy so that
+// the values replace the ones in the existing job file
+func applyRunIfChanged(presubmit *prowconfig.Presubmit, regex string) {
+	if regex == "" {
+		return
+	}
+	presubmit.AlwaysRun = false
+	presubmit.RunIfChanged = regex
+	markAlwaysRunPolicy(presubmit)
+}
+
+// applyScheduling places the pods of a job on the nodes selected by the
+// repository settings
+func applyScheduling(podSpec *kubeapi.PodSpec, scheduling config.Scheduling) {
+	podSpec.NodeSelector = scheduling.NodeSelector
+	podSpec.Tolerations = scheduling.Tolerations
+}
+
+// applyResources replaces the requests and limits of the ci-operator container
+// in the pod with the ones in `resources`, keeping the defaults for the others
+func applyResources(podSpec *kubeapi.PodSpec, resources kubeapi.ResourceRequirements) {
+	container := &podSpec.Containers[0]
+	for name, quantity := range resources.Requests {
+		if container.Resources.Requests == nil {
+			container.Resources.Requests = kubeapi.ResourceList{}
+		}
+		container.Resources.Requests[name] = quantity
+	}
+	for name, quantity := range resources.Limits {
+		if container.Resources.Limits == nil {
+			container.Resources.Limits = kubeapi.ResourceList{}
+		}
+		container.Resources.Limits[name] = quantity
+	}
+}
+
+// applyLeases makes ci-operator in the pod able to acquire leases from the
+// lease server: the password is mounted from the credentials secret and the
+// lease server options are appended to the ci-operator arguments
+func applyLeases(podSpec *kubeapi.PodSpec, opts *generatorOptions) {
+	container := &podSpec.Containers[0]
+	container.Args = append(container.Args,
+		fmt.Sprintf("--lease-server=%s", opts.leaseServer),
+		fmt.Sprintf("--lease-server-username=%s", opts.leaseServerUsername),
+		fmt.Sprintf("--lease-server-password-file=%s", leaseServerPasswordPath),
+	)
+	container.VolumeMounts = append(container.VolumeMounts, kubeapi.VolumeMount{
+		Name:      leaseServerCredentialsMountName,
+		MountPath: leaseServerCredentialsMountPath,
+		ReadOnly:  true,
+	})
+	podSpec.Volumes = append(podSpec.Volumes, kubeapi.Volume{
+		Name: leaseServerCredentialsMountName,
+		VolumeSource: kubeapi.VolumeSource{
+			Secret: &kubeapi.SecretVolumeSource{SecretName: opts.leaseServerCredentialsSecret},
+		},
+	})
+}
+
+// applyPRAuthorAccess makes ci-operator in the pod of a presubmit not give
+// the author of the PR access to the test namespace when `access` is not set
+func applyPRAuthorAccess(podSpec *kubeapi.PodSpec, access bool) {
+	if access {
+		return
+	}
+	args := podSpec.Containers[0].Args
+	for i := range args {
+		if strings.HasPrefix(args[i], prAuthorAccessArg+"=") {
+			args[i] = fmt.Sprintf("%s=false", prAuthorAccessArg)
+		}
+	}
+}
+
+// applyClusterTypeLabel labels a job with the cluster type its test runs on,
+// which is passed to ci-operator as CLUSTER_TYPE, when the label is enabled
+// in `opts`, so that the job can be routed by the label
+func applyClusterTypeLabel(job *prowconfig.JobBase, opts *generatorOptions) {
+	if opts.clusterTypeLabel == "" {
+		return
+	}
+	for _, env := range job.Spec.Containers[0].Env {
+		if env.Name == "CLUSTER_TYPE" && env.Value != "" {
+			job.Labels[opts.clusterTypeLabel] = env.Value
+		}
+	}
+}
+
+// applyJobLabels sets the labels from `opts` on all jobs, keeping the values
+// of labels the jobs already have
+func applyJobLabels(jobConfig *prowconfig.JobConfig, opts *generatorOptions) {
+	if len(opts.jobLabels) == 0 {
+		return
+	}
+	var jobs []*prowconfig.JobBase
+	for repo := range jobConfig.Presubmits {
+		for i := range jobConfig.Presubmits[repo] {
+			jobs = append(jobs, &jobConfig.Presubmits[repo][i].JobBase)
+		}
+	}
+	for repo := range jobConfig.Postsubmits {
+		for i := range jobConfig.Postsubmits[repo] {
+			jobs = append(jobs, &jobConfig.Postsubmits[repo][i].JobBase)
+		}
+	}
+	for i := range jobConfig.Periodics {
+		jobs = append(jobs, &jobConfig.Periodics[i].JobBase)
+	}
+	for _, job := range jobs {
+		if job.Labels == nil {
+			job.Labels = map[string]string{}
+		}
+		for key, value := range opts.jobLabels {
+			if _, set := job.Labels[key]; !set {
+				job.Labels[key] = value
+			}
+		}
+	}
+}
+
+// applyDecorationTimeouts replaces the decoration timeouts of a job with the
+// ones in the settings for its test, where set
+func applyDecorationTimeouts(job *prowconfig.JobBase, test config.ProwgenTest) {
+	if test.Timeout != nil {
+		job.DecorationConfig.Timeout = test.Timeout
+	}
+	if test.GracePeriod != nil {
+		job.DecorationConfig.GracePeriod = test.GracePeriod
 	}
 }
 
 func generatePostsubmitForTest(
 	name string,
 	info *config.Info,
+	variantSuffix bool,
 	treatBranchesAsExplicit bool,
 	labels map[string]string,
-	podSpec *kubeapi.PodSpec) *prowconfig.Postsubmit {
+	podSpec *kubeapi.PodSpec,
+	opts *generatorOptions) *prowconfig.Postsubmit {
 
 	copiedLabels := make(map[string]string)
 	for k, v := range labels {
@@ -327,33 +1000,27 @@ func generatePostsubmitForTest(
 	}
 	copiedLabels[jc.ProwJobLabelGenerated] = jc.Generated
 
-	branchName := jc.MakeRegexFilenameLabel(info.Branch)
-	jobPrefix := fmt.Sprintf("branch-ci-%s-%s-%s-", info.Org, info.Repo, branchName)
 	if len(info.Variant) > 0 {
-		name = fmt.Sprintf("%s-%s", info.Variant, name)
+		name = variantTestName(name, info.Variant, variantSuffix)
 		copiedLabels[prowJobLabelVariant] = info.Variant
 	}
-	jobName := fmt.Sprintf("%s%s", jobPrefix, name)
-	if len(jobName) > 63 && len(jobPrefix) < 53 {
-		// warn if the prefix gives people enough space to choose names and they've chosen something long
-		logrus.WithField("name", jobName).Warn("Generated job name is longer than 63 characters. This may cause issues when Prow attempts to label resources with job name. Consider a shorter name.")
-	}
+	jobName := jc.PostsubmitName(info.Org, info.Repo, branchToken(info), name)
+	jc.WarnLongJobName(jobName, name)
 
-	branch := info.Branch
+	branch := info.BranchRegex()
 	if treatBranchesAsExplicit {
 		branch = makeBranchExplicit(branch)
 	}
 
-	newTrue := true
-
 	return &prowconfig.Postsubmit{
 		JobBase: prowconfig.JobBase{
-			Agent:  "kubernetes",
-			Name:   jobName,
-			Spec:   podSpec,
-			Labels: copiedLabels,
+			Agent:   "kubernetes",
+			Cluster: opts.buildCluster,
+			Name:    jobName,
+			Spec:    podSpec,
+			Labels:  copiedLabels,
 			UtilityConfig: prowconfig.UtilityConfig{
-				DecorationConfig: &v1.DecorationConfig{SkipCloning: &newTrue},
+				DecorationConfig: opts.decorationConfig(),
 				Decorate:         true,
 			},
 		},
@@ -364,70 +1031,426 @@ func generatePostsubmitForTest(
 // Given a ci-operator configuration file and basic information about what
 // should be tested, generate a following JobConfig:
 //
-// - one presubmit for each test defined in config file
+// - one presubmit for each test defined in config file and another optional
+//   one for each test marked as optional in the repository settings in `prowgen`
+// - one periodic for each test with a schedule in the repository settings in
+//   `prowgen`
 // - if the config file has non-empty `images` section, generate an additinal
 //   presubmit and postsubmit that has `--target=[images]`. This postsubmit
-//   will additionally pass `--promote` to ci-operator
+//   is annotated with the promotion namespace and name and, when the images
+//   are promoted to an official namespace, additionally passes `--promote` to
+//   ci-operator. The presubmit is not generated when the repository settings
+//   in `prowgen` skip it
+//
+// Job names, branches and repositories are derived from the information
+// returned by `opts.jobInfo`, which differs from `info` when the branch is
+// aliased or the organization is remapped in `opts`. Jobs for configuration
+// files with a glob branch run on the branches matching it and are named
+// after its `branchToken`; no periodics are generated for them.
 func generateJobs(
-	configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info,
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions,
 ) *prowconfig.JobConfig {
 
-	orgrepo := fmt.Sprintf("%s/%s", info.Org, info.Repo)
+	jobInfo := opts.jobInfo(info)
+	orgrepo := fmt.Sprintf("%s/%s", jobInfo.Org, jobInfo.Repo)
 	presubmits := map[string][]prowconfig.Presubmit{}
 	postsubmits := map[string][]prowconfig.Postsubmit{}
+	var periodics []prowconfig.Periodic
 
-	for _, element := range configSpec.Tests {
-		var podSpec *kubeapi.PodSpec
-		if element.ContainerTestConfiguration != nil {
-			podSpec = generatePodSpec(info, element.As)
-		} else {
-			var release string
-			if c := configSpec.ReleaseTagConfiguration; c != nil {
-				release = c.Name
+	for i := range configSpec.Tests {
+		presubmits[orgrepo] = append(presubmits[orgrepo], *generateTestPresubmit(configSpec, &configSpec.Tests[i], info, prowgen, opts))
+		if prowgen.Tests[configSpec.Tests[i].As].Optional {
+			presubmits[orgrepo] = append(presubmits[orgrepo], *generateOptionalTestPresubmit(configSpec, &configSpec.Tests[i], info, prowgen, opts))
+		}
+		if prowgen.Tests[configSpec.Tests[i].As].Periodic() {
+			if info.IsBranchGlob() {
+				// periodics test a single branch, which a glob does not name
+				logrus.WithField("test", configSpec.Tests[i].As).Warnf("Not generating a periodic for configuration file %s with a glob branch", info.Basename())
+				continue
 			}
-			podSpec = generatePodSpecTemplate(info, release, &element)
+			periodics = append(periodics, *generateTestPeriodic(configSpec, &configSpec.Tests[i], info, prowgen, opts))
 		}
-		presubmits[orgrepo] = append(presubmits[orgrepo], *generatePresubmitForTest(element.As, info, podSpec))
 	}
 
 	if len(configSpec.Images) > 0 {
 		// TODO: we should populate labels based on ci-operator characteristics
 		labels := map[string]string{}
 
-		// Identify which jobs need a to have a release payload explicitly requested
-		var additionalPresubmitArgs []string
+		var additionalPostsubmitArgs []string
 		if promotion.PromotesOfficialImages(configSpec) {
-			additionalPresubmitArgs = []string{"--target=[release:latest]"}
+			additionalPostsubmitArgs = append(additionalPostsubmitArgs, "--promote")
 		}
-
-		additionalPostsubmitArgs := []string{"--promote"}
 		if configSpec.PromotionConfiguration != nil {
 			for additionalImage := range configSpec.PromotionConfiguration.AdditionalImages {
 				additionalPostsubmitArgs = append(additionalPostsubmitArgs, fmt.Sprintf("--target=%s", configSpec.PromotionConfiguration.AdditionalImages[additionalImage]))
 			}
 		}
 
-		presubmits[orgrepo] = append(presubmits[orgrepo], *generatePresubmitForTest("images", info, generatePodSpec(info, "[images]", additionalPresubmitArgs...)))
+		if !prowgen.SkipImagesPresubmit {
+			presubmits[orgrepo] = append(presubmits[orgrepo], *generateImagesPresubmit(configSpec, info, prowgen, opts))
+		}
 
 		if configSpec.PromotionConfiguration != nil {
-			postsubmits[orgrepo] = append(postsubmits[orgrepo], *generatePostsubmitForTest("images", info, true, labels, generatePodSpec(info, "[images]", additionalPostsubmitArgs...)))
+			podSpec := generatePodSpec(info, "[images]", opts, additionalPostsubmitArgs...)
+			applyScheduling(podSpec, prowgen.SchedulingFor("images"))
+			applyResources(podSpec, prowgen.ResourcesFor("images"))
+			postsubmit := generatePostsubmitForTest("images", jobInfo, prowgen.VariantSuffix, true, labels, podSpec, opts)
+			postsubmit.Annotations = promotionAnnotations(configSpec.PromotionConfiguration)
+			postsubmits[orgrepo] = append(postsubmits[orgrepo], *postsubmit)
 		}
 	}
 
 	return &prowconfig.JobConfig{
 		Presubmits:  presubmits,
 		Postsubmits: postsubmits,
+		Periodics:   periodics,
+	}
+}
+
+// promotionAnnotations returns the annotations recording the target of the
+// promotion done by the images postsubmit
+func promotionAnnotations(promotion *cioperatorapi.PromotionConfiguration) map[string]string {
+	annotations := map[string]string{}
+	if promotion.Namespace != "" {
+		annotations[jc.ProwJobAnnotationPromotionNamespace] = promotion.Namespace
+	}
+	if promotion.Name != "" {
+		annotations[jc.ProwJobAnnotationPromotionName] = promotion.Name
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// generateTestPodSpec generates the pod spec running a test from the
+// configuration, which the presubmit and the periodic for the test share
+func generateTestPodSpec(
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, test *cioperatorapi.TestStepConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions,
+) *kubeapi.PodSpec {
+	var podSpec *kubeapi.PodSpec
+	if test.ContainerTestConfiguration != nil {
+		podSpec = generatePodSpec(info, test.As, opts)
+	} else {
+		var release string
+		if c := configSpec.ReleaseTagConfiguration; c != nil {
+			release = c.Name
+		}
+		podSpec = generatePodSpecTemplate(info, release, test, prowgen.Tests[test.As].ClusterProfileFor(info.Branch), opts)
+	}
+	applyScheduling(podSpec, prowgen.SchedulingFor(test.As))
+	applyResources(podSpec, prowgen.ResourcesFor(test.As))
+	if prowgen.Tests[test.As].Leases {
+		applyLeases(podSpec, opts)
 	}
+	return podSpec
+}
+
+// generateTestPresubmit generates the presubmit for a test from the configuration
+func generateTestPresubmit(
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, test *cioperatorapi.TestStepConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions,
+) *prowconfig.Presubmit {
+	podSpec := generateTestPodSpec(configSpec, test, info, prowgen, opts)
+	applyPRAuthorAccess(podSpec, prowgen.PRAuthorAccessFor(test.As))
+	presubmit := generatePresubmitForTest(test.As, opts.jobInfo(info), prowgen.VariantSuffix, podSpec, opts)
+	applyClusterTypeLabel(&presubmit.JobBase, opts)
+	applyAlwaysRunPolicy(presubmit, prowgen.AlwaysRun)
+	applyRunIfChanged(presubmit, prowgen.Tests[test.As].RunIfChanged)
+	applyDecorationTimeouts(&presubmit.JobBase, prowgen.Tests[test.As])
+	return presubmit
+}
+
+// optionalPrefix prefixes the names of tests in the names, contexts and triggers
+// of optional presubmits
+const optionalPrefix = "optional-"
+
+// generateOptionalTestPresubmit generates the optional presubmit for a test from
+// the configuration. It runs the same pod as the presubmit for the test, but
+// only when requested with `/test optional-TEST`, and reports to its own context.
+func generateOptionalTestPresubmit(
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, test *cioperatorapi.TestStepConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions,
+) *prowconfig.Presubmit {
+	presubmit := generateTestPresubmit(configSpec, test, info, prowgen, opts)
+	jobInfo := opts.jobInfo(info)
+	name := optionalPrefix + strings.TrimPrefix(presubmit.Context, "ci/prow/")
+	presubmit.Name = jc.PresubmitName(jobInfo.Org, jobInfo.Repo, branchToken(jobInfo), name)
+	presubmit.Context = fmt.Sprintf("ci/prow/%s", name)
+	presubmit.RerunCommand = prowconfig.DefaultRerunCommandFor(name)
+	presubmit.Trigger = jc.TriggerFor(name)
+	presubmit.Optional = true
+	// the values replace the ones in the existing job file like a policy would
+	presubmit.AlwaysRun = false
+	presubmit.RunIfChanged = ""
+	markAlwaysRunPolicy(presubmit)
+	return presubmit
+}
+
+// generateTestPeriodic generates the periodic for a test from the
+// configuration on the schedule from the repository settings
+func generateTestPeriodic(
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, test *cioperatorapi.TestStepConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions,
+) *prowconfig.Periodic {
+	settings := prowgen.Tests[test.As]
+	podSpec := generateTestPodSpec(configSpec, test, info, prowgen, opts)
+	periodic := generatePeriodicForTest(test.As, opts.jobInfo(info), prowgen.VariantSuffix, settings.Cron, settings.Interval, podSpec, opts)
+	applyClusterTypeLabel(&periodic.JobBase, opts)
+	applyDecorationTimeouts(&periodic.JobBase, settings)
+	return periodic
 }
 
-// generateJobsToDir returns a callback that knows how to generate prow job configuration
-// into the dir provided by consuming ci-operator configuration
-func generateJobsToDir(dir string) func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info) error {
+// generateImagesPresubmit generates the presubmit building the images from
+// the configuration
+func generateImagesPresubmit(
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions,
+) *prowconfig.Presubmit {
+	// Identify which jobs need a to have a release payload explicitly requested
+	var additionalPresubmitArgs []string
+	if promotion.PromotesOfficialImages(configSpec) {
+		additionalPresubmitArgs = []string{"--target=[release:latest]"}
+	}
+	podSpec := generatePodSpec(info, "[images]", opts, additionalPresubmitArgs...)
+	applyScheduling(podSpec, prowgen.SchedulingFor("images"))
+	applyResources(podSpec, prowgen.ResourcesFor("images"))
+	applyPRAuthorAccess(podSpec, prowgen.PRAuthorAccessFor("images"))
+	presubmit := generatePresubmitForTest("images", opts.jobInfo(info), prowgen.VariantSuffix, podSpec, opts)
+	applyAlwaysRunPolicy(presubmit, prowgen.ImagesAlwaysRun)
+	applyRunIfChanged(presubmit, prowgen.Tests["images"].RunIfChanged)
+	applyDecorationTimeouts(&presubmit.JobBase, prowgen.Tests["images"])
+	return presubmit
+}
+
+// generatePresubmit returns the presubmit which `generateJobs` generates for
+// the test with the given name, which is `images` for the presubmit building
+// images. It returns nil when `generateJobs` does not generate such presubmit.
+func generatePresubmit(
+	configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info, prowgen *config.Prowgen, opts *generatorOptions, testName string,
+) *prowconfig.Presubmit {
+	for i := range configSpec.Tests {
+		if configSpec.Tests[i].As == testName {
+			return generateTestPresubmit(configSpec, &configSpec.Tests[i], info, prowgen, opts)
+		}
+	}
+	if testName == "images" && len(configSpec.Images) > 0 && !prowgen.SkipImagesPresubmit {
+		return generateImagesPresubmit(configSpec, info, prowgen, opts)
+	}
+	return nil
+}
+
+// jobsToDir generates Prow job configuration from ci-operator configuration files
+// into a directory. Configuration files for different branches of a repository may
+// share the same job files, so the jobs are accumulated per org/repo by `generate`
+// and all jobs for a repository are written together by `write`.
+type jobsToDir struct {
+	dir      string
+	contexts requiredContexts
+	summary  *generationSummary
+	opts     *generatorOptions
+
+	lock sync.Mutex // guards contexts and jobs
+	// jobs holds the jobs generated so far, keyed by org/repo
+	jobs map[string]*prowconfig.JobConfig
+}
+
+// newJobsToDir creates a jobsToDir writing into `dir`. When `contexts` is not nil,
+// the contexts required by the generated presubmits are recorded into it. When
+// `summary` is not nil, the generated jobs are counted in it.
+func newJobsToDir(dir string, contexts requiredContexts, summary *generationSummary, opts *generatorOptions) *jobsToDir {
+	return &jobsToDir{
+		dir:      dir,
+		contexts: contexts,
+		summary:  summary,
+		opts:     opts,
+		jobs:     map[string]*prowconfig.JobConfig{},
+	}
+}
+
+// generate generates the jobs for a ci-operator configuration file and adds them to
+// the jobs to be written. It is safe for concurrent use.
+func (j *jobsToDir) generate(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info) error {
+	jobConfig, err := generateJobsForConfig(configSpec, info, j.opts)
+	if err != nil {
+		return err
+	}
+	if j.summary != nil {
+		j.summary.add(jobConfig)
+	}
+
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.contexts != nil {
+		j.contexts.add(jobConfig)
+	}
+	jobInfo := j.opts.jobInfo(info)
+	orgRepo := fmt.Sprintf("%s/%s", jobInfo.Org, jobInfo.Repo)
+	accumulated, ok := j.jobs[orgRepo]
+	if !ok {
+		accumulated = &prowconfig.JobConfig{
+			Presubmits:  map[string][]prowconfig.Presubmit{},
+			Postsubmits: map[string][]prowconfig.Postsubmit{},
+		}
+		j.jobs[orgRepo] = accumulated
+	}
+	for repo, presubmits := range jobConfig.Presubmits {
+		accumulated.Presubmits[repo] = append(accumulated.Presubmits[repo], presubmits...)
+	}
+	for repo, postsubmits := range jobConfig.Postsubmits {
+		accumulated.Postsubmits[repo] = append(accumulated.Postsubmits[repo], postsubmits...)
+	}
+	accumulated.Periodics = append(accumulated.Periodics, jobConfig.Periodics...)
+	return nil
+}
+
+// write writes all jobs generated so far into the directory, merging them with the
+// jobs already present there. Failures for single repositories do not stop writing
+// the jobs for the others.
+func (j *jobsToDir) write() error {
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.opts.prowConfig != "" {
+		if err := j.validate(); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, orgRepo := range sets.StringKeySet(j.jobs).List() {
+		parts := strings.SplitN(orgRepo, "/", 2)
+		if err := jc.WriteToDirGrouped(j.dir, parts[0], parts[1], j.jobs[orgRepo], j.opts.fileGrouping, j.opts.fileMode); err != nil {
+			logrus.WithError(err).WithField("target-repo", orgRepo).Error("Failed to write jobs")
+			errs = append(errs, fmt.Errorf("%s: %v", orgRepo, err))
+		}
+	}
+	return kutilerrors.NewAggregate(errs)
+}
+
+// validate checks all generated jobs with Prow, logging the jobs Prow would
+// reject. The caller needs to hold the lock.
+func (j *jobsToDir) validate() error {
+	all := &prowconfig.JobConfig{
+		Presubmits:  map[string][]prowconfig.Presubmit{},
+		Postsubmits: map[string][]prowconfig.Postsubmit{},
+	}
+	for _, jobConfig := range j.jobs {
+		for repo, presubmits := range jobConfig.Presubmits {
+			all.Presubmits[repo] = append(all.Presubmits[repo], presubmits...)
+		}
+		for repo, postsubmits := range jobConfig.Postsubmits {
+			all.Postsubmits[repo] = append(all.Postsubmits[repo], postsubmits...)
+		}
+		all.Periodics = append(all.Periodics, jobConfig.Periodics...)
+	}
+	rejected, err := jc.ValidateWithProw(j.opts.prowConfig, all)
+	if err != nil {
+		return fmt.Errorf("failed to validate jobs with Prow (%v)", err)
+	}
+	if len(rejected) == 0 {
+		return nil
+	}
+	for _, name := range sets.StringKeySet(rejected).List() {
+		logger := logrus.WithError(rejected[name])
+		if name != "" {
+			logger = logger.WithField("job", name)
+		}
+		logger.Error("Prow would reject generated jobs")
+	}
+	return fmt.Errorf("Prow would reject %d generated jobs", len(rejected))
+}
+
+// generateJobsForConfig generates the jobs for a ci-operator configuration
+// file, honoring the settings of its repository
+func generateJobsForConfig(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info, opts *generatorOptions) (*prowconfig.JobConfig, error) {
+	prowgen := &config.Prowgen{}
+	if opts.readProwgenConfigs {
+		var err error
+		if prowgen, err = config.LoadProwgenConfig(filepath.Dir(info.Filename)); err != nil {
+			return nil, err
+		}
+	}
+	if tests := prowgen.NeedLeases(); len(tests) > 0 && opts.leaseServer == "" {
+		return nil, fmt.Errorf("tests %s need leases, but `--lease-server` is not set", strings.Join(tests, ", "))
+	}
+	jobConfig := generateJobs(configSpec, info, prowgen, opts)
+	if err := jc.ApplyOverrides(jobConfig, prowgen.JobOverrides); err != nil {
+		return nil, err
+	}
+	applyJobLabels(jobConfig, opts)
+	if invalid := jc.InvalidTriggers(jobConfig); len(invalid) > 0 {
+		return nil, fmt.Errorf("generated presubmits cannot be triggered: %s", strings.Join(invalid, ", "))
+	}
+	if opts.strictNames {
+		if names := jc.LongJobNames(jobConfig); len(names) > 0 {
+			return nil, fmt.Errorf("generated job names are longer than %d characters: %s", jc.MaxJobNameLength, strings.Join(names, ", "))
+		}
+	}
+	return jobConfig, nil
+}
+
+// findConfigsWithoutJobs returns a callback that generates the jobs for
+// ci-operator configuration files in memory and records the files for which
+// no jobs are generated into `empty`. The callback is safe for concurrent use.
+func findConfigsWithoutJobs(empty *[]string, opts *generatorOptions) func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info) error {
+	var lock sync.Mutex
 	return func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info) error {
-		return jc.WriteToDir(dir, info.Org, info.Repo, generateJobs(configSpec, info))
+		jobConfig, err := generateJobsForConfig(configSpec, info, opts)
+		if err != nil {
+			return err
+		}
+		jobs := len(jobConfig.Periodics)
+		for _, presubmits := range jobConfig.Presubmits {
+			jobs += len(presubmits)
+		}
+		for _, postsubmits := range jobConfig.Postsubmits {
+			jobs += len(postsubmits)
+		}
+		if jobs == 0 {
+			lock.Lock()
+			*empty = append(*empty, info.Filename)
+			lock.Unlock()
+		}
+		return nil
+	}
+}
+
+// collectJobNames returns a callback that generates the jobs for ci-operator
+// configuration files in memory and records their names into `names`. The
+// callback is safe for concurrent use.
+func collectJobNames(names sets.String, opts *generatorOptions) func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info) error {
+	var lock sync.Mutex
+	return func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *config.Info) error {
+		jobConfig, err := generateJobsForConfig(configSpec, info, opts)
+		if err != nil {
+			return err
+		}
+		lock.Lock()
+		names.Insert(jc.JobNames(jobConfig).UnsortedList()...)
+		lock.Unlock()
+		return nil
 	}
 }
 
+// generateJobsFromTarStream generates jobs for the configuration files read
+// as a tar stream from stdin and writes the job files as a tar stream to stdout
+func generateJobsFromTarStream(contexts requiredContexts, summary *generationSummary, kustomize bool, opts *generatorOptions) error {
+	dir, err := ioutil.TempDir("", "ci-operator-prowgen")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory (%v)", err)
+	}
+	defer os.RemoveAll(dir)
+
+	jobs := newJobsToDir(dir, contexts, summary, opts)
+	if err := config.OperateOnCIOperatorConfigTar(os.Stdin, jobs.generate); err != nil {
+		return err
+	}
+	if err := jobs.write(); err != nil {
+		return err
+	}
+	if kustomize {
+		if err := jc.WriteKustomizations(dir, opts.fileMode); err != nil {
+			return err
+		}
+	}
+	return writeDirToTar(dir, os.Stdout)
+}
+
 func getReleaseRepoDir(directory string) (string, error) {
 	var gopath string
 	if gopath = os.Getenv("GOPATH"); len(gopath) == 0 {
@@ -465,19 +1488,126 @@ func main() {
 		os.Exit(0)
 	}
 
+	if opt.defaultsFile != "" {
+		if err := applyDefaultsFile(flagSet, opt.defaultsFile); err != nil {
+			logrus.WithError(err).WithField("source-file", opt.defaultsFile).Fatal("Failed to apply defaults")
+		}
+	}
+
 	if err := opt.process(); err != nil {
 		logrus.WithError(err).Fatal("Failed to process arguments")
 		os.Exit(1)
 	}
 
-	if len(opt.fromFile) > 0 {
-		if err := config.OperateOnCIOperatorConfig(opt.fromFile, generateJobsToDir(opt.toDir)); err != nil {
-			logrus.WithError(err).WithField("source-file", opt.fromFile).Fatal("Failed to generate jobs")
+	var contexts requiredContexts
+	if len(opt.toRequiredContexts) > 0 {
+		contexts = requiredContexts{}
+	}
+	summary := newGenerationSummary()
+
+	if opt.listOrphans {
+		generated := sets.NewString()
+		callback := collectJobNames(generated, &opt.generator)
+		if err := opt.operateOnCIOperatorConfigs(callback); err != nil {
+			logrus.WithError(err).Fatal("Failed to generate jobs")
 		}
-	} else { // from directory
-		if err := config.OperateOnCIOperatorConfigDir(opt.fromDir, generateJobsToDir(opt.toDir)); err != nil {
+		orphans, err := jc.FindOrphans(opt.toDir, generated)
+		if err != nil {
+			logrus.WithError(err).WithField("target-dir", opt.toDir).Fatal("Failed to read Prow job configuration")
+		}
+		for _, orphan := range orphans {
+			if orphan.WholeFile {
+				fmt.Println(orphan.Filename)
+				continue
+			}
+			for _, job := range orphan.Jobs {
+				fmt.Printf("%s: %s\n", orphan.Filename, job)
+			}
+		}
+		if len(orphans) > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opt.diffSince != "" {
+		repoDiffs, err := diffChangedRepos(opt.fromDir, opt.toDir, opt.diffSince, opt.parallel, &opt.generator)
+		if err != nil {
 			fields := logrus.Fields{"target-dir": opt.toDir, "source-dir": opt.fromDir}
-			logrus.WithError(err).WithFields(fields).Fatal("Failed to generate jobs")
+			logrus.WithError(err).WithFields(fields).Fatal("Failed to diff jobs for changed ci-operator configuration")
+		}
+		diffs.PrintJobConfigDiffs(os.Stdout, repoDiffs)
+		return
+	}
+
+	if opt.dryRun {
+		changed, err := dryRunJobs(opt.toDir, &opt.generator, opt.operateOnCIOperatorConfigs, os.Stdout)
+		if err != nil {
+			logrus.WithError(err).WithField("target-dir", opt.toDir).Fatal("Failed to diff generated jobs")
+		}
+		if changed {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if opt.validateNotEmpty {
+		var empty []string
+		callback := findConfigsWithoutJobs(&empty, &opt.generator)
+		if err := opt.operateOnCIOperatorConfigs(callback); err != nil {
+			logrus.WithError(err).Fatal("Failed to generate jobs")
+		}
+		if len(empty) > 0 {
+			sort.Strings(empty)
+			for _, filename := range empty {
+				logrus.WithField("source-file", filename).Error("No jobs are generated for ci-operator configuration")
+			}
+			logrus.Fatalf("No jobs are generated for %d ci-operator configuration files", len(empty))
+		}
+		return
+	}
+
+	if opt.tarStream {
+		if err := generateJobsFromTarStream(contexts, summary, opt.kustomize, &opt.generator); err != nil {
+			logrus.WithError(err).Fatal("Failed to generate jobs")
+		}
+	} else {
+		jobs := newJobsToDir(opt.toDir, contexts, summary, &opt.generator)
+		if len(opt.fromFile) > 0 {
+			if err := config.OperateOnCIOperatorConfig(opt.fromFile, jobs.generate); err != nil {
+				logrus.WithError(err).WithField("source-file", opt.fromFile).Fatal("Failed to generate jobs")
+			}
+		} else if len(opt.fromConfigMap) > 0 {
+			if err := config.OperateOnCIOperatorConfigMap(opt.fromConfigMap, jobs.generate); err != nil {
+				logrus.WithError(err).WithField("source-file", opt.fromConfigMap).Fatal("Failed to generate jobs")
+			}
+		} else { // from directory
+			if err := config.OperateOnCIOperatorConfigDirParallel(opt.fromDir, opt.parallel, jobs.generate); err != nil {
+				fields := logrus.Fields{"target-dir": opt.toDir, "source-dir": opt.fromDir}
+				logrus.WithError(err).WithFields(fields).Fatal("Failed to generate jobs")
+			}
+		}
+		if err := jobs.write(); err != nil {
+			logrus.WithError(err).WithField("target-dir", opt.toDir).Fatal("Failed to write jobs")
+		}
+	}
+
+	if opt.kustomize && !opt.tarStream {
+		if err := jc.WriteKustomizations(opt.toDir, opt.generator.fileMode); err != nil {
+			logrus.WithError(err).WithField("target-dir", opt.toDir).Fatal("Failed to write kustomizations")
+		}
+	}
+
+	if contexts != nil {
+		if err := contexts.writeToFile(opt.toRequiredContexts, opt.generator.fileMode); err != nil {
+			logrus.WithError(err).WithField("target-file", opt.toRequiredContexts).Fatal("Failed to write required contexts")
+		}
+	}
+
+	summary.log()
+	if len(opt.toSummary) > 0 {
+		if err := summary.writeToFile(opt.toSummary, opt.generator.fileMode); err != nil {
+			logrus.WithError(err).WithField("target-file", opt.toSummary).Fatal("Failed to write generation summary")
 		}
 	}
 }
@@ -2,14 +2,20 @@ package main
 
 import (
 	"bytes"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/ghodss/yaml"
 	kubeapi "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -20,6 +26,7 @@ import (
 	ciop "github.com/openshift/ci-operator/pkg/api"
 
 	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
 )
 
 func TestGeneratePodSpec(t *testing.T) {
@@ -119,9 +126,9 @@ func TestGeneratePodSpec(t *testing.T) {
 	for _, tc := range tests {
 		var podSpec *kubeapi.PodSpec
 		if len(tc.additionalArgs) == 0 {
-			podSpec = generatePodSpec(tc.info, tc.target)
+			podSpec = generatePodSpec(tc.info, tc.target, &generatorOptions{})
 		} else {
-			podSpec = generatePodSpec(tc.info, tc.target, tc.additionalArgs...)
+			podSpec = generatePodSpec(tc.info, tc.target, &generatorOptions{}, tc.additionalArgs...)
 		}
 		if !equality.Semantic.DeepEqual(podSpec, tc.expected) {
 			t.Errorf("expected PodSpec diff:\n%s", diff.ObjectDiff(tc.expected, podSpec))
@@ -129,6 +136,227 @@ func TestGeneratePodSpec(t *testing.T) {
 	}
 }
 
+func TestGeneratePodSpecConfigSpecEnv(t *testing.T) {
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "branch"}
+	testCases := []struct {
+		opts     *generatorOptions
+		expected string
+	}{
+		{opts: &generatorOptions{}, expected: "CONFIG_SPEC"},
+		{opts: &generatorOptions{configSpecEnv: "CI_CONFIG"}, expected: "CI_CONFIG"},
+	}
+	for _, tc := range testCases {
+		env := generatePodSpec(info, "target", tc.opts).Containers[0].Env
+		if len(env) != 1 || env[0].Name != tc.expected || env[0].ValueFrom.ConfigMapKeyRef.Key != info.Basename() {
+			t.Errorf("expected ci-operator config in %s env var, got %#v", tc.expected, env)
+		}
+	}
+}
+
+func TestGeneratePodSpecCiOperatorImage(t *testing.T) {
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "branch"}
+	testCases := []struct {
+		opts               *generatorOptions
+		expectedImage      string
+		expectedPullPolicy kubeapi.PullPolicy
+	}{
+		{opts: &generatorOptions{}, expectedImage: "ci-operator:latest", expectedPullPolicy: kubeapi.PullAlways},
+		{
+			opts:               &generatorOptions{ciOperatorImage: "mirror.example.com/ci/ci-operator:latest", ciOperatorImagePullPolicy: kubeapi.PullIfNotPresent},
+			expectedImage:      "mirror.example.com/ci/ci-operator:latest",
+			expectedPullPolicy: kubeapi.PullIfNotPresent,
+		},
+	}
+	for _, tc := range testCases {
+		container := generatePodSpec(info, "target", tc.opts).Containers[0]
+		if container.Image != tc.expectedImage || container.ImagePullPolicy != tc.expectedPullPolicy {
+			t.Errorf("expected image %s pulled with %s, got %s pulled with %s", tc.expectedImage, tc.expectedPullPolicy, container.Image, container.ImagePullPolicy)
+		}
+	}
+}
+
+func TestProcessCiOperatorImage(t *testing.T) {
+	testCases := []struct {
+		args          []string
+		expectedError bool
+	}{
+		{args: nil},
+		{args: []string{"--ci-operator-image=mirror.example.com/ci/ci-operator:latest", "--ci-operator-image-pull-policy=IfNotPresent"}},
+		{args: []string{"--ci-operator-image="}, expectedError: true},
+		{args: []string{"--ci-operator-image-pull-policy=Sometimes"}, expectedError: true},
+	}
+	for _, tc := range testCases {
+		flagSet := flag.NewFlagSet("", flag.ContinueOnError)
+		opt := bindOptions(flagSet)
+		if err := flagSet.Parse(append([]string{"--from-file=config.yaml", "--to-dir=jobs"}, tc.args...)); err != nil {
+			t.Fatal(err)
+		}
+		err := opt.process()
+		if err == nil && tc.expectedError {
+			t.Errorf("%v: expected an error, but got none", tc.args)
+		}
+		if err != nil && !tc.expectedError {
+			t.Errorf("%v: expected no error, but got one: %v", tc.args, err)
+		}
+	}
+}
+
+func TestProcessListOrphans(t *testing.T) {
+	testCases := []struct {
+		args          []string
+		expectedError string
+	}{
+		{args: []string{"--list-orphans"}},
+		{args: []string{"--list-orphans", "--to-required-contexts=contexts.yaml"}, expectedError: "`--list-orphans` cannot be combined with `--to-required-contexts` and `--to-summary`"},
+		{args: []string{"--list-orphans", "--to-summary=summary.yaml"}, expectedError: "`--list-orphans` cannot be combined with `--to-required-contexts` and `--to-summary`"},
+	}
+	for _, tc := range testCases {
+		flagSet := flag.NewFlagSet("", flag.ContinueOnError)
+		opt := bindOptions(flagSet)
+		if err := flagSet.Parse(append([]string{"--from-dir=config", "--to-dir=jobs"}, tc.args...)); err != nil {
+			t.Fatal(err)
+		}
+		err := opt.process()
+		if err == nil && tc.expectedError != "" {
+			t.Errorf("%v: expected an error, but got none", tc.args)
+		}
+		if err != nil && err.Error() != tc.expectedError {
+			t.Errorf("%v: expected error %q, but got: %v", tc.args, tc.expectedError, err)
+		}
+	}
+}
+
+func TestGeneratePodSpecArtifactDir(t *testing.T) {
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "branch"}
+	testCases := []struct {
+		opts     *generatorOptions
+		expected string
+	}{
+		{opts: &generatorOptions{}, expected: "--artifact-dir=$(ARTIFACTS)"},
+		{opts: &generatorOptions{artifactDir: "$(TEST_ARTIFACTS)"}, expected: "--artifact-dir=$(TEST_ARTIFACTS)"},
+	}
+	for _, tc := range testCases {
+		args := generatePodSpec(info, "target", tc.opts).Containers[0].Args
+		if len(args) < 2 || args[1] != tc.expected {
+			t.Errorf("expected %s arg, got %v", tc.expected, args)
+		}
+	}
+}
+
+func TestGeneratePodSpecImagePullSecrets(t *testing.T) {
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "branch"}
+	testCases := []struct {
+		opts     *generatorOptions
+		expected []kubeapi.LocalObjectReference
+	}{
+		{opts: &generatorOptions{}},
+		{
+			opts:     &generatorOptions{imagePullSecrets: []string{"registry-pull", "mirror-pull"}},
+			expected: []kubeapi.LocalObjectReference{{Name: "registry-pull"}, {Name: "mirror-pull"}},
+		},
+	}
+	for _, tc := range testCases {
+		if secrets := generatePodSpec(info, "target", tc.opts).ImagePullSecrets; !equality.Semantic.DeepEqual(tc.expected, secrets) {
+			t.Errorf("unexpected image pull secrets: %s", diff.ObjectReflectDiff(tc.expected, secrets))
+		}
+	}
+}
+
+func TestGeneratePodSpecPullSecret(t *testing.T) {
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "branch"}
+	paths := map[string]string{"api.ci": "/etc/pull-secret", "build01": "/var/run/pull-secret"}
+	testCases := []struct {
+		id       string
+		opts     *generatorOptions
+		expected string
+	}{
+		{id: "no build cluster", opts: &generatorOptions{pullSecret: "regcred", pullSecretPaths: paths}},
+		{id: "build cluster without a path", opts: &generatorOptions{buildCluster: "build02", pullSecret: "regcred", pullSecretPaths: paths}},
+		{id: "build cluster with a path", opts: &generatorOptions{buildCluster: "build01", pullSecret: "regcred", pullSecretPaths: paths}, expected: "/var/run/pull-secret"},
+	}
+	for _, tc := range testCases {
+		podSpec := generatePodSpec(info, "target", tc.opts)
+		var mountPath string
+		for _, mount := range podSpec.Containers[0].VolumeMounts {
+			if mount.Name == pullSecretMountName {
+				mountPath = mount.MountPath
+			}
+		}
+		var secret string
+		for _, volume := range podSpec.Volumes {
+			if volume.Name == pullSecretMountName {
+				secret = volume.Secret.SecretName
+			}
+		}
+		if mountPath != tc.expected {
+			t.Errorf("%s: expected pull secret mounted at %q, got %q", tc.id, tc.expected, mountPath)
+		}
+		if (secret != "") != (tc.expected != "") || secret != "" && secret != "regcred" {
+			t.Errorf("%s: unexpected pull secret volume for secret %q", tc.id, secret)
+		}
+	}
+}
+
+func TestGeneratePodSpecConfigMapKey(t *testing.T) {
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "feature/x"}
+	keyFormat, err := config.NewConfigMapKeyFormat("{{.Org}}.{{.Repo}}.{{.Branch}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		id       string
+		opts     *generatorOptions
+		expected string
+	}{
+		{id: "default format", opts: &generatorOptions{}, expected: "org-repo-feature_x.yaml"},
+		{id: "custom format", opts: &generatorOptions{keyFormat: keyFormat}, expected: "org.repo.feature_x"},
+	}
+	for _, tc := range testCases {
+		podSpec := generatePodSpec(info, "target", tc.opts)
+		if key := podSpec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key; key != tc.expected {
+			t.Errorf("%s: expected ConfigMap key %q, got %q", tc.id, tc.expected, key)
+		}
+	}
+}
+
+func TestParsePullSecretPaths(t *testing.T) {
+	testCases := []struct {
+		id          string
+		values      []string
+		expected    map[string]string
+		expectedErr bool
+	}{{
+		id:       "no paths",
+		expected: map[string]string{},
+	}, {
+		id:       "multiple clusters",
+		values:   []string{"api.ci=/etc/pull-secret", "build01=/var/run/pull-secret"},
+		expected: map[string]string{"api.ci": "/etc/pull-secret", "build01": "/var/run/pull-secret"},
+	}, {
+		id:          "relative path",
+		values:      []string{"api.ci=pull-secret"},
+		expectedErr: true,
+	}, {
+		id:          "missing cluster",
+		values:      []string{"=/etc/pull-secret"},
+		expectedErr: true,
+	}, {
+		id:          "conflicting paths",
+		values:      []string{"api.ci=/etc/pull-secret", "api.ci=/var/run/pull-secret"},
+		expectedErr: true,
+	}}
+	for _, tc := range testCases {
+		paths, err := parsePullSecretPaths(tc.values)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error: %t, got: %v", tc.id, tc.expectedErr, err)
+			continue
+		}
+		if !tc.expectedErr && !reflect.DeepEqual(tc.expected, paths) {
+			t.Errorf("%s: unexpected paths: %s", tc.id, diff.ObjectReflectDiff(tc.expected, paths))
+		}
+	}
+}
+
 func TestGeneratePodSpecTemplate(t *testing.T) {
 	tests := []struct {
 		info    *config.Info
@@ -319,7 +547,7 @@ func TestGeneratePodSpecTemplate(t *testing.T) {
 
 	for _, tc := range tests {
 		var podSpec *kubeapi.PodSpec
-		podSpec = generatePodSpecTemplate(tc.info, tc.release, &tc.test)
+		podSpec = generatePodSpecTemplate(tc.info, tc.release, &tc.test, "", &generatorOptions{})
 		if !equality.Semantic.DeepEqual(podSpec, tc.expected) {
 			t.Errorf("expected PodSpec diff:\n%s", diff.ObjectDiff(tc.expected, podSpec))
 		}
@@ -331,9 +559,10 @@ func TestGeneratePresubmitForTest(t *testing.T) {
 	standardJobLabels := map[string]string{"ci-operator.openshift.io/prowgen-controlled": "true"}
 
 	tests := []struct {
-		name     string
-		repoInfo *config.Info
-		expected *prowconfig.Presubmit
+		name          string
+		repoInfo      *config.Info
+		variantSuffix bool
+		expected      *prowconfig.Presubmit
 	}{{
 		name:     "testname",
 		repoInfo: &config.Info{Org: "org", Repo: "repo", Branch: "branch"},
@@ -356,9 +585,54 @@ func TestGeneratePresubmitForTest(t *testing.T) {
 			RerunCommand: "/test testname",
 			Trigger:      `(?m)^/test( | .* )testname,?($|\s.*)`,
 		},
+	}, {
+		name:     "testname",
+		repoInfo: &config.Info{Org: "org", Repo: "repo", Branch: "branch", Variant: "fips"},
+
+		expected: &prowconfig.Presubmit{
+			JobBase: prowconfig.JobBase{
+				Agent:  "kubernetes",
+				Labels: map[string]string{"ci-operator.openshift.io/prowgen-controlled": "true", "ci-operator.openshift.io/variant": "fips"},
+				Name:   "pull-ci-org-repo-branch-fips-testname",
+				UtilityConfig: prowconfig.UtilityConfig{
+					DecorationConfig: &v1.DecorationConfig{SkipCloning: &newTrue},
+					Decorate:         true,
+				},
+			},
+			AlwaysRun: true,
+			Brancher:  prowconfig.Brancher{Branches: []string{"branch"}},
+			Reporter: prowconfig.Reporter{
+				Context: "ci/prow/fips-testname",
+			},
+			RerunCommand: "/test fips-testname",
+			Trigger:      `(?m)^/test( | .* )fips-testname,?($|\s.*)`,
+		},
+	}, {
+		name:          "testname",
+		repoInfo:      &config.Info{Org: "org", Repo: "repo", Branch: "branch", Variant: "fips"},
+		variantSuffix: true,
+
+		expected: &prowconfig.Presubmit{
+			JobBase: prowconfig.JobBase{
+				Agent:  "kubernetes",
+				Labels: map[string]string{"ci-operator.openshift.io/prowgen-controlled": "true", "ci-operator.openshift.io/variant": "fips"},
+				Name:   "pull-ci-org-repo-branch-testname-fips",
+				UtilityConfig: prowconfig.UtilityConfig{
+					DecorationConfig: &v1.DecorationConfig{SkipCloning: &newTrue},
+					Decorate:         true,
+				},
+			},
+			AlwaysRun: true,
+			Brancher:  prowconfig.Brancher{Branches: []string{"branch"}},
+			Reporter: prowconfig.Reporter{
+				Context: "ci/prow/testname-fips",
+			},
+			RerunCommand: "/test testname-fips",
+			Trigger:      `(?m)^/test( | .* )testname-fips,?($|\s.*)`,
+		},
 	}}
 	for _, tc := range tests {
-		presubmit := generatePresubmitForTest(tc.name, tc.repoInfo, nil) // podSpec tested in generatePodSpec
+		presubmit := generatePresubmitForTest(tc.name, tc.repoInfo, tc.variantSuffix, nil, &generatorOptions{}) // podSpec tested in generatePodSpec
 		if !equality.Semantic.DeepEqual(presubmit, tc.expected) {
 			t.Errorf("expected presubmit diff:\n%s", diff.ObjectDiff(tc.expected, presubmit))
 		}
@@ -373,6 +647,7 @@ func TestGeneratePostSubmitForTest(t *testing.T) {
 		repoInfo *config.Info
 		labels   map[string]string
 
+		variantSuffix           bool
 		treatBranchesAsExplicit bool
 
 		expected *prowconfig.Postsubmit
@@ -468,9 +743,33 @@ func TestGeneratePostSubmitForTest(t *testing.T) {
 				Brancher: prowconfig.Brancher{Branches: []string{"Branch-.*"}},
 			},
 		},
+		{
+			name: "images",
+			repoInfo: &config.Info{
+				Org:     "organization",
+				Repo:    "repository",
+				Branch:  "branch",
+				Variant: "fips",
+			},
+			labels: map[string]string{},
+
+			variantSuffix: true,
+
+			expected: &prowconfig.Postsubmit{
+				JobBase: prowconfig.JobBase{
+					Agent:  "kubernetes",
+					Name:   "branch-ci-organization-repository-branch-images-fips",
+					Labels: map[string]string{"ci-operator.openshift.io/prowgen-controlled": "true", "ci-operator.openshift.io/variant": "fips"},
+					UtilityConfig: prowconfig.UtilityConfig{
+						DecorationConfig: &v1.DecorationConfig{SkipCloning: &newTrue},
+						Decorate:         true,
+					}},
+				Brancher: prowconfig.Brancher{Branches: []string{"branch"}},
+			},
+		},
 	}
 	for _, tc := range tests {
-		postsubmit := generatePostsubmitForTest(tc.name, tc.repoInfo, tc.treatBranchesAsExplicit, tc.labels, nil) // podSpec tested in TestGeneratePodSpec
+		postsubmit := generatePostsubmitForTest(tc.name, tc.repoInfo, tc.variantSuffix, tc.treatBranchesAsExplicit, tc.labels, nil, &generatorOptions{}) // podSpec tested in TestGeneratePodSpec
 		if !equality.Semantic.DeepEqual(postsubmit, tc.expected) {
 			t.Errorf("expected postsubmit diff:\n%s", diff.ObjectDiff(tc.expected, postsubmit))
 		}
@@ -483,6 +782,7 @@ func TestGenerateJobs(t *testing.T) {
 		id       string
 		config   *ciop.ReleaseBuildConfiguration
 		repoInfo *config.Info
+		prowgen  *config.Prowgen
 
 		expectedPresubmits  map[string][]string
 		expectedPostsubmits map[string][]string
@@ -600,7 +900,7 @@ func TestGenerateJobs(t *testing.T) {
 				}},
 			},
 		}, {
-			id: "Promotion configuration causes --promote job",
+			id: "Promotion configuration causes images postsubmit",
 			config: &ciop.ReleaseBuildConfiguration{
 				Tests:                  []ciop.TestStepConfiguration{},
 				Images:                 []ciop.ProjectDirectoryImageBuildStepConfiguration{{}},
@@ -620,8 +920,9 @@ func TestGenerateJobs(t *testing.T) {
 				}},
 				Postsubmits: map[string][]prowconfig.Postsubmit{"organization/repository": {{
 					JobBase: prowconfig.JobBase{
-						Name:   "branch-ci-organization-repository-branch-images",
-						Labels: standardJobLabels,
+						Name:        "branch-ci-organization-repository-branch-images",
+						Labels:      standardJobLabels,
+						Annotations: map[string]string{jc.ProwJobAnnotationPromotionNamespace: "ci"},
 					}},
 				}},
 			},
@@ -647,12 +948,39 @@ func TestGenerateJobs(t *testing.T) {
 					}},
 				}},
 			},
+		}, {
+			id: "skipping images presubmit still generates the promotion job",
+			config: &ciop.ReleaseBuildConfiguration{
+				Tests:                  []ciop.TestStepConfiguration{},
+				Images:                 []ciop.ProjectDirectoryImageBuildStepConfiguration{{}},
+				PromotionConfiguration: &ciop.PromotionConfiguration{Namespace: "ci"},
+			},
+			repoInfo: &config.Info{
+				Org:    "organization",
+				Repo:   "repository",
+				Branch: "branch",
+			},
+			prowgen: &config.Prowgen{SkipImagesPresubmit: true},
+			expected: &prowconfig.JobConfig{
+				Presubmits: map[string][]prowconfig.Presubmit{},
+				Postsubmits: map[string][]prowconfig.Postsubmit{"organization/repository": {{
+					JobBase: prowconfig.JobBase{
+						Name:        "branch-ci-organization-repository-branch-images",
+						Labels:      standardJobLabels,
+						Annotations: map[string]string{jc.ProwJobAnnotationPromotionNamespace: "ci"},
+					}},
+				}},
+			},
 		},
 	}
 
 	log.SetOutput(ioutil.Discard)
 	for _, tc := range tests {
-		jobConfig := generateJobs(tc.config, tc.repoInfo)
+		prowgen := tc.prowgen
+		if prowgen == nil {
+			prowgen = &config.Prowgen{}
+		}
+		jobConfig := generateJobs(tc.config, tc.repoInfo, prowgen, &generatorOptions{})
 
 		prune(jobConfig) // prune the fields that are tested in TestGeneratePre/PostsubmitForTest
 
@@ -662,6 +990,957 @@ func TestGenerateJobs(t *testing.T) {
 	}
 }
 
+// testConfigSpec returns a configuration with `unit` and `e2e` container tests
+// and an image promoted to the `ci` namespace, so jobs generated from it are
+// presubmits for the tests and images and a postsubmit for the images.
+func testConfigSpec() *ciop.ReleaseBuildConfiguration {
+	return &ciop.ReleaseBuildConfiguration{
+		Images: []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+			{As: "e2e", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+		},
+		PromotionConfiguration: &ciop.PromotionConfiguration{Namespace: "ci"},
+	}
+}
+
+// testInfo returns the repository and branch the jobs for testConfigSpec are
+// generated for
+func testInfo() *config.Info {
+	return &config.Info{Org: "org", Repo: "repo", Branch: "master"}
+}
+
+// presubmitsByName indexes the presubmits generated for org/repo by job name
+func presubmitsByName(jobConfig *prowconfig.JobConfig) map[string]prowconfig.Presubmit {
+	presubmits := map[string]prowconfig.Presubmit{}
+	for _, job := range jobConfig.Presubmits["org/repo"] {
+		presubmits[job.Name] = job
+	}
+	return presubmits
+}
+
+func TestGenerateJobsAlwaysRunPolicy(t *testing.T) {
+	yes, no := true, false
+	testCases := []struct {
+		id      string
+		prowgen *config.Prowgen
+
+		expectedAlwaysRun    map[string]bool
+		expectedRunIfChanged map[string]string
+		expectedPolicy       map[string]bool
+	}{{
+		id:                "no policy runs everything always",
+		prowgen:           &config.Prowgen{},
+		expectedAlwaysRun: map[string]bool{"unit": true, "e2e": true, "images": true},
+		expectedPolicy:    map[string]bool{},
+	}, {
+		id:                "policy for tests does not affect images",
+		prowgen:           &config.Prowgen{AlwaysRun: &no},
+		expectedAlwaysRun: map[string]bool{"unit": false, "e2e": false, "images": true},
+		expectedPolicy:    map[string]bool{"unit": true, "e2e": true},
+	}, {
+		id:                "policy for images",
+		prowgen:           &config.Prowgen{AlwaysRun: &yes, ImagesAlwaysRun: &no},
+		expectedAlwaysRun: map[string]bool{"unit": true, "e2e": true, "images": false},
+		expectedPolicy:    map[string]bool{"unit": true, "e2e": true, "images": true},
+	}, {
+		id: "run_if_changed replaces the policy",
+		prowgen: &config.Prowgen{AlwaysRun: &yes, Tests: map[string]config.ProwgenTest{
+			"unit":   {RunIfChanged: `\.go$`},
+			"images": {RunIfChanged: "^Dockerfile$"},
+		}},
+		expectedAlwaysRun:    map[string]bool{"unit": false, "e2e": true, "images": false},
+		expectedRunIfChanged: map[string]string{"unit": `\.go$`, "images": "^Dockerfile$"},
+		expectedPolicy:       map[string]bool{"unit": true, "e2e": true, "images": true},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			jobConfig := generateJobs(testConfigSpec(), testInfo(), tc.prowgen, &generatorOptions{})
+
+			alwaysRun, runIfChanged, policy := map[string]bool{}, map[string]string{}, map[string]bool{}
+			for _, job := range jobConfig.Presubmits["org/repo"] {
+				test := strings.TrimPrefix(job.Name, "pull-ci-org-repo-master-")
+				alwaysRun[test] = job.AlwaysRun
+				if job.RunIfChanged != "" {
+					runIfChanged[test] = job.RunIfChanged
+				}
+				if _, ok := job.Annotations[jc.ProwJobAnnotationAlwaysRunPolicy]; ok {
+					policy[test] = true
+				}
+			}
+			if !reflect.DeepEqual(tc.expectedAlwaysRun, alwaysRun) {
+				t.Errorf("unexpected always_run: %s", diff.ObjectReflectDiff(tc.expectedAlwaysRun, alwaysRun))
+			}
+			if len(tc.expectedRunIfChanged)+len(runIfChanged) > 0 && !reflect.DeepEqual(tc.expectedRunIfChanged, runIfChanged) {
+				t.Errorf("unexpected run_if_changed: %s", diff.ObjectReflectDiff(tc.expectedRunIfChanged, runIfChanged))
+			}
+			if invalid := jc.InvalidTriggers(jobConfig); len(invalid) > 0 {
+				t.Errorf("presubmits cannot be triggered: %v", invalid)
+			}
+			if !reflect.DeepEqual(tc.expectedPolicy, policy) {
+				t.Errorf("unexpected policy annotations: %s", diff.ObjectReflectDiff(tc.expectedPolicy, policy))
+			}
+		})
+	}
+}
+
+func TestGenerateJobsPodSettings(t *testing.T) {
+	yes, no := true, false
+	tolerations := []kubeapi.Toleration{{Key: "dedicated", Operator: kubeapi.TolerationOpEqual, Value: "e2e", Effect: kubeapi.TaintEffectNoSchedule}}
+	argsWithPrefix := func(spec *kubeapi.PodSpec, prefix string) []string {
+		var args []string
+		for _, arg := range spec.Containers[0].Args {
+			if strings.HasPrefix(arg, prefix) {
+				args = append(args, arg)
+			}
+		}
+		return args
+	}
+	testCases := []struct {
+		id      string
+		prowgen *config.Prowgen
+		opts    *generatorOptions
+
+		// setting extracts the setting under test from a generated job
+		setting  func(job prowconfig.JobBase) interface{}
+		expected map[string]interface{}
+	}{{
+		id: "scheduling",
+		prowgen: &config.Prowgen{
+			Scheduling: config.Scheduling{NodeSelector: map[string]string{"pool": "default"}},
+			Tests: map[string]config.ProwgenTest{
+				"e2e":    {Scheduling: config.Scheduling{NodeSelector: map[string]string{"pool": "heavy"}, Tolerations: tolerations}},
+				"images": {Scheduling: config.Scheduling{NodeSelector: map[string]string{"pool": "builds"}}},
+			},
+		},
+		setting: func(job prowconfig.JobBase) interface{} {
+			return config.Scheduling{NodeSelector: job.Spec.NodeSelector, Tolerations: job.Spec.Tolerations}
+		},
+		expected: map[string]interface{}{
+			"pull-ci-org-repo-master-unit":     config.Scheduling{NodeSelector: map[string]string{"pool": "default"}},
+			"pull-ci-org-repo-master-e2e":      config.Scheduling{NodeSelector: map[string]string{"pool": "heavy"}, Tolerations: tolerations},
+			"pull-ci-org-repo-master-images":   config.Scheduling{NodeSelector: map[string]string{"pool": "builds"}},
+			"branch-ci-org-repo-master-images": config.Scheduling{NodeSelector: map[string]string{"pool": "builds"}},
+		},
+	}, {
+		id: "resources",
+		prowgen: &config.Prowgen{
+			Tests: map[string]config.ProwgenTest{
+				"e2e":    {Resources: &config.Resources{Requests: map[string]string{"memory": "4Gi"}, Limits: map[string]string{"cpu": "2", "memory": "8Gi"}}},
+				"images": {Resources: &config.Resources{Requests: map[string]string{"cpu": "500m"}}},
+			},
+		},
+		setting: func(job prowconfig.JobBase) interface{} {
+			return job.Spec.Containers[0].Resources
+		},
+		expected: map[string]interface{}{
+			"pull-ci-org-repo-master-unit": kubeapi.ResourceRequirements{Requests: kubeapi.ResourceList{"cpu": resource.MustParse("10m")}},
+			"pull-ci-org-repo-master-e2e": kubeapi.ResourceRequirements{
+				Requests: kubeapi.ResourceList{"cpu": resource.MustParse("10m"), "memory": resource.MustParse("4Gi")},
+				Limits:   kubeapi.ResourceList{"cpu": resource.MustParse("2"), "memory": resource.MustParse("8Gi")},
+			},
+			"pull-ci-org-repo-master-images":   kubeapi.ResourceRequirements{Requests: kubeapi.ResourceList{"cpu": resource.MustParse("500m")}},
+			"branch-ci-org-repo-master-images": kubeapi.ResourceRequirements{Requests: kubeapi.ResourceList{"cpu": resource.MustParse("500m")}},
+		},
+	}, {
+		id: "decoration timeouts",
+		prowgen: &config.Prowgen{
+			Tests: map[string]config.ProwgenTest{
+				"e2e":    {Timeout: &v1.Duration{Duration: 4 * time.Hour}, GracePeriod: &v1.Duration{Duration: 15 * time.Minute}},
+				"images": {Timeout: &v1.Duration{Duration: 3 * time.Hour}},
+			},
+		},
+		opts: &generatorOptions{decorationTimeout: 2 * time.Hour, decorationGracePeriod: 10 * time.Minute},
+		setting: func(job prowconfig.JobBase) interface{} {
+			data, err := yaml.Marshal(v1.DecorationConfig{Timeout: job.DecorationConfig.Timeout, GracePeriod: job.DecorationConfig.GracePeriod})
+			if err != nil {
+				return err
+			}
+			return string(data)
+		},
+		expected: map[string]interface{}{
+			"pull-ci-org-repo-master-unit":     "grace_period: 10m0s\ntimeout: 2h0m0s\n",
+			"pull-ci-org-repo-master-e2e":      "grace_period: 15m0s\ntimeout: 4h0m0s\n",
+			"pull-ci-org-repo-master-images":   "grace_period: 10m0s\ntimeout: 3h0m0s\n",
+			"branch-ci-org-repo-master-images": "grace_period: 10m0s\ntimeout: 2h0m0s\n",
+		},
+	}, {
+		id:      "leases",
+		prowgen: &config.Prowgen{Tests: map[string]config.ProwgenTest{"e2e": {Leases: true}}},
+		opts:    &generatorOptions{leaseServer: "http://boskos", leaseServerUsername: "ci", leaseServerCredentialsSecret: "boskos-credentials"},
+		setting: func(job prowconfig.JobBase) interface{} {
+			leases := argsWithPrefix(job.Spec, "--lease-server")
+			for _, volume := range job.Spec.Volumes {
+				if volume.Secret != nil && volume.Secret.SecretName == "boskos-credentials" {
+					leases = append(leases, volume.Name)
+				}
+			}
+			return leases
+		},
+		expected: map[string]interface{}{
+			"pull-ci-org-repo-master-unit":     []string(nil),
+			"pull-ci-org-repo-master-e2e":      []string{"--lease-server=http://boskos", "--lease-server-username=ci", "--lease-server-password-file=/etc/boskos/password", "boskos"},
+			"pull-ci-org-repo-master-images":   []string(nil),
+			"branch-ci-org-repo-master-images": []string(nil),
+		},
+	}, {
+		id: "PR author access",
+		prowgen: &config.Prowgen{
+			PRAuthorAccess: &no,
+			Tests:          map[string]config.ProwgenTest{"unit": {PRAuthorAccess: &yes}},
+		},
+		setting: func(job prowconfig.JobBase) interface{} {
+			return argsWithPrefix(job.Spec, prAuthorAccessArg)
+		},
+		expected: map[string]interface{}{
+			"pull-ci-org-repo-master-unit":     []string{"--give-pr-author-access-to-namespace=true"},
+			"pull-ci-org-repo-master-e2e":      []string{"--give-pr-author-access-to-namespace=false"},
+			"pull-ci-org-repo-master-images":   []string{"--give-pr-author-access-to-namespace=false"},
+			"branch-ci-org-repo-master-images": []string{"--give-pr-author-access-to-namespace=true"},
+		},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			opts := tc.opts
+			if opts == nil {
+				opts = &generatorOptions{}
+			}
+			jobConfig := generateJobs(testConfigSpec(), testInfo(), tc.prowgen, opts)
+
+			actual := map[string]interface{}{}
+			for _, job := range jobConfig.Presubmits["org/repo"] {
+				actual[job.Name] = tc.setting(job.JobBase)
+			}
+			for _, job := range jobConfig.Postsubmits["org/repo"] {
+				actual[job.Name] = tc.setting(job.JobBase)
+			}
+			if !equality.Semantic.DeepEqual(tc.expected, actual) {
+				t.Errorf("unexpected %s: %s", tc.id, diff.ObjectReflectDiff(tc.expected, actual))
+			}
+		})
+	}
+}
+
+func TestGenerateJobsForConfigProwgenErrors(t *testing.T) {
+	testCases := []struct {
+		id      string
+		prowgen string
+		opts    *generatorOptions
+
+		expectedErr string
+	}{{
+		id:      "valid settings",
+		prowgen: "tests:\n  e2e:\n    leases: true\n",
+		opts:    &generatorOptions{leaseServer: "http://boskos", leaseServerUsername: "ci", leaseServerCredentialsSecret: "boskos-credentials"},
+	}, {
+		id:          "invalid resource quantity",
+		prowgen:     "tests:\n  e2e:\n    resources:\n      limits:\n        memory: 8 GB\n",
+		expectedErr: "tests.e2e.resources",
+	}, {
+		id:          "tests needing leases without a lease server",
+		prowgen:     "tests:\n  e2e:\n    leases: true\n",
+		expectedErr: "need leases",
+	}, {
+		id:          "trigger which does not compile",
+		prowgen:     "job_overrides:\n  pull-ci-org-repo-master-e2e:\n    trigger: '(?m)^/test (e2e'\n",
+		expectedErr: "cannot be triggered",
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "prowgen-errors")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+			if err := ioutil.WriteFile(filepath.Join(tmp, config.ProwgenFile), []byte(tc.prowgen), 0644); err != nil {
+				t.Fatal(err)
+			}
+			info := testInfo()
+			info.Filename = filepath.Join(tmp, "org-repo-master.yaml")
+			opts := tc.opts
+			if opts == nil {
+				opts = &generatorOptions{}
+			}
+			opts.readProwgenConfigs = true
+
+			_, err = generateJobsForConfig(testConfigSpec(), info, opts)
+			if tc.expectedErr == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tc.expectedErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectedErr)) {
+				t.Errorf("expected an error containing %q, got %v", tc.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestGenerateJobsTriggers(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Tests: []ciop.TestStepConfiguration{
+			{As: "e2e-4.1", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+			{As: "c++", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+		},
+	}
+	tmp, err := ioutil.TempDir("", "prowgen-triggers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "master", Filename: filepath.Join(tmp, "org-repo-master.yaml")}
+
+	jobConfig, err := generateJobsForConfig(configSpec, info, &generatorOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"pull-ci-org-repo-master-e2e-4.1": `(?m)^/test( | .* )e2e-4\.1,?($|\s.*)`,
+		"pull-ci-org-repo-master-c++":     `(?m)^/test( | .* )c\+\+,?($|\s.*)`,
+	}
+	triggers := map[string]string{}
+	for _, job := range jobConfig.Presubmits["org/repo"] {
+		triggers[job.Name] = job.Trigger
+	}
+	if !reflect.DeepEqual(expected, triggers) {
+		t.Errorf("unexpected triggers: %s", diff.ObjectReflectDiff(expected, triggers))
+	}
+}
+
+func TestGenerateJobsClusterTypeLabel(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Images: []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		Tests: []ciop.TestStepConfiguration{
+			{As: "e2e-aws", OpenshiftInstallerClusterTestConfiguration: &ciop.OpenshiftInstallerClusterTestConfiguration{
+				ClusterTestConfiguration: ciop.ClusterTestConfiguration{ClusterProfile: ciop.ClusterProfileAWS},
+			}},
+			{As: "e2e-gcp", OpenshiftInstallerClusterTestConfiguration: &ciop.OpenshiftInstallerClusterTestConfiguration{
+				ClusterTestConfiguration: ciop.ClusterTestConfiguration{ClusterProfile: ciop.ClusterProfileGCP},
+			}},
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+		},
+	}
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "master"}
+	testCases := []struct {
+		id       string
+		label    string
+		expected map[string]string
+	}{
+		{
+			id:       "label is not set by default",
+			expected: map[string]string{},
+		},
+		{
+			id:    "label is set for tests with a cluster type",
+			label: "ci-operator.openshift.io/cloud",
+			expected: map[string]string{
+				"pull-ci-org-repo-master-e2e-aws": "aws",
+				"pull-ci-org-repo-master-e2e-gcp": "gcp",
+			},
+		},
+	}
+	for _, tc := range testCases {
+		jobConfig := generateJobs(configSpec, info, &config.Prowgen{}, &generatorOptions{clusterTypeLabel: tc.label})
+		labels := map[string]string{}
+		for _, job := range jobConfig.Presubmits["org/repo"] {
+			for key, value := range job.Labels {
+				if key != jc.ProwJobLabelGenerated {
+					labels[job.Name] = value
+				}
+			}
+		}
+		if !reflect.DeepEqual(tc.expected, labels) {
+			t.Errorf("%s: unexpected labels: %s", tc.id, diff.ObjectReflectDiff(tc.expected, labels))
+		}
+	}
+}
+
+func TestGenerateJobsClusterProfiles(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Tests: []ciop.TestStepConfiguration{
+			{As: "e2e", OpenshiftInstallerClusterTestConfiguration: &ciop.OpenshiftInstallerClusterTestConfiguration{
+				ClusterTestConfiguration: ciop.ClusterTestConfiguration{ClusterProfile: ciop.ClusterProfileAWS},
+			}},
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+		},
+	}
+	prowgen := &config.Prowgen{Tests: map[string]config.ProwgenTest{
+		"e2e":  {ClusterProfiles: map[string]ciop.ClusterProfile{"4.1": ciop.ClusterProfileGCP}},
+		"unit": {ClusterProfiles: map[string]ciop.ClusterProfile{"4.1": ciop.ClusterProfileGCP}},
+	}}
+	testCases := []struct {
+		branch   string
+		expected map[string]string
+	}{
+		{branch: "master", expected: map[string]string{"e2e": "cluster-secrets-aws"}},
+		{branch: "release-4.1", expected: map[string]string{"e2e": "cluster-secrets-gcp"}},
+	}
+	for _, tc := range testCases {
+		jobConfig := generateJobs(configSpec, &config.Info{Org: "org", Repo: "repo", Branch: tc.branch}, prowgen, &generatorOptions{})
+		secrets := map[string]string{}
+		for _, job := range jobConfig.Presubmits["org/repo"] {
+			for _, volume := range job.Spec.Volumes {
+				if volume.Name == "cluster-profile" {
+					secrets[job.Context[len("ci/prow/"):]] = volume.Projected.Sources[0].Secret.Name
+				}
+			}
+		}
+		if !reflect.DeepEqual(tc.expected, secrets) {
+			t.Errorf("%s: unexpected cluster profile secrets: %s", tc.branch, diff.ObjectReflectDiff(tc.expected, secrets))
+		}
+	}
+}
+
+func TestGenerateJobsOptional(t *testing.T) {
+	prowgen := &config.Prowgen{Tests: map[string]config.ProwgenTest{"e2e": {Optional: true}}}
+	jobConfig := generateJobs(testConfigSpec(), testInfo(), prowgen, &generatorOptions{})
+
+	presubmits := presubmitsByName(jobConfig)
+	var names []string
+	for name := range presubmits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	expectedNames := []string{"pull-ci-org-repo-master-e2e", "pull-ci-org-repo-master-images", "pull-ci-org-repo-master-optional-e2e", "pull-ci-org-repo-master-unit"}
+	if !reflect.DeepEqual(expectedNames, names) {
+		t.Fatalf("unexpected presubmits: %s", diff.ObjectReflectDiff(expectedNames, names))
+	}
+
+	required, optional := presubmits["pull-ci-org-repo-master-e2e"], presubmits["pull-ci-org-repo-master-optional-e2e"]
+	if !required.AlwaysRun || required.Optional || required.Context != "ci/prow/e2e" {
+		t.Errorf("expected the presubmit for the test to be required, got always_run %t, optional %t, context %s", required.AlwaysRun, required.Optional, required.Context)
+	}
+	if optional.AlwaysRun || !optional.Optional || optional.Context != "ci/prow/optional-e2e" || optional.RerunCommand != "/test optional-e2e" {
+		t.Errorf("expected the optional presubmit to run on request, got always_run %t, optional %t, context %s, rerun command %s", optional.AlwaysRun, optional.Optional, optional.Context, optional.RerunCommand)
+	}
+	if !equality.Semantic.DeepEqual(required.Spec, optional.Spec) {
+		t.Errorf("expected the optional presubmit to run the same pod: %s", diff.ObjectReflectDiff(required.Spec, optional.Spec))
+	}
+	for job, comment := range map[string]string{required.Name: "/test optional-e2e", optional.Name: "/test e2e"} {
+		if regexp.MustCompile(presubmits[job].Trigger).MatchString(comment) {
+			t.Errorf("%s: trigger should not match %q", job, comment)
+		}
+	}
+	if invalid := jc.InvalidTriggers(jobConfig); len(invalid) > 0 {
+		t.Errorf("presubmits cannot be triggered: %v", invalid)
+	}
+
+	contexts := requiredContexts{}
+	contexts.add(jobConfig)
+	if expected := []string{"ci/prow/e2e", "ci/prow/images", "ci/prow/unit"}; !reflect.DeepEqual(expected, contexts["org"]["repo"]["master"].List()) {
+		t.Errorf("unexpected required contexts: %s", diff.ObjectReflectDiff(expected, contexts["org"]["repo"]["master"].List()))
+	}
+}
+
+func TestGenerateJobsPeriodics(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+			{As: "e2e", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+			{As: "nightly", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+		},
+	}
+	prowgen := &config.Prowgen{Tests: map[string]config.ProwgenTest{
+		"e2e":     {Interval: "24h", Timeout: &v1.Duration{Duration: 4 * time.Hour}},
+		"nightly": {Cron: "0 4 * * *"},
+	}}
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "master", Variant: "variant"}
+	jobConfig := generateJobs(configSpec, info, prowgen, &generatorOptions{})
+
+	presubmits := presubmitsByName(jobConfig)
+	if len(presubmits) != 3 {
+		t.Errorf("expected presubmits for all tests, got %d", len(presubmits))
+	}
+
+	type schedule struct{ cron, interval string }
+	expected := map[string]schedule{
+		"periodic-ci-org-repo-master-variant-e2e":     {interval: "24h"},
+		"periodic-ci-org-repo-master-variant-nightly": {cron: "0 4 * * *"},
+	}
+	actual := map[string]schedule{}
+	for _, job := range jobConfig.Periodics {
+		actual[job.Name] = schedule{cron: job.Cron, interval: job.Interval}
+
+		expectedRefs := []v1.Refs{{Org: "org", Repo: "repo", BaseRef: "master"}}
+		if !reflect.DeepEqual(expectedRefs, job.ExtraRefs) {
+			t.Errorf("%s: unexpected extra refs: %s", job.Name, diff.ObjectReflectDiff(expectedRefs, job.ExtraRefs))
+		}
+		if job.DecorationConfig == nil || job.DecorationConfig.SkipCloning == nil || !*job.DecorationConfig.SkipCloning {
+			t.Errorf("%s: expected the periodic to skip cloning", job.Name)
+		}
+		if job.Labels[jc.ProwJobLabelGenerated] != jc.Generated || job.Labels[prowJobLabelVariant] != "variant" {
+			t.Errorf("%s: unexpected labels: %v", job.Name, job.Labels)
+		}
+		presubmit := presubmits[strings.Replace(job.Name, "periodic-", "pull-", 1)]
+		if !equality.Semantic.DeepEqual(presubmit.Spec, job.Spec) {
+			t.Errorf("%s: expected the pod spec of the presubmit: %s", job.Name, diff.ObjectReflectDiff(presubmit.Spec, job.Spec))
+		}
+		if !reflect.DeepEqual(presubmit.DecorationConfig, job.DecorationConfig) {
+			t.Errorf("%s: expected the decoration config of the presubmit: %s", job.Name, diff.ObjectReflectDiff(presubmit.DecorationConfig, job.DecorationConfig))
+		}
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected periodics: %s", diff.ObjectReflectDiff(expected, actual))
+	}
+}
+
+func TestFindConfigsWithoutJobs(t *testing.T) {
+	configs := map[string]*ciop.ReleaseBuildConfiguration{
+		"org/repo/org-repo-tests.yaml": {
+			Tests: []ciop.TestStepConfiguration{{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}}},
+		},
+		"org/repo/org-repo-images.yaml": {
+			Images: []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		},
+		"org/repo/org-repo-nothing.yaml": {},
+		"org/repo/org-repo-other.yaml":   {},
+	}
+	var empty []string
+	callback := findConfigsWithoutJobs(&empty, &generatorOptions{})
+	for filename, configSpec := range configs {
+		info := &config.Info{Org: "org", Repo: "repo", Branch: "master", Filename: filename}
+		if err := callback(configSpec, info); err != nil {
+			t.Fatalf("%s: unexpected error: %v", filename, err)
+		}
+	}
+	sort.Strings(empty)
+	expected := []string{"org/repo/org-repo-nothing.yaml", "org/repo/org-repo-other.yaml"}
+	if !reflect.DeepEqual(expected, empty) {
+		t.Errorf("unexpected configs without jobs: %s", diff.ObjectReflectDiff(expected, empty))
+	}
+}
+
+func TestGeneratePresubmit(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		InputConfiguration: ciop.InputConfiguration{
+			ReleaseTagConfiguration: &ciop.ReleaseTagConfiguration{Namespace: "ocp", Name: "4.0"},
+		},
+		Images: []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+			{As: "e2e", OpenshiftInstallerClusterTestConfiguration: &ciop.OpenshiftInstallerClusterTestConfiguration{
+				ClusterTestConfiguration: ciop.ClusterTestConfiguration{ClusterProfile: "aws"},
+			}},
+		},
+		PromotionConfiguration: &ciop.PromotionConfiguration{Namespace: "ocp", Name: "4.0"},
+	}
+	info := &config.Info{Org: "org", Repo: "repo", Branch: "master"}
+	opts := &generatorOptions{orgRemaps: map[string]string{"org": "fork"}}
+	prowgen := &config.Prowgen{}
+
+	jobConfig := generateJobs(configSpec, info, prowgen, opts)
+	for _, expected := range jobConfig.Presubmits["fork/repo"] {
+		test := strings.TrimPrefix(expected.Name, "pull-ci-fork-repo-master-")
+		t.Run(test, func(t *testing.T) {
+			presubmit := generatePresubmit(configSpec, info, prowgen, opts, test)
+			if presubmit == nil {
+				t.Fatalf("expected a presubmit, got none")
+			}
+			if !equality.Semantic.DeepEqual(&expected, presubmit) {
+				t.Errorf("presubmit differs from the one generated with all jobs:\n%s", diff.ObjectReflectDiff(&expected, presubmit))
+			}
+		})
+	}
+	if len(jobConfig.Presubmits["fork/repo"]) != 3 {
+		t.Errorf("expected three presubmits to be generated, got %d", len(jobConfig.Presubmits["fork/repo"]))
+	}
+
+	if presubmit := generatePresubmit(configSpec, info, prowgen, opts, "unknown"); presubmit != nil {
+		t.Errorf("expected no presubmit for an unknown test, got %s", presubmit.Name)
+	}
+	if presubmit := generatePresubmit(configSpec, info, &config.Prowgen{SkipImagesPresubmit: true}, opts, "images"); presubmit != nil {
+		t.Errorf("expected no presubmit for skipped images, got %s", presubmit.Name)
+	}
+}
+
+func TestGenerateJobsPromotionArgs(t *testing.T) {
+	hasArg := func(spec *kubeapi.PodSpec, arg string) bool {
+		for _, a := range spec.Containers[0].Args {
+			if a == arg {
+				return true
+			}
+		}
+		return false
+	}
+	testCases := []struct {
+		id        string
+		promotion *ciop.PromotionConfiguration
+
+		expectPostsubmit     bool
+		expectPromote        bool
+		expectReleasePayload bool
+	}{{
+		id: "no promotion",
+	}, {
+		id:                   "promotion to the official ocp namespace",
+		promotion:            &ciop.PromotionConfiguration{Namespace: "ocp", Name: "4.0"},
+		expectPostsubmit:     true,
+		expectPromote:        true,
+		expectReleasePayload: true,
+	}, {
+		id:                   "promotion to the official origin-v4.0 imagestream",
+		promotion:            &ciop.PromotionConfiguration{Namespace: "openshift", Name: "origin-v4.0"},
+		expectPostsubmit:     true,
+		expectPromote:        true,
+		expectReleasePayload: true,
+	}, {
+		id:               "promotion to an unofficial namespace",
+		promotion:        &ciop.PromotionConfiguration{Namespace: "ci", Name: "other"},
+		expectPostsubmit: true,
+	}, {
+		id:               "disabled promotion to the official namespace",
+		promotion:        &ciop.PromotionConfiguration{Namespace: "ocp", Name: "4.0", Disabled: true},
+		expectPostsubmit: true,
+	}}
+	for _, tc := range testCases {
+		configSpec := &ciop.ReleaseBuildConfiguration{
+			Images:                 []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+			PromotionConfiguration: tc.promotion,
+		}
+		jobConfig := generateJobs(configSpec, testInfo(), &config.Prowgen{}, &generatorOptions{})
+
+		for _, job := range jobConfig.Presubmits["org/repo"] {
+			if hasArg(job.Spec, "--promote") {
+				t.Errorf("%s: presubmit %s must never promote", tc.id, job.Name)
+			}
+			if hasArg(job.Spec, "--target=[release:latest]") != tc.expectReleasePayload {
+				t.Errorf("%s: expected presubmit %s to request the release payload: %t", tc.id, job.Name, tc.expectReleasePayload)
+			}
+		}
+
+		postsubmits := jobConfig.Postsubmits["org/repo"]
+		if (len(postsubmits) > 0) != tc.expectPostsubmit {
+			t.Errorf("%s: expected a promoting postsubmit: %t, got %d postsubmits", tc.id, tc.expectPostsubmit, len(postsubmits))
+		}
+		for _, job := range postsubmits {
+			if hasArg(job.Spec, "--promote") != tc.expectPromote {
+				t.Errorf("%s: expected postsubmit %s to promote: %t", tc.id, job.Name, tc.expectPromote)
+			}
+			if hasArg(job.Spec, "--target=[release:latest]") {
+				t.Errorf("%s: postsubmit %s must not request the release payload", tc.id, job.Name)
+			}
+			if job.Annotations[jc.ProwJobAnnotationPromotionNamespace] != tc.promotion.Namespace || job.Annotations[jc.ProwJobAnnotationPromotionName] != tc.promotion.Name {
+				t.Errorf("%s: postsubmit %s has unexpected promotion annotations: %v", tc.id, job.Name, job.Annotations)
+			}
+		}
+	}
+}
+
+func TestGenerateJobsWithBranchAlias(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "from"}},
+		},
+		Images:                 []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		PromotionConfiguration: &ciop.PromotionConfiguration{Namespace: "ci"},
+	}
+	info := &config.Info{Org: "organization", Repo: "repository", Branch: "master"}
+	opts := &generatorOptions{branchAliases: map[string]map[string]string{
+		"organization/repository": {"master": "main"},
+		"organization/other":      {"release": "stable"},
+	}}
+
+	jobConfig := generateJobs(configSpec, info, &config.Prowgen{}, opts)
+
+	var presubmits []string
+	for _, job := range jobConfig.Presubmits["organization/repository"] {
+		presubmits = append(presubmits, fmt.Sprintf("%s %s %v", job.Name, job.Context, job.Branches))
+		if key := job.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key; key != info.Basename() {
+			t.Errorf("%s: expected job to use configuration %s, got %s", job.Name, info.Basename(), key)
+		}
+	}
+	expectedPresubmits := []string{
+		"pull-ci-organization-repository-main-unit ci/prow/unit [main]",
+		"pull-ci-organization-repository-main-images ci/prow/images [main]",
+	}
+	if !reflect.DeepEqual(expectedPresubmits, presubmits) {
+		t.Errorf("unexpected presubmits: %s", diff.ObjectReflectDiff(expectedPresubmits, presubmits))
+	}
+
+	var postsubmits []string
+	for _, job := range jobConfig.Postsubmits["organization/repository"] {
+		postsubmits = append(postsubmits, fmt.Sprintf("%s %v", job.Name, job.Branches))
+	}
+	expectedPostsubmits := []string{"branch-ci-organization-repository-main-images [^main$]"}
+	if !reflect.DeepEqual(expectedPostsubmits, postsubmits) {
+		t.Errorf("unexpected postsubmits: %s", diff.ObjectReflectDiff(expectedPostsubmits, postsubmits))
+	}
+}
+
+func TestGenerateJobsWithBranchGlob(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "from"}},
+		},
+		Images:                 []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		PromotionConfiguration: &ciop.PromotionConfiguration{Namespace: "ci"},
+	}
+	info := &config.Info{Org: "organization", Repo: "repository", Branch: "release-4.*"}
+	prowgen := &config.Prowgen{Tests: map[string]config.ProwgenTest{"unit": {Cron: "0 4 * * *"}}}
+
+	jobConfig := generateJobs(configSpec, info, prowgen, &generatorOptions{})
+
+	var presubmits []string
+	for _, job := range jobConfig.Presubmits["organization/repository"] {
+		presubmits = append(presubmits, fmt.Sprintf("%s %v", job.Name, job.Branches))
+		if key := job.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key; key != "organization-repository-release-4._.yaml" {
+			t.Errorf("%s: expected job to use the sanitized key of the configuration, got %s", job.Name, key)
+		}
+	}
+	expectedPresubmits := []string{
+		`pull-ci-organization-repository-release-4-unit [release-4.*]`,
+		`pull-ci-organization-repository-release-4-images [release-4.*]`,
+	}
+	if !reflect.DeepEqual(expectedPresubmits, presubmits) {
+		t.Errorf("unexpected presubmits: %s", diff.ObjectReflectDiff(expectedPresubmits, presubmits))
+	}
+
+	var postsubmits []string
+	for _, job := range jobConfig.Postsubmits["organization/repository"] {
+		postsubmits = append(postsubmits, fmt.Sprintf("%s %v", job.Name, job.Branches))
+	}
+	expectedPostsubmits := []string{`branch-ci-organization-repository-release-4-images [release-4.*]`}
+	if !reflect.DeepEqual(expectedPostsubmits, postsubmits) {
+		t.Errorf("unexpected postsubmits: %s", diff.ObjectReflectDiff(expectedPostsubmits, postsubmits))
+	}
+
+	if len(jobConfig.Periodics) != 0 {
+		t.Errorf("expected no periodics for a glob branch, got %d", len(jobConfig.Periodics))
+	}
+}
+
+func TestGenerateJobsWithOrgRemap(t *testing.T) {
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "from"}},
+		},
+	}
+	info := &config.Info{Org: "openshift", Repo: "repository", Branch: "master"}
+	opts := &generatorOptions{
+		orgRemaps:     map[string]string{"openshift": "fork"},
+		branchAliases: map[string]map[string]string{"openshift/repository": {"master": "main"}},
+	}
+
+	jobConfig := generateJobs(configSpec, info, &config.Prowgen{}, opts)
+
+	if _, ok := jobConfig.Presubmits["openshift/repository"]; ok {
+		t.Errorf("expected no jobs for the original organization")
+	}
+	jobs := jobConfig.Presubmits["fork/repository"]
+	if len(jobs) != 1 {
+		t.Fatalf("expected one job for the remapped organization, got %d", len(jobs))
+	}
+	if expected := "pull-ci-fork-repository-main-unit"; jobs[0].Name != expected {
+		t.Errorf("expected job name %s, got %s", expected, jobs[0].Name)
+	}
+	if key := jobs[0].Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key; key != info.Basename() {
+		t.Errorf("expected job to use configuration %s, got %s", info.Basename(), key)
+	}
+}
+
+func TestParseOrgRemaps(t *testing.T) {
+	testCases := []struct {
+		id          string
+		values      []string
+		expected    map[string]string
+		expectedErr bool
+	}{{
+		id:       "no remaps",
+		expected: map[string]string{},
+	}, {
+		id:       "multiple remaps",
+		values:   []string{"openshift=fork", "openshift-priv=fork-priv"},
+		expected: map[string]string{"openshift": "fork", "openshift-priv": "fork-priv"},
+	}, {
+		id:          "missing target",
+		values:      []string{"openshift="},
+		expectedErr: true,
+	}, {
+		id:          "repository instead of organization",
+		values:      []string{"openshift/origin=fork/origin"},
+		expectedErr: true,
+	}, {
+		id:          "conflicting remaps",
+		values:      []string{"openshift=fork", "openshift=other"},
+		expectedErr: true,
+	}}
+	for _, tc := range testCases {
+		remaps, err := parseOrgRemaps(tc.values)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error: %t, got: %v", tc.id, tc.expectedErr, err)
+			continue
+		}
+		if !tc.expectedErr && !reflect.DeepEqual(tc.expected, remaps) {
+			t.Errorf("%s: unexpected remaps: %s", tc.id, diff.ObjectReflectDiff(tc.expected, remaps))
+		}
+	}
+}
+
+func TestParseJobLabels(t *testing.T) {
+	testCases := []struct {
+		id          string
+		values      []string
+		expected    map[string]string
+		expectedErr bool
+	}{{
+		id:       "no labels",
+		expected: map[string]string{},
+	}, {
+		id:       "multiple labels",
+		values:   []string{"cost-center=ci", "example.com/team=", "cost-center=ci"},
+		expected: map[string]string{"cost-center": "ci", "example.com/team": ""},
+	}, {
+		id:          "missing value",
+		values:      []string{"cost-center"},
+		expectedErr: true,
+	}, {
+		id:          "invalid key",
+		values:      []string{"cost center=ci"},
+		expectedErr: true,
+	}, {
+		id:          "invalid value",
+		values:      []string{"cost-center=ci/infra"},
+		expectedErr: true,
+	}, {
+		id:          "label set by the generator",
+		values:      []string{jc.ProwJobLabelGenerated + "=false"},
+		expectedErr: true,
+	}, {
+		id:          "cluster type label",
+		values:      []string{"ci-operator.openshift.io/cloud=aws"},
+		expectedErr: true,
+	}, {
+		id:          "conflicting labels",
+		values:      []string{"cost-center=ci", "cost-center=art"},
+		expectedErr: true,
+	}}
+	for _, tc := range testCases {
+		labels, err := parseJobLabels(tc.values, "ci-operator.openshift.io/cloud")
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error: %t, got: %v", tc.id, tc.expectedErr, err)
+			continue
+		}
+		if !tc.expectedErr && !reflect.DeepEqual(tc.expected, labels) {
+			t.Errorf("%s: unexpected labels: %s", tc.id, diff.ObjectReflectDiff(tc.expected, labels))
+		}
+	}
+}
+
+func TestApplyJobLabels(t *testing.T) {
+	jobConfig := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {{
+			JobBase: prowconfig.JobBase{Name: "presubmit", Labels: map[string]string{jc.ProwJobLabelGenerated: jc.Generated, "cost-center": "art"}},
+		}}},
+		Postsubmits: map[string][]prowconfig.Postsubmit{"org/repo": {{
+			JobBase: prowconfig.JobBase{Name: "postsubmit"},
+		}}},
+		Periodics: []prowconfig.Periodic{{
+			JobBase: prowconfig.JobBase{Name: "periodic", Labels: map[string]string{jc.ProwJobLabelGenerated: jc.Generated}},
+		}},
+	}
+	applyJobLabels(jobConfig, &generatorOptions{jobLabels: map[string]string{"cost-center": "ci", "team": "dptp"}})
+
+	expected := map[string]map[string]string{
+		"presubmit":  {jc.ProwJobLabelGenerated: jc.Generated, "cost-center": "art", "team": "dptp"},
+		"postsubmit": {"cost-center": "ci", "team": "dptp"},
+		"periodic":   {jc.ProwJobLabelGenerated: jc.Generated, "cost-center": "ci", "team": "dptp"},
+	}
+	labels := map[string]map[string]string{
+		"presubmit":  jobConfig.Presubmits["org/repo"][0].Labels,
+		"postsubmit": jobConfig.Postsubmits["org/repo"][0].Labels,
+		"periodic":   jobConfig.Periodics[0].Labels,
+	}
+	if !reflect.DeepEqual(expected, labels) {
+		t.Errorf("unexpected labels: %s", diff.ObjectReflectDiff(expected, labels))
+	}
+}
+
+func TestDecorationConfig(t *testing.T) {
+	newTrue := true
+	testCases := []struct {
+		id       string
+		opts     *generatorOptions
+		expected *v1.DecorationConfig
+	}{{
+		id:       "defaults are inherited",
+		opts:     &generatorOptions{},
+		expected: &v1.DecorationConfig{SkipCloning: &newTrue},
+	}, {
+		id:   "timeout and grace period are set",
+		opts: &generatorOptions{decorationTimeout: 4 * time.Hour, decorationGracePeriod: 15 * time.Minute},
+		expected: &v1.DecorationConfig{
+			SkipCloning: &newTrue,
+			Timeout:     &v1.Duration{Duration: 4 * time.Hour},
+			GracePeriod: &v1.Duration{Duration: 15 * time.Minute},
+		},
+	}, {
+		id: "utility images are set",
+		opts: &generatorOptions{utilityImages: v1.UtilityImages{
+			CloneRefs:  "registry.local/clonerefs:v1",
+			InitUpload: "registry.local/initupload:v1",
+			Entrypoint: "registry.local/entrypoint:v1",
+			Sidecar:    "registry.local/sidecar:v1",
+		}},
+		expected: &v1.DecorationConfig{
+			SkipCloning: &newTrue,
+			UtilityImages: &v1.UtilityImages{
+				CloneRefs:  "registry.local/clonerefs:v1",
+				InitUpload: "registry.local/initupload:v1",
+				Entrypoint: "registry.local/entrypoint:v1",
+				Sidecar:    "registry.local/sidecar:v1",
+			},
+		},
+	}}
+	for _, tc := range testCases {
+		if decoration := tc.opts.decorationConfig(); !equality.Semantic.DeepEqual(tc.expected, decoration) {
+			t.Errorf("%s: unexpected decoration config: %s", tc.id, diff.ObjectReflectDiff(tc.expected, decoration))
+		}
+	}
+}
+
+func TestParseBranchAliases(t *testing.T) {
+	testCases := []struct {
+		id          string
+		values      []string
+		expected    map[string]map[string]string
+		expectedErr bool
+	}{{
+		id:       "no aliases",
+		expected: map[string]map[string]string{},
+	}, {
+		id:     "aliases for multiple repos",
+		values: []string{"org/repo:master=main", "org/repo:release=stable", "org/other:master=trunk"},
+		expected: map[string]map[string]string{
+			"org/repo":  {"master": "main", "release": "stable"},
+			"org/other": {"master": "trunk"},
+		},
+	}, {
+		id:          "missing repo",
+		values:      []string{"org:master=main"},
+		expectedErr: true,
+	}, {
+		id:          "missing new branch",
+		values:      []string{"org/repo:master="},
+		expectedErr: true,
+	}, {
+		id:          "missing mapping",
+		values:      []string{"org/repo"},
+		expectedErr: true,
+	}, {
+		id:          "conflicting aliases",
+		values:      []string{"org/repo:master=main", "org/repo:master=trunk"},
+		expectedErr: true,
+	}}
+	for _, tc := range testCases {
+		aliases, err := parseBranchAliases(tc.values)
+		if tc.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error: %t, got: %v", tc.id, tc.expectedErr, err)
+			continue
+		}
+		if !tc.expectedErr && !reflect.DeepEqual(tc.expected, aliases) {
+			t.Errorf("%s: unexpected aliases: %s", tc.id, diff.ObjectReflectDiff(tc.expected, aliases))
+		}
+	}
+}
+
 func prune(jobConfig *prowconfig.JobConfig) {
 	for repo := range jobConfig.Presubmits {
 		for i := range jobConfig.Presubmits[repo] {
@@ -742,6 +2021,9 @@ tests:
 			prowExpectedPostsubmitYAML: []byte(`postsubmits:
   super/duper:
   - agent: kubernetes
+    annotations:
+      ci-operator.openshift.io/promotion-name: other
+      ci-operator.openshift.io/promotion-namespace: ci
     branches:
     - ^branch$
     decorate: true
@@ -755,7 +2037,6 @@ tests:
       - args:
         - --artifact-dir=$(ARTIFACTS)
         - --give-pr-author-access-to-namespace=true
-        - --promote
         - --sentry-dsn-path=/etc/sentry-dsn/ci-operator
         - --target=[images]
         command:
@@ -1065,6 +2346,9 @@ tests:
             cpu: 10m
       serviceAccountName: ci-operator
   - agent: kubernetes
+    annotations:
+      ci-operator.openshift.io/promotion-name: test
+      ci-operator.openshift.io/promotion-namespace: ci
     branches:
     - ^branch$
     decorate: true
@@ -1079,7 +2363,6 @@ tests:
       - args:
         - --artifact-dir=$(ARTIFACTS)
         - --give-pr-author-access-to-namespace=true
-        - --promote
         - --sentry-dsn-path=/etc/sentry-dsn/ci-operator
         - --target=[images]
         command:
@@ -1294,6 +2577,9 @@ tests:
             cpu: 10m
       serviceAccountName: ci-operator
   - agent: kubernetes
+    annotations:
+      ci-operator.openshift.io/promotion-name: test
+      ci-operator.openshift.io/promotion-namespace: ci
     branches:
     - ^branch$
     decorate: true
@@ -1307,7 +2593,6 @@ tests:
       - args:
         - --artifact-dir=$(ARTIFACTS)
         - --give-pr-author-access-to-namespace=true
-        - --promote
         - --sentry-dsn-path=/etc/sentry-dsn/ci-operator
         - --target=[images]
         command:
@@ -1373,9 +2658,13 @@ tests:
 				t.Fatalf("Unexpected error writing old postsubmits: %v", err)
 			}
 
-			if err := config.OperateOnCIOperatorConfig(fullConfigPath, generateJobsToDir(baseProwConfigDir)); err != nil {
+			jobs := newJobsToDir(baseProwConfigDir, nil, nil, &generatorOptions{readProwgenConfigs: true})
+			if err := config.OperateOnCIOperatorConfig(fullConfigPath, jobs.generate); err != nil {
 				t.Fatalf("Unexpected error generating jobs from config: %v", err)
 			}
+			if err := jobs.write(); err != nil {
+				t.Fatalf("Unexpected error writing jobs: %v", err)
+			}
 
 			presubmitData, err := ioutil.ReadFile(presubmitPath)
 			if err != nil {
@@ -1397,3 +2686,232 @@ tests:
 		})
 	}
 }
+
+func TestGenerateJobsToDirParallel(t *testing.T) {
+	ciopConfig := []byte(`build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`)
+	configDir, err := ioutil.TempDir("", "prowgen-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	jobDir, err := ioutil.TempDir("", "prowgen-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(jobDir)
+
+	var expected []string
+	for i := 0; i < 10; i++ {
+		branch := fmt.Sprintf("release-4.%d", i)
+		path := filepath.Join(configDir, "super", "duper", fmt.Sprintf("super-duper-%s.yaml", branch))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, ciopConfig, 0644); err != nil {
+			t.Fatal(err)
+		}
+		expected = append(expected, fmt.Sprintf("pull-ci-super-duper-%s-unit", branch))
+	}
+
+	contexts := requiredContexts{}
+	opts := &generatorOptions{fileGrouping: jc.GroupByBranch}
+	jobs := newJobsToDir(jobDir, contexts, nil, opts)
+	if err := config.OperateOnCIOperatorConfigDirParallel(configDir, 4, jobs.generate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := jobs.write(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	jobConfig, err := jc.ReadFromDir(jobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, job := range jobConfig.Presubmits["super/duper"] {
+		names = append(names, job.Name)
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("unexpected presubmits: %s", diff.ObjectReflectDiff(expected, names))
+	}
+	if branches := len(contexts["super"]["duper"]); branches != 10 {
+		t.Errorf("expected required contexts for 10 branches, got %d", branches)
+	}
+}
+
+func TestGenerateJobsToDirSharedFile(t *testing.T) {
+	ciopConfig := []byte(`build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`)
+	configDir, err := ioutil.TempDir("", "prowgen-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(configDir)
+	jobDir, err := ioutil.TempDir("", "prowgen-jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(jobDir)
+
+	for _, branch := range []string{"master", "release-4.1"} {
+		path := filepath.Join(configDir, "super", "duper", fmt.Sprintf("super-duper-%s.yaml", branch))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, ciopConfig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a job generated for a branch that no longer has a config is pruned
+	stale := []byte(`presubmits:
+  super/duper:
+  - agent: kubernetes
+    branches:
+    - release-3.11
+    context: ci/prow/unit
+    labels:
+      ci-operator.openshift.io/prowgen-controlled: "true"
+    name: pull-ci-super-duper-release-3.11-unit
+    rerun_command: /test unit
+    trigger: ((?m)^/test( all| unit),?(\s+|$))
+`)
+	staleDir := filepath.Join(jobDir, "super", "duper")
+	if err := os.MkdirAll(staleDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(staleDir, "super-duper-presubmits.yaml"), stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs := newJobsToDir(jobDir, nil, nil, &generatorOptions{fileGrouping: jc.GroupByRepo})
+	if err := config.OperateOnCIOperatorConfigDir(configDir, jobs.generate); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := jobs.write(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	files, err := ioutil.ReadDir(staleDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var filenames []string
+	for _, file := range files {
+		filenames = append(filenames, file.Name())
+	}
+	expectedFiles := []string{"super-duper-presubmits.yaml"}
+	if !reflect.DeepEqual(expectedFiles, filenames) {
+		t.Errorf("unexpected job files: %s", diff.ObjectReflectDiff(expectedFiles, filenames))
+	}
+
+	jobConfig, err := jc.ReadFromDir(jobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, job := range jobConfig.Presubmits["super/duper"] {
+		names = append(names, job.Name)
+	}
+	sort.Strings(names)
+	expected := []string{"pull-ci-super-duper-master-unit", "pull-ci-super-duper-release-4.1-unit"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("unexpected presubmits: %s", diff.ObjectReflectDiff(expected, names))
+	}
+}
+
+func TestGenerateJobsToDirValidateWithProw(t *testing.T) {
+	prowConfig := []byte(`plank:
+  default_decoration_config:
+    utility_images:
+      clonerefs: clonerefs:latest
+      initupload: initupload:latest
+      entrypoint: entrypoint:latest
+      sidecar: sidecar:latest
+    gcs_configuration:
+      bucket: bucket
+      path_strategy: single
+      default_org: org
+      default_repo: repo
+    gcs_credentials_secret: gce-sa-credentials-gcs-publisher
+`)
+	tmp, err := ioutil.TempDir("", "prowgen-validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	prowConfigPath := filepath.Join(tmp, "config.yaml")
+	if err := ioutil.WriteFile(prowConfigPath, prowConfig, 0644); err != nil {
+		t.Fatal(err)
+	}
+	configSpec := &ciop.ReleaseBuildConfiguration{
+		Images:                 []ciop.ProjectDirectoryImageBuildStepConfiguration{{To: "image"}},
+		PromotionConfiguration: &ciop.PromotionConfiguration{Namespace: "ci", Name: "stream"},
+		Tests: []ciop.TestStepConfiguration{
+			{As: "unit", ContainerTestConfiguration: &ciop.ContainerTestConfiguration{From: "src"}},
+		},
+	}
+	info := &config.Info{Org: "super", Repo: "duper", Branch: "master"}
+
+	validDir := filepath.Join(tmp, "valid")
+	jobs := newJobsToDir(validDir, nil, nil, &generatorOptions{prowConfig: prowConfigPath})
+	if err := jobs.generate(configSpec, info); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.write(); err != nil {
+		t.Errorf("unexpected error for valid jobs: %v", err)
+	}
+
+	invalidDir := filepath.Join(tmp, "invalid")
+	jobs = newJobsToDir(invalidDir, nil, nil, &generatorOptions{prowConfig: prowConfigPath})
+	if err := jobs.generate(configSpec, info); err != nil {
+		t.Fatal(err)
+	}
+	jobs.jobs["super/duper"].Presubmits["super/duper"][0].MaxConcurrency = -1
+	if err := jobs.write(); err == nil {
+		t.Error("expected an error for jobs Prow would reject")
+	}
+	if _, err := os.Stat(invalidDir); !os.IsNotExist(err) {
+		t.Errorf("expected no jobs to be written, got: %v", err)
+	}
+}
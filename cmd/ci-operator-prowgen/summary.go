@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
+)
+
+// generationSummary counts the jobs generated during a run. It is safe for
+// concurrent use.
+type generationSummary struct {
+	lock  sync.Mutex
+	repos sets.String
+
+	Configs     int `json:"configs"`
+	Repos       int `json:"repos"`
+	Presubmits  int `json:"presubmits"`
+	Postsubmits int `json:"postsubmits"`
+	Periodics   int `json:"periodics"`
+}
+
+func newGenerationSummary() *generationSummary {
+	return &generationSummary{repos: sets.NewString()}
+}
+
+// add records the jobs generated for a single ci-operator configuration file
+func (s *generationSummary) add(jobConfig *prowconfig.JobConfig) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Configs++
+	for repo, presubmits := range jobConfig.Presubmits {
+		s.repos.Insert(repo)
+		s.Presubmits += len(presubmits)
+	}
+	for repo, postsubmits := range jobConfig.Postsubmits {
+		s.repos.Insert(repo)
+		s.Postsubmits += len(postsubmits)
+	}
+	s.Periodics += len(jobConfig.Periodics)
+	s.Repos = s.repos.Len()
+}
+
+// log reports the counts at the end of a run
+func (s *generationSummary) log() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	logrus.WithFields(logrus.Fields{
+		"configs":     s.Configs,
+		"repos":       s.Repos,
+		"presubmits":  s.Presubmits,
+		"postsubmits": s.Postsubmits,
+		"periodics":   s.Periodics,
+	}).Info("Generated jobs")
+}
+
+// writeToFile writes the counts as JSON
+func (s *generationSummary) writeToFile(path string, mode os.FileMode) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	raw, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal generation summary (%v)", err)
+	}
+	return jc.WriteFile(path, raw, mode)
+}
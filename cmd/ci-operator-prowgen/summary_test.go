@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestGenerationSummary(t *testing.T) {
+	summary := newGenerationSummary()
+	summary.add(&prowconfig.JobConfig{
+		Presubmits:  map[string][]prowconfig.Presubmit{"org/repo": {{}, {}}},
+		Postsubmits: map[string][]prowconfig.Postsubmit{"org/repo": {{}}},
+	})
+	summary.add(&prowconfig.JobConfig{
+		Presubmits:  map[string][]prowconfig.Presubmit{"org/repo": {{}}},
+		Postsubmits: map[string][]prowconfig.Postsubmit{},
+	})
+	summary.add(&prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{"org/other": {{}}},
+		Periodics:  []prowconfig.Periodic{{}},
+	})
+
+	dir, err := ioutil.TempDir("", "summary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "summary.json")
+	if err := summary.writeToFile(path, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{
+  "configs": 3,
+  "repos": 2,
+  "presubmits": 4,
+  "postsubmits": 1,
+  "periodics": 1
+}`
+	if string(data) != expected {
+		t.Errorf("unexpected summary: %s", diff.StringDiff(expected, string(data)))
+	}
+}
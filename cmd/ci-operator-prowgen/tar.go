@@ -0,0 +1,47 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// writeDirToTar writes all regular files under `dir` to a tar stream, using
+// paths relative to `dir` as the names of the entries
+func writeDirToTar(dir string, out io.Writer) error {
+	writer := tar.NewWriter(out)
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("failed to create tar header for %s (%v)", path, err)
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := writer.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header for %s (%v)", path, err)
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err := io.Copy(writer, file); err != nil {
+			return fmt.Errorf("failed to write %s to tar stream (%v)", path, err)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return writer.Close()
+}
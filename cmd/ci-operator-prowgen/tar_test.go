@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func TestWriteDirToTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "prowgen-tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"super/duper/super-duper-master-presubmits.yaml":     "presubmits: {}\n",
+		"super/duper/super-duper-master-postsubmits.yaml":    "postsubmits: {}\n",
+		"super/trooper/super-trooper-master-presubmits.yaml": "presubmits: {}\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stream bytes.Buffer
+	if err := writeDirToTar(dir, &stream); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	written := map[string]string{}
+	reader := tar.NewReader(&stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written[header.Name] = string(content)
+	}
+	if !reflect.DeepEqual(files, written) {
+		t.Errorf("unexpected tar content: %s", diff.ObjectReflectDiff(files, written))
+	}
+}
@@ -78,7 +78,7 @@ func main() {
 		// we know the path is relative, but there is no API to declare that
 		relPath, _ := filepath.Rel(o.releaseRepoDir, info.Filename)
 		pathsToCheck = append(pathsToCheck, pathWithConfig{path: relPath, configMap: info.ConfigMapName()})
-		configInfos[info.Basename()] = info
+		configInfos[info.ConfigMapKey()] = info
 		return nil
 	}); err != nil {
 		logrus.WithError(err).Fatal("Could not load CI Operator configurations.")
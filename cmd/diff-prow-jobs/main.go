@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/diffs"
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
+)
+
+type options struct {
+	beforeDir string
+	afterDir  string
+
+	help bool
+}
+
+func bindOptions(flag *flag.FlagSet) *options {
+	opt := &options{}
+
+	flag.StringVar(&opt.beforeDir, "before-dir", "", "Path to a root of directory structure with Prow job config files before the change")
+	flag.StringVar(&opt.afterDir, "after-dir", "", "Path to a root of directory structure with Prow job config files after the change")
+	flag.BoolVar(&opt.help, "h", false, "Show help for diff-prow-jobs")
+
+	return opt
+}
+
+func main() {
+	flagSet := flag.NewFlagSet("", flag.ExitOnError)
+	opt := bindOptions(flagSet)
+	flagSet.Parse(os.Args[1:])
+
+	if opt.help {
+		flagSet.Usage()
+		os.Exit(0)
+	}
+
+	if len(opt.beforeDir) == 0 || len(opt.afterDir) == 0 {
+		fmt.Fprintln(os.Stderr, "diff tool needs both --before-dir and --after-dir")
+		os.Exit(1)
+	}
+
+	before, err := jc.ReadFromDir(opt.beforeDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read Prow jobs from %s (%v)\n", opt.beforeDir, err)
+		os.Exit(1)
+	}
+	after, err := jc.ReadFromDir(opt.afterDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read Prow jobs from %s (%v)\n", opt.afterDir, err)
+		os.Exit(1)
+	}
+
+	diffs.PrintJobConfigDiffs(os.Stdout, diffs.GetJobConfigDiffs(before, after))
+}
@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"k8s.io/apimachinery/pkg/util/sets"
 	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	"k8s.io/test-infra/prow/flagutil"
 	prowgithub "k8s.io/test-infra/prow/github"
 	prowplugins "k8s.io/test-infra/prow/plugins"
 	pjdwapi "k8s.io/test-infra/prow/pod-utils/downwardapi"
@@ -46,11 +48,26 @@ type options struct {
 	noFail       bool
 	local        bool
 	allowVolumes bool
+	plan         bool
+	paused       bool
+	listPaused   bool
 	debugLogPath string
 	metricsPath  string
 
 	releaseRepoPath string
 	rehearsalLimit  int
+	sampleThreshold int
+	refsPath        string
+	templateSeed    string
+	ciopImage       string
+	keyFormat       string
+
+	excludedBranches  flagutil.Strings
+	ciopConfigPaths   flagutil.Strings
+	excludedReposPath string
+	clusterType       string
+
+	strictClusterTypes bool
 }
 
 func gatherOptions() options {
@@ -62,12 +79,30 @@ func gatherOptions() options {
 	fs.BoolVar(&o.local, "local", false, "Whether this is a local execution or part of a CI job")
 	fs.BoolVar(&o.allowVolumes, "allow-volumes", false, "Allows jobs with extra volumes to be rehearsed")
 
+	fs.BoolVar(&o.plan, "plan", false, "Print a Markdown table of jobs that would be rehearsed and why to stdout, without submitting any jobs")
+	fs.BoolVar(&o.paused, "paused", false, fmt.Sprintf("Create rehearsal ProwJobs labeled with %s=true and do not wait for them. The Prow controller must not run ProwJobs with the label; removing it triggers the rehearsal. Temporary ConfigMaps are kept for the rehearsals when any were created", rehearse.RehearsalPausedLabel))
+	fs.BoolVar(&o.listPaused, "list-paused", false, "Print the rehearsals for the PR which were created with --paused and were not triggered yet, without submitting any jobs")
+
 	fs.StringVar(&o.debugLogPath, "debug-log", "", "Alternate file for debug output, defaults to stderr")
 	fs.StringVar(&o.releaseRepoPath, "candidate-path", "", "Path to a openshift/release working copy with a revision to be tested")
+	fs.StringVar(&o.refsPath, "refs", "", "Path to a YAML file with refs to use instead of the ones of the job (allows rehearsing against a tag, a specific revision or a batch of pulls)")
 	fs.StringVar(&o.metricsPath, "metrics-output", "", "Path to a file where JSON metrics will be dumped after rehearsal")
 
+	fs.StringVar(&o.templateSeed, "template-job-seed", "", "Seed for picking jobs that rehearse changed templates and jobs that represent identically changed jobs, defaults to the PR number. Passing the same seed again picks the same jobs")
+	fs.IntVar(&o.sampleThreshold, "sample-identical-changes", 0, "If set, when more than this many jobs changed identically, only one of them is rehearsed for each repository and cluster type and the others are reported as not rehearsed")
+
+	fs.StringVar(&o.ciopImage, "ci-operator-image", "", "Image to run ci-operator from in all rehearsal jobs instead of the configured one (e.g. an image built for a ci-operator PR)")
+
+	fs.StringVar(&o.keyFormat, "config-map-key-format", config.DefaultConfigMapKeyFormat, "Go template for the keys under which ci-operator config files are stored in ConfigMaps, executed with the org, repo, branch and variant; must match the one the jobs were generated with")
+
 	fs.IntVar(&o.rehearsalLimit, "rehearsal-limit", 15, "Upper limit of jobs attempted to rehearse (if more jobs would be rehearsed, none will)")
 
+	fs.Var(&o.ciopConfigPaths, "extra-ciop-config-path", "Path to a directory with ci-operator config files in addition to ci-operator/config, relative to the release repo, provide one or more times")
+	fs.Var(&o.excludedBranches, "exclude-branch", "Regular expression matching branches whose jobs will never be rehearsed, provide one or more times")
+	fs.StringVar(&o.excludedReposPath, "excluded-repos", "", "Path to a file listing org/repo names, one per line, whose jobs will never be rehearsed")
+	fs.StringVar(&o.clusterType, "cluster-type", "", "If set, only jobs with this CLUSTER_TYPE (e.g. aws) will be rehearsed")
+	fs.BoolVar(&o.strictClusterTypes, "strict-cluster-types", false, "Fail instead of warning when jobs using changed templates have a CLUSTER_TYPE for which no job is picked to rehearse the changes")
+
 	fs.Parse(os.Args[1:])
 	return o
 }
@@ -76,9 +111,64 @@ func validateOptions(o options) error {
 	if len(o.releaseRepoPath) == 0 {
 		return fmt.Errorf("--candidate-path was not provided")
 	}
+	if _, err := compileBranchPatterns(o.excludedBranches.Strings()); err != nil {
+		return fmt.Errorf("invalid --exclude-branch: %v", err)
+	}
+	if o.listPaused && (o.dryRun || o.paused || o.plan) {
+		return fmt.Errorf("--list-paused needs --dry-run=false and cannot be combined with --paused and --plan")
+	}
+	if _, err := config.NewConfigMapKeyFormat(o.keyFormat); err != nil {
+		return fmt.Errorf("invalid --config-map-key-format: %v", err)
+	}
 	return nil
 }
 
+// listPaused prints the rehearsals for the PR which were created paused and
+// were not triggered yet
+func listPaused(clusterConfig *rest.Config, namespace string, prNumber int, logger *logrus.Entry) int {
+	pjclient, err := rehearse.NewProwJobClient(clusterConfig, namespace, false)
+	if err != nil {
+		logger.WithError(err).Error("could not create a ProwJob client")
+		return 1
+	}
+	pjs, err := rehearse.ListPausedRehearsals(pjclient, prNumber)
+	if err != nil {
+		logger.WithError(err).Error("could not list paused rehearsals")
+		return 1
+	}
+	if len(pjs) == 0 {
+		fmt.Println("No paused rehearsals were found.")
+		return 0
+	}
+	fmt.Printf("The following %d rehearsals are paused, remove the %s label from a ProwJob to trigger it:\n\n", len(pjs), rehearse.RehearsalPausedLabel)
+	fmt.Println("| Rehearsal | ProwJob |")
+	fmt.Println("| --- | --- |")
+	for _, pj := range pjs {
+		fmt.Printf("| `%s` | `%s` |\n", pj.Spec.Job, pj.Name)
+	}
+	return 0
+}
+
+// printSkipped adds the jobs which were not rehearsed to the Markdown report
+// printed to stdout, so that PR authors learn why their jobs were skipped
+func printSkipped(skipped rehearse.SkippedJobs) {
+	if len(skipped) > 0 {
+		fmt.Print("\n" + skipped.Markdown())
+	}
+}
+
+func compileBranchPatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
 const (
 	misconfigurationOutput = `[ERROR] pj-rehearse: misconfiguration
 
@@ -126,6 +216,22 @@ func rehearseMain() int {
 		return gracefulExit(o.noFail, misconfigurationOutput)
 	}
 
+	// validated above
+	keyFormat, _ := config.NewConfigMapKeyFormat(o.keyFormat)
+
+	var excludedRepos sets.String
+	if o.excludedReposPath != "" {
+		if excludedRepos, err = rehearse.LoadExcludedRepos(o.excludedReposPath); err != nil {
+			logrus.WithError(err).Error("could not load repositories excluded from rehearsals")
+			return gracefulExit(o.noFail, misconfigurationOutput)
+		}
+	}
+
+	if o.plan {
+		// planning must not have any side effects on the cluster
+		o.dryRun = true
+	}
+
 	metrics := rehearse.NewMetrics(o.metricsPath)
 	defer metrics.Dump()
 
@@ -141,6 +247,15 @@ func rehearseMain() int {
 			return gracefulExit(o.noFail, misconfigurationOutput)
 		}
 	}
+	if o.refsPath != "" {
+		if jobSpec.Refs, err = rehearse.LoadRefs(o.refsPath); err != nil {
+			logrus.WithError(err).Error("could not load refs")
+			return gracefulExit(o.noFail, misconfigurationOutput)
+		}
+	} else if err := rehearse.ValidateRefs(jobSpec.Refs); err != nil {
+		logrus.WithError(err).Error("job has invalid refs")
+		return gracefulExit(o.noFail, misconfigurationOutput)
+	}
 	metrics.JobSpec = jobSpec
 
 	prFields := logrus.Fields{prowgithub.OrgLogField: jobSpec.Refs.Org, prowgithub.RepoLogField: jobSpec.Refs.Repo}
@@ -153,9 +268,12 @@ func rehearseMain() int {
 		return 0
 	}
 
-	prNumber := jobSpec.Refs.Pulls[0].Number
-	if o.local {
+	var prNumber int
+	if o.local || len(jobSpec.Refs.Pulls) == 0 {
+		// there is no PR to identify rehearsals by, so make up a unique number
 		prNumber = int(time.Now().Unix())
+	} else {
+		prNumber = jobSpec.Refs.Pulls[0].Number
 	}
 
 	logger = logrus.WithField(prowgithub.PrLogField, prNumber)
@@ -170,13 +288,13 @@ func rehearseMain() int {
 		}
 	}
 
-	prConfig := config.GetAllConfigs(o.releaseRepoPath, logger)
+	prConfig := config.GetAllConfigs(o.releaseRepoPath, logger, keyFormat, o.ciopConfigPaths.Strings()...)
 	pluginConfig, err := loadPluginConfig(o.releaseRepoPath)
 	if err != nil {
 		logger.WithError(err).Error("could not load plugin configuration from tested revision of release repo")
 		return gracefulExit(o.noFail, misconfigurationOutput)
 	}
-	masterConfig, err := config.GetAllConfigsFromSHA(o.releaseRepoPath, jobSpec.Refs.BaseSHA, logger)
+	masterConfig, err := config.GetAllConfigsFromSHA(o.releaseRepoPath, jobSpec.Refs.BaseSHA, logger, keyFormat, o.ciopConfigPaths.Strings()...)
 	if err != nil {
 		logger.WithError(err).Error("could not load configuration from base revision of release repo")
 		return gracefulExit(o.noFail, misconfigurationOutput)
@@ -201,6 +319,16 @@ func rehearseMain() int {
 		metrics.RecordChangedCiopConfigs(changedCiopConfigs)
 	}
 
+	// Jobs reading ci-operator config files that exist in neither revision
+	// are broken; they are never rehearsed, so at least report them
+	if dangling := diffs.GetPresubmitsWithDanglingCiopConfigs(prConfig.Prow, logger, masterConfig.CiOperator, prConfig.CiOperator); len(dangling) > 0 {
+		var count int
+		for _, jobs := range dangling {
+			count += len(jobs)
+		}
+		logger.WithField("jobs", count).Warn("Found jobs using ci-operator config files that do not exist")
+	}
+
 	changedTemplates, err := config.GetChangedTemplates(o.releaseRepoPath, jobSpec.Refs.BaseSHA)
 	if err != nil {
 		logger.WithError(err).Error("could not get template differences")
@@ -224,6 +352,9 @@ func rehearseMain() int {
 	if o.local {
 		namespace = "ci-stg"
 	}
+	if o.listPaused {
+		return listPaused(clusterConfig, namespace, prNumber, logger)
+	}
 
 	cmClient, err := rehearse.NewCMClient(clusterConfig, namespace, o.dryRun)
 	if err != nil {
@@ -232,7 +363,13 @@ func rehearseMain() int {
 	}
 
 	cmManager := config.NewTemplateCMManager(namespace, cmClient, pluginConfig, prNumber, o.releaseRepoPath, logger)
+	// paused rehearsals need the ConfigMaps when they are triggered later, but
+	// they are removed when no rehearsals were created
+	var createdPaused bool
 	defer func() {
+		if createdPaused {
+			return
+		}
 		if err := cmManager.CleanupCMTemplates(); err != nil {
 			logger.WithError(err).Error("failed to clean up temporary template CM")
 		}
@@ -265,15 +402,36 @@ func rehearseMain() int {
 	}
 	loggers := rehearse.Loggers{Job: logger, Debug: debugLogger.WithField(prowgithub.PrLogField, prNumber)}
 
-	toRehearse := diffs.GetChangedPresubmits(masterConfig.Prow, prConfig.Prow, logger)
+	toRehearse, details := diffs.GetChangedPresubmits(masterConfig.Prow, prConfig.Prow, logger)
 	metrics.RecordChangedPresubmits(toRehearse)
 	metrics.RecordOpportunity(toRehearse, "direct-change")
 
-	presubmitsWithChangedCiopConfigs := diffs.GetPresubmitsForCiopConfigs(prConfig.Prow, changedCiopConfigs, logger, affectedJobs)
+	presubmitsWithChangedCiopConfigs, ciopConfigDetails := diffs.GetPresubmitsForCiopConfigs(prConfig.Prow, changedCiopConfigs, logger, affectedJobs)
 	metrics.RecordOpportunity(presubmitsWithChangedCiopConfigs, "ci-operator-config-change")
 	toRehearse.AddAll(presubmitsWithChangedCiopConfigs)
+	details.AddAll(ciopConfigDetails)
+
+	// patterns were already validated in validateOptions
+	excludedBranches, _ := compileBranchPatterns(o.excludedBranches.Strings())
+	filter := rehearse.JobFilter{ExcludedBranches: excludedBranches, ExcludedRepos: excludedRepos, ClusterType: o.clusterType}
 
-	presubmitsWithChangedTemplates := rehearse.AddRandomJobsForChangedTemplates(changedTemplates, toRehearse, prConfig.Prow.JobConfig.Presubmits, loggers, prNumber)
+	var sampledOut rehearse.SkippedJobs
+	if o.sampleThreshold > 0 {
+		toRehearse, sampledOut = rehearse.SampleIdenticalChanges(toRehearse, details, o.sampleThreshold, prConfig.CiOperator, o.allowVolumes, filter, loggers, prNumber, o.templateSeed)
+		if len(sampledOut) > 0 {
+			logger.WithField("jobs", len(sampledOut)).Info("Rehearsing representative jobs for identically changed jobs")
+		}
+	}
+
+	if uncovered := rehearse.UncoveredTemplateClusterTypes(changedTemplates, toRehearse, prConfig.Prow.JobConfig.Presubmits); len(uncovered) > 0 {
+		uncoveredLogger := logger.WithField("cluster-types", uncovered)
+		if o.strictClusterTypes {
+			uncoveredLogger.Error("Template changes cannot be rehearsed for unknown cluster types")
+			return gracefulExit(o.noFail, misconfigurationOutput)
+		}
+		uncoveredLogger.Warn("Template changes are NOT rehearsed for unknown cluster types")
+	}
+	presubmitsWithChangedTemplates := rehearse.AddRandomJobsForChangedTemplates(changedTemplates, toRehearse, prConfig.Prow.JobConfig.Presubmits, loggers, prNumber, o.templateSeed)
 	metrics.RecordOpportunity(presubmitsWithChangedTemplates, "templates-change")
 	toRehearse.AddAll(presubmitsWithChangedTemplates)
 
@@ -281,10 +439,33 @@ func rehearseMain() int {
 	metrics.RecordOpportunity(toRehearseClusterProfiles, "cluster-profile-change")
 	toRehearse.AddAll(toRehearseClusterProfiles)
 
-	rehearsals := rehearse.ConfigureRehearsalJobs(toRehearse, prConfig.CiOperator, prNumber, loggers, o.allowVolumes, changedTemplates, changedClusterProfiles)
+	// sampled out jobs picked for templates or cluster profiles are rehearsed after all
+	var notSampled rehearse.SkippedJobs
+	for _, job := range sampledOut {
+		if !toRehearse.Has(job.Repo, job.Job) {
+			notSampled = append(notSampled, job)
+		}
+	}
+
+	rehearsals, skipped := rehearse.ConfigureRehearsalJobs(toRehearse, prConfig.CiOperator, prNumber, loggers, o.allowVolumes, filter, changedTemplates, changedClusterProfiles)
+	skipped = skipped.Add(notSampled)
+	if o.ciopImage != "" {
+		rehearse.OverrideCiOperatorImage(rehearsals, o.ciopImage, logger)
+	}
 	metrics.RecordActual(rehearsals)
+	if o.plan {
+		fmt.Print(rehearse.NewPlan(rehearsals, prNumber, metrics.Opportunities, details).Markdown())
+		if len(rehearsals) > o.rehearsalLimit {
+			fmt.Printf("\nThis is more than the limit of %d jobs, so no jobs would actually be rehearsed.\n", o.rehearsalLimit)
+		}
+		printSkipped(skipped)
+		return 0
+	}
 	if len(rehearsals) == 0 {
 		logger.Info("no jobs to rehearse have been found")
+		if !o.dryRun {
+			printSkipped(skipped)
+		}
 		return 0
 	} else if len(rehearsals) > o.rehearsalLimit {
 		jobCountFields := logrus.Fields{
@@ -296,8 +477,20 @@ func rehearseMain() int {
 	}
 
 	executor := rehearse.NewExecutor(rehearsals, prNumber, o.releaseRepoPath, jobSpec.Refs, o.dryRun, loggers, pjclient)
+	executor.Paused = o.paused
 	success, err := executor.ExecuteJobs()
 	metrics.Execution = executor.Metrics
+	createdPaused = o.paused && len(executor.Metrics.SubmittedRehearsals) > 0
+	if !o.dryRun {
+		// dry runs print the submitted jobs instead
+		if o.paused {
+			fmt.Print(rehearse.NewPlan(rehearsals, prNumber, metrics.Opportunities, details).Markdown())
+			fmt.Printf("\nThe rehearsals were created paused; they run when the %s label is removed from their ProwJobs.\n", rehearse.RehearsalPausedLabel)
+		} else {
+			fmt.Print(rehearse.NewPlan(rehearsals, prNumber, metrics.Opportunities, details).ResultsMarkdown(executor.Metrics))
+		}
+		printSkipped(skipped)
+	}
 	if err != nil {
 		logger.WithError(err).Error("Failed to rehearse jobs")
 		return gracefulExit(o.noFail, rehearseFailureOutput)
@@ -306,6 +499,10 @@ func rehearseMain() int {
 		logger.Error("Some jobs failed their rehearsal runs")
 		return gracefulExit(o.noFail, jobsFailureOutput)
 	}
+	if o.paused {
+		logger.WithField("jobs", len(rehearsals)).Info("Rehearsals were created paused and did not run yet")
+		return 0
+	}
 	logger.Info("All jobs were rehearsed successfully")
 	return 0
 }
@@ -22,6 +22,16 @@ func (p Presubmits) AddAll(jobs Presubmits) {
 	}
 }
 
+// Has tells whether there is a presubmit with the name for the repo.
+func (p Presubmits) Has(repo, name string) bool {
+	for _, job := range p[repo] {
+		if job.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 // Add a presubmit for a given repo.
 // The method assumes two jobs with a matching name are identical, so if
 // a presubmit with a given name already exists, it is kept as is.
@@ -116,3 +116,13 @@ func TestPresubmitsAdd(t *testing.T) {
 	}
 
 }
+
+func TestPresubmitsHas(t *testing.T) {
+	presubmits := Presubmits{"org/repo": {{JobBase: prowconfig.JobBase{Name: "job"}}}}
+	if !presubmits.Has("org/repo", "job") {
+		t.Error("expected the job to be found")
+	}
+	if presubmits.Has("org/repo", "other") || presubmits.Has("org/other", "job") {
+		t.Error("expected no other jobs to be found")
+	}
+}
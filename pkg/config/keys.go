@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/promotion"
+)
+
+const (
+	configMapNamePrefix = "ci-operator-"
+	configMapNameSuffix = "-configs"
+)
+
+// DefaultConfigMapKeyFormat is the format of keys under which ci-operator
+// configuration files are stored in ConfigMaps, which matches the names of
+// the files
+const DefaultConfigMapKeyFormat = "{{.Org}}-{{.Repo}}-{{.Branch}}{{if .Variant}}__{{.Variant}}{{end}}.yaml"
+
+// invalidConfigMapKeyCharacters match the characters that cannot be used in
+// ConfigMap keys
+var invalidConfigMapKeyCharacters = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// ConfigMapKeyFormat determines the keys under which ci-operator configuration
+// files are stored in ConfigMaps. The generated jobs refer to the keys and
+// rehearsals look up the configuration files by them, so both need to use
+// the same format. A nil format is the default one.
+type ConfigMapKeyFormat struct {
+	template *template.Template
+}
+
+// NewConfigMapKeyFormat creates a format from a Go template executed with the
+// Info of a configuration file
+func NewConfigMapKeyFormat(format string) (*ConfigMapKeyFormat, error) {
+	tmpl, err := template.New("key").Option("missingkey=error").Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ConfigMap key format (%v)", err)
+	}
+	keyFormat := &ConfigMapKeyFormat{template: tmpl}
+	// unknown fields are only detected when the template is executed
+	if _, err := keyFormat.execute(&Info{Org: "org", Repo: "repo", Branch: "master", Variant: "variant"}); err != nil {
+		return nil, fmt.Errorf("invalid ConfigMap key format (%v)", err)
+	}
+	return keyFormat, nil
+}
+
+var defaultConfigMapKeyFormat = func() *ConfigMapKeyFormat {
+	format, err := NewConfigMapKeyFormat(DefaultConfigMapKeyFormat)
+	if err != nil {
+		panic(err)
+	}
+	return format
+}()
+
+func (f *ConfigMapKeyFormat) execute(info *Info) (string, error) {
+	var key bytes.Buffer
+	if err := f.template.Execute(&key, info); err != nil {
+		return "", err
+	}
+	return SanitizeConfigMapKey(key.String()), nil
+}
+
+// Key returns the key under which the configuration file is stored
+func (f *ConfigMapKeyFormat) Key(info *Info) string {
+	if f == nil {
+		f = defaultConfigMapKeyFormat
+	}
+	key, err := f.execute(info)
+	if err != nil {
+		// formats are validated when they are created
+		panic(fmt.Sprintf("failed to format ConfigMap key for %s: %v", info.Basename(), err))
+	}
+	return key
+}
+
+// SanitizeConfigMapKey replaces the characters which cannot be used in
+// ConfigMap keys with underscores
+func SanitizeConfigMapKey(key string) string {
+	return invalidConfigMapKeyCharacters.ReplaceAllString(key, "_")
+}
+
+// ConfigMapKey returns the key under which the configuration file is stored
+// with the default format
+func (i *Info) ConfigMapKey() string {
+	return defaultConfigMapKeyFormat.Key(i)
+}
+
+// ConfigMapName returns the configmap in which we expect this file to be uploaded
+func (i *Info) ConfigMapName() string {
+	return fmt.Sprintf("%s%s%s", configMapNamePrefix, promotion.FlavorForBranch(i.Branch), configMapNameSuffix)
+}
+
+// IsCiopConfigCM returns true if a given name is a valid ci-operator config ConfigMap
+func IsCiopConfigCM(name string) bool {
+	return strings.HasPrefix(name, configMapNamePrefix) && strings.HasSuffix(name, configMapNameSuffix) &&
+		len(name) > len(configMapNamePrefix)+len(configMapNameSuffix)
+}
@@ -0,0 +1,91 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestConfigMapKeyFormat(t *testing.T) {
+	testCases := []struct {
+		name        string
+		format      string
+		info        *Info
+		expected    string
+		expectedErr bool
+	}{
+		{
+			name:     "default format matches the file name",
+			format:   DefaultConfigMapKeyFormat,
+			info:     &Info{Org: "org", Repo: "repo", Branch: "release-4.1"},
+			expected: "org-repo-release-4.1.yaml",
+		},
+		{
+			name:     "default format with a variant",
+			format:   DefaultConfigMapKeyFormat,
+			info:     &Info{Org: "org", Repo: "repo", Branch: "master", Variant: "rhel"},
+			expected: "org-repo-master__rhel.yaml",
+		},
+		{
+			name:     "characters not allowed in keys are replaced",
+			format:   DefaultConfigMapKeyFormat,
+			info:     &Info{Org: "org", Repo: "repo", Branch: "feature/c++"},
+			expected: "org-repo-feature_c__.yaml",
+		},
+		{
+			name:     "custom format",
+			format:   "{{.Org}}.{{.Repo}}.{{.Branch}}",
+			info:     &Info{Org: "org", Repo: "repo", Branch: "master"},
+			expected: "org.repo.master",
+		},
+		{
+			name:        "format that does not parse",
+			format:      "{{.Org",
+			expectedErr: true,
+		},
+		{
+			name:        "format with an unknown field",
+			format:      "{{.Organization}}-{{.Repo}}",
+			expectedErr: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			format, err := NewConfigMapKeyFormat(testCase.format)
+			if err != nil != testCase.expectedErr {
+				t.Fatalf("expected error: %t, got %v", testCase.expectedErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if key := format.Key(testCase.info); key != testCase.expected {
+				t.Errorf("expected key %q, got %q", testCase.expected, key)
+			}
+		})
+	}
+}
+
+func TestConfigMapKeyDefaults(t *testing.T) {
+	info := &Info{Org: "org", Repo: "repo", Branch: "feature/x", Variant: "rhel"}
+	var format *ConfigMapKeyFormat
+	if key, expected := format.Key(info), info.ConfigMapKey(); key != expected {
+		t.Errorf("nil format: expected key %q, got %q", expected, key)
+	}
+	if expected := "org-repo-feature_x__rhel.yaml"; info.ConfigMapKey() != expected {
+		t.Errorf("expected key %q, got %q", expected, info.ConfigMapKey())
+	}
+}
+
+func TestIsCiopConfigCM(t *testing.T) {
+	testCases := map[string]bool{
+		"ci-operator-master-configs": true,
+		"ci-operator-4.1-configs":    true,
+		"ci-operator-configs":        false,
+		"ci-operator--configs":       false,
+		"ci-operator-master":         false,
+		"prow-job-cluster-launch":    false,
+	}
+	for name, expected := range testCases {
+		if IsCiopConfigCM(name) != expected {
+			t.Errorf("%s: expected %t", name, expected)
+		}
+	}
+}
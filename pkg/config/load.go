@@ -1,17 +1,22 @@
 package config
 
 import (
+	"archive/tar"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ghodss/yaml"
-	"github.com/openshift/ci-operator-prowgen/pkg/promotion"
 	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	kutilerrors "k8s.io/apimachinery/pkg/util/errors"
 
 	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
 )
@@ -21,7 +26,10 @@ func readCiOperatorConfig(configFilePath string) (*cioperatorapi.ReleaseBuildCon
 	if err != nil {
 		return nil, fmt.Errorf("failed to read ci-operator config (%v)", err)
 	}
+	return parseCiOperatorConfig(data)
+}
 
+func parseCiOperatorConfig(data []byte) (*cioperatorapi.ReleaseBuildConfiguration, error) {
 	var configSpec *cioperatorapi.ReleaseBuildConfiguration
 	if err := yaml.Unmarshal(data, &configSpec); err != nil {
 		return nil, fmt.Errorf("failed to load ci-operator config (%v)", err)
@@ -31,9 +39,57 @@ func readCiOperatorConfig(configFilePath string) (*cioperatorapi.ReleaseBuildCon
 		return nil, fmt.Errorf("invalid ci-operator config: %v", err)
 	}
 
+	if err := validateTestNames(configSpec.Tests); err != nil {
+		return nil, fmt.Errorf("invalid ci-operator config: %v", err)
+	}
+
+	if err := validateImageNames(configSpec.Images); err != nil {
+		return nil, fmt.Errorf("invalid ci-operator config: %v", err)
+	}
+
 	return configSpec, nil
 }
 
+// validateTestNames ensures that no two tests share a name; jobs are generated
+// per test name, so duplicates would silently result in a single job
+func validateTestNames(tests []cioperatorapi.TestStepConfiguration) error {
+	var names []string
+	for _, test := range tests {
+		names = append(names, test.As)
+	}
+	if duplicates := findDuplicates(names); len(duplicates) > 0 {
+		return fmt.Errorf("tests defined more than once: %s", strings.Join(duplicates, ", "))
+	}
+	return nil
+}
+
+// validateImageNames ensures that no two images are built into the same
+// `to` tag, which would make them overwrite each other in promotion
+func validateImageNames(images []cioperatorapi.ProjectDirectoryImageBuildStepConfiguration) error {
+	var names []string
+	for _, image := range images {
+		names = append(names, string(image.To))
+	}
+	if duplicates := findDuplicates(names); len(duplicates) > 0 {
+		return fmt.Errorf("images built more than once: %s", strings.Join(duplicates, ", "))
+	}
+	return nil
+}
+
+// findDuplicates returns names occurring more than once, each once in the
+// order in which they were first repeated
+func findDuplicates(names []string) []string {
+	seen := map[string]int{}
+	var duplicates []string
+	for _, name := range names {
+		seen[name]++
+		if seen[name] == 2 {
+			duplicates = append(duplicates, name)
+		}
+	}
+	return duplicates
+}
+
 // DataWithInfo describes the metadata for a CI Operator configuration file
 type Info struct {
 	Org    string
@@ -54,14 +110,57 @@ func (i *Info) Basename() string {
 	return fmt.Sprintf("%s.yaml", basename)
 }
 
-// ConfigMapName returns the configmap in which we expect this file to be uploaded
-func (i *Info) ConfigMapName() string {
-	return fmt.Sprintf("ci-operator-%s-configs", promotion.FlavorForBranch(i.Branch))
+// branchGlobCharacters are the characters which make the branch of a
+// configuration file a glob or a regular expression, like `release-4.*`,
+// applying the file to all branches matching it
+const branchGlobCharacters = "*?["
+
+// IsBranchGlob tells whether the branch of the configuration file is a glob
+// or a regular expression rather than a single branch
+func (i *Info) IsBranchGlob() bool {
+	return strings.ContainsAny(i.Branch, branchGlobCharacters)
 }
 
-// IsCiopConfigCM returns true if a given name is a valid ci-operator config ConfigMap
-func IsCiopConfigCM(name string) bool {
-	return regexp.MustCompile(`^ci-operator-.+-configs$`).MatchString(name)
+// BranchRegex returns the regular expression matching the branches the
+// configuration file applies to. Branches which are not globs or are already
+// valid regular expressions, like `release-4.*`, are returned as they are.
+// Other globs, like `*-stable`, are translated to an anchored regular
+// expression: `*` matches any characters, `?` a single one and bracket
+// expressions are kept; all other characters are matched literally.
+func (i *Info) BranchRegex() string {
+	if !i.IsBranchGlob() {
+		return i.Branch
+	}
+	if _, err := regexp.Compile(i.Branch); err == nil {
+		return i.Branch
+	}
+	var regex strings.Builder
+	regex.WriteString("^")
+	glob := i.Branch
+	for len(glob) > 0 {
+		switch glob[0] {
+		case '*':
+			regex.WriteString(".*")
+		case '?':
+			regex.WriteString(".")
+		case '[':
+			if end := strings.IndexByte(glob, ']'); end > 1 {
+				class := glob[1:end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				regex.WriteString("[" + class + "]")
+				glob = glob[end+1:]
+				continue
+			}
+			regex.WriteString(regexp.QuoteMeta("["))
+		default:
+			regex.WriteString(regexp.QuoteMeta(glob[:1]))
+		}
+		glob = glob[1:]
+	}
+	regex.WriteString("$")
+	return regex.String()
 }
 
 // We use the directory/file naming convention to encode useful information
@@ -99,6 +198,47 @@ func InfoFromPath(configFilePath string) (*Info, error) {
 	}, nil
 }
 
+// versionedBranch matches the dashed release branch names at the end of a
+// ConfigMap key, which cannot be told apart from the repo name otherwise
+var versionedBranch = regexp.MustCompile(`-((release|enterprise|openshift)-[0-9][^-]*)$`)
+
+// InfoFromConfigMapKey reconstructs the component information from a key of
+// a ci-operator configuration ConfigMap, which has the ORG-REPO-BRANCH.yaml
+// form. Dashes are ambiguous in such keys, so the org is expected not to
+// contain any and the branch is expected to either not contain any or to be
+// a release branch like `release-4.1`.
+func InfoFromConfigMapKey(key string) (*Info, error) {
+	s := strings.TrimSuffix(key, filepath.Ext(key))
+
+	var variant string
+	if i := strings.LastIndex(s, "__"); i != -1 {
+		variant = s[i+2:]
+		s = s[:i]
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("could not extract org from '%s' (expected key like 'ORG-REPO-BRANCH.yaml')", key)
+	}
+	org, rest := parts[0], parts[1]
+
+	branchStart := strings.LastIndex(rest, "-")
+	if match := versionedBranch.FindStringSubmatchIndex(rest); match != nil {
+		branchStart = match[0]
+	}
+	if branchStart < 1 || branchStart == len(rest)-1 {
+		return nil, fmt.Errorf("could not extract repo and branch from '%s' (expected key like 'ORG-REPO-BRANCH.yaml')", key)
+	}
+
+	return &Info{
+		Org:      org,
+		Repo:     rest[:branchStart],
+		Branch:   rest[branchStart+1:],
+		Variant:  variant,
+		Filename: key,
+	}, nil
+}
+
 func isConfigFile(path string, info os.FileInfo) bool {
 	extension := filepath.Ext(path)
 	return !info.IsDir() && (extension == ".yaml" || extension == ".yml")
@@ -142,6 +282,136 @@ func OperateOnCIOperatorConfigDir(configDir string, callback func(*cioperatorapi
 	})
 }
 
+// OperateOnCIOperatorConfigDirParallel runs the callback on all CI Operator
+// configuration files found while walking the directory provided, using up
+// to `workers` goroutines. The callback therefore needs to be safe for
+// concurrent use. Unlike OperateOnCIOperatorConfigDir, failures for single
+// files do not stop the processing of the others; all of them are returned
+// together once every file was processed.
+func OperateOnCIOperatorConfigDirParallel(configDir string, workers int, callback func(*cioperatorapi.ReleaseBuildConfiguration, *Info) error) error {
+	var paths []string
+	if err := filepath.Walk(configDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			logrus.WithField("source-file", path).WithError(err).Error("Failed to walk CI Operator configuration dir")
+			return err
+		}
+		if isConfigFile(path, info) {
+			paths = append(paths, path)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	errs := make([]error, len(paths))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indices {
+				if err := OperateOnCIOperatorConfig(paths[index], callback); err != nil {
+					errs[index] = fmt.Errorf("%s: %v", paths[index], err)
+				}
+			}
+		}()
+	}
+	for index := range paths {
+		indices <- index
+	}
+	close(indices)
+	wg.Wait()
+
+	return kutilerrors.NewAggregate(errs)
+}
+
+// OperateOnCIOperatorConfigTar runs the callback on all CI Operator
+// configuration files found in the tar stream provided. Paths of the
+// entries in the stream are expected to follow the same ORG/REPO/FILE
+// structure as the files on disk.
+func OperateOnCIOperatorConfigTar(stream io.Reader, callback func(*cioperatorapi.ReleaseBuildConfiguration, *Info) error) error {
+	reader := tar.NewReader(stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logrus.WithError(err).Error("Failed to read CI Operator configuration tar stream")
+			return err
+		}
+		if !header.FileInfo().Mode().IsRegular() || !isConfigFile(header.Name, header.FileInfo()) {
+			continue
+		}
+		logger := logrus.WithField("source-file", header.Name)
+		data, err := ioutil.ReadAll(reader)
+		if err != nil {
+			logger.WithError(err).Error("Failed to read CI Operator configuration from tar stream")
+			return err
+		}
+		configSpec, err := parseCiOperatorConfig(data)
+		if err != nil {
+			logger.WithError(err).Error("Failed to load CI Operator configuration")
+			return err
+		}
+		info, err := InfoFromPath(header.Name)
+		if err != nil {
+			logger.WithError(err).Error("Failed to load CI Operator configuration")
+			return err
+		}
+		if err := callback(configSpec, info); err != nil {
+			logger.WithError(err).Error("Failed to execute callback")
+			return err
+		}
+	}
+}
+
+// OperateOnCIOperatorConfigMap runs the callback on all CI Operator
+// configurations stored in the data of the ConfigMap manifest at the path
+// provided. The component information is reconstructed from the data keys.
+func OperateOnCIOperatorConfigMap(path string, callback func(*cioperatorapi.ReleaseBuildConfiguration, *Info) error) error {
+	logger := logrus.WithField("source-file", path)
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		logger.WithError(err).Error("Failed to read CI Operator configuration ConfigMap")
+		return err
+	}
+	var configMap corev1.ConfigMap
+	if err := yaml.Unmarshal(raw, &configMap); err != nil {
+		logger.WithError(err).Error("Failed to load CI Operator configuration ConfigMap")
+		return err
+	}
+
+	var keys []string
+	for key := range configMap.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		logger := logger.WithField("key", key)
+		configSpec, err := parseCiOperatorConfig([]byte(configMap.Data[key]))
+		if err != nil {
+			logger.WithError(err).Error("Failed to load CI Operator configuration")
+			return err
+		}
+		info, err := InfoFromConfigMapKey(key)
+		if err != nil {
+			logger.WithError(err).Error("Failed to load CI Operator configuration")
+			return err
+		}
+		if err := callback(configSpec, info); err != nil {
+			logger.WithError(err).Error("Failed to execute callback")
+			return err
+		}
+	}
+	return nil
+}
+
 func LoggerForInfo(info Info) *logrus.Entry {
 	return logrus.WithFields(logrus.Fields{
 		"org":         info.Org,
@@ -175,18 +445,55 @@ func (i *DataWithInfo) CommitTo(dir string) error {
 	return nil
 }
 
+// CompoundCiopConfig holds ci-operator configuration files keyed by the
+// ConfigMap key they are stored under
 type CompoundCiopConfig map[string]*cioperatorapi.ReleaseBuildConfiguration
 
-func (compound CompoundCiopConfig) add(handledConfig *cioperatorapi.ReleaseBuildConfiguration, handledElements *Info) error {
-	compound[handledElements.Basename()] = handledConfig
-	return nil
+func (compound CompoundCiopConfig) adder(keyFormat *ConfigMapKeyFormat) func(*cioperatorapi.ReleaseBuildConfiguration, *Info) error {
+	return func(handledConfig *cioperatorapi.ReleaseBuildConfiguration, handledElements *Info) error {
+		compound[keyFormat.Key(handledElements)] = handledConfig
+		return nil
+	}
 }
 
 func CompoundLoad(path string) (CompoundCiopConfig, error) {
+	return CompoundLoadWithKeyFormat(path, nil)
+}
+
+// CompoundLoadWithKeyFormat loads the ci-operator configuration files under
+// the path, keyed by the ConfigMap keys in the given format
+func CompoundLoadWithKeyFormat(path string, keyFormat *ConfigMapKeyFormat) (CompoundCiopConfig, error) {
 	config := CompoundCiopConfig{}
-	if err := OperateOnCIOperatorConfigDir(path, config.add); err != nil {
+	if err := OperateOnCIOperatorConfigDir(path, config.adder(keyFormat)); err != nil {
 		return nil, err
 	}
 
 	return config, nil
 }
+
+// CompoundLoadPaths loads the ci-operator configuration files from all the
+// paths provided into one compound config. When a file with the same name
+// exists under multiple paths, the one under the earlier path is used.
+func CompoundLoadPaths(paths ...string) (CompoundCiopConfig, error) {
+	return CompoundLoadPathsWithKeyFormat(nil, paths...)
+}
+
+// CompoundLoadPathsWithKeyFormat does the same as CompoundLoadPaths, keying
+// the files by the ConfigMap keys in the given format
+func CompoundLoadPathsWithKeyFormat(keyFormat *ConfigMapKeyFormat, paths ...string) (CompoundCiopConfig, error) {
+	config := CompoundCiopConfig{}
+	for _, path := range paths {
+		loaded, err := CompoundLoadWithKeyFormat(path, keyFormat)
+		if err != nil {
+			return nil, err
+		}
+		for filename, configSpec := range loaded {
+			if _, exists := config[filename]; exists {
+				logrus.WithFields(logrus.Fields{"source-file": filename, "source-dir": path}).Warn("ci-operator config file already loaded from another path, ignoring")
+				continue
+			}
+			config[filename] = configSpec
+		}
+	}
+	return config, nil
+}
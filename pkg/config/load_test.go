@@ -1,10 +1,21 @@
 package config
 
 import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/util/diff"
+
+	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
 )
 
 func TestExtractRepoElementsFromPath(t *testing.T) {
@@ -62,6 +73,18 @@ func TestExtractRepoElementsFromPath(t *testing.T) {
 			},
 			expectedError: false,
 		},
+		{
+			name: "path with glob branch parses fine",
+			path: "./org/repo/org-repo-release-4.*.yaml",
+			expected: &Info{
+				Org:      "org",
+				Repo:     "repo",
+				Branch:   "release-4.*",
+				Variant:  "",
+				Filename: "./org/repo/org-repo-release-4.*.yaml",
+			},
+			expectedError: false,
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.path, func(t *testing.T) {
@@ -115,6 +138,34 @@ func TestInfo_Basename(t *testing.T) {
 	}
 }
 
+func TestInfo_BranchGlob(t *testing.T) {
+	testCases := []struct {
+		branch        string
+		expectedGlob  bool
+		expectedRegex string
+	}{
+		{branch: "release-4.1", expectedRegex: "release-4.1"},
+		{branch: "release-4.*", expectedGlob: true, expectedRegex: "release-4.*"},
+		{branch: "Branch-.*", expectedGlob: true, expectedRegex: "Branch-.*"},
+		{branch: `^release-4\.[0-9]+$`, expectedGlob: true, expectedRegex: `^release-4\.[0-9]+$`},
+		{branch: "*-stable", expectedGlob: true, expectedRegex: `^.*-stable$`},
+		{branch: "?-stable", expectedGlob: true, expectedRegex: `^.-stable$`},
+		{branch: "*-[!3].[0-9]", expectedGlob: true, expectedRegex: `^.*-[^3]\.[0-9]$`},
+		{branch: "*-[", expectedGlob: true, expectedRegex: `^.*-\[$`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.branch, func(t *testing.T) {
+			info := &Info{Org: "org", Repo: "repo", Branch: tc.branch}
+			if glob := info.IsBranchGlob(); glob != tc.expectedGlob {
+				t.Errorf("expected glob to be %t, got %t", tc.expectedGlob, glob)
+			}
+			if regex := info.BranchRegex(); regex != tc.expectedRegex {
+				t.Errorf("expected regex %q, got %q", tc.expectedRegex, regex)
+			}
+		})
+	}
+}
+
 func TestInfo_ConfigMapName(t *testing.T) {
 	testCases := []struct {
 		name     string
@@ -201,3 +252,414 @@ func TestInfo_ConfigMapName(t *testing.T) {
 		})
 	}
 }
+
+func TestOperateOnCIOperatorConfigTar(t *testing.T) {
+	config := []byte(`build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`)
+	var stream bytes.Buffer
+	writer := tar.NewWriter(&stream)
+	entries := []struct {
+		header tar.Header
+		data   []byte
+	}{
+		{header: tar.Header{Name: "super/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "super/duper/", Typeflag: tar.TypeDir, Mode: 0755}},
+		{header: tar.Header{Name: "super/duper/OWNERS", Typeflag: tar.TypeReg, Mode: 0644}, data: []byte("approvers: []\n")},
+		{header: tar.Header{Name: "super/duper/super-duper-master.yaml", Typeflag: tar.TypeReg, Mode: 0644}, data: config},
+		{header: tar.Header{Name: "super/duper/super-duper-release-3.11__variant.yaml", Typeflag: tar.TypeReg, Mode: 0644}, data: config},
+	}
+	for _, entry := range entries {
+		entry.header.Size = int64(len(entry.data))
+		if err := writer.WriteHeader(&entry.header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write(entry.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []Info
+	if err := OperateOnCIOperatorConfigTar(&stream, func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *Info) error {
+		if len(configSpec.Tests) != 1 || configSpec.Tests[0].As != "unit" {
+			t.Errorf("%s: unexpected configuration parsed: %#v", info.Filename, configSpec.Tests)
+		}
+		infos = append(infos, *info)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Info{{
+		Org:      "super",
+		Repo:     "duper",
+		Branch:   "master",
+		Filename: "super/duper/super-duper-master.yaml",
+	}, {
+		Org:      "super",
+		Repo:     "duper",
+		Branch:   "release-3.11",
+		Variant:  "variant",
+		Filename: "super/duper/super-duper-release-3.11__variant.yaml",
+	}}
+	if !reflect.DeepEqual(expected, infos) {
+		t.Errorf("unexpected infos: %s", diff.ObjectReflectDiff(expected, infos))
+	}
+}
+
+func TestInfoFromConfigMapKey(t *testing.T) {
+	testCases := []struct {
+		name          string
+		key           string
+		expected      *Info
+		expectedError bool
+	}{
+		{
+			name:     "simple key parses fine",
+			key:      "org-repo-branch.yaml",
+			expected: &Info{Org: "org", Repo: "repo", Branch: "branch", Filename: "org-repo-branch.yaml"},
+		},
+		{
+			name:     "key without extension parses fine",
+			key:      "org-repo-branch",
+			expected: &Info{Org: "org", Repo: "repo", Branch: "branch", Filename: "org-repo-branch"},
+		},
+		{
+			name:     "dashed repo parses fine",
+			key:      "org-cluster-api-provider-master.yaml",
+			expected: &Info{Org: "org", Repo: "cluster-api-provider", Branch: "master", Filename: "org-cluster-api-provider-master.yaml"},
+		},
+		{
+			name:     "release branch parses fine",
+			key:      "org-some-repo-release-4.1.yaml",
+			expected: &Info{Org: "org", Repo: "some-repo", Branch: "release-4.1", Filename: "org-some-repo-release-4.1.yaml"},
+		},
+		{
+			name:     "key with variant parses fine",
+			key:      "org-repo-openshift-3.11__variant.yaml",
+			expected: &Info{Org: "org", Repo: "repo", Branch: "openshift-3.11", Variant: "variant", Filename: "org-repo-openshift-3.11__variant.yaml"},
+		},
+		{
+			name:          "key without branch fails to parse",
+			key:           "org-repo.yaml",
+			expectedError: true,
+		},
+		{
+			name:          "key without dashes fails to parse",
+			key:           "org.yaml",
+			expectedError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			info, err := InfoFromConfigMapKey(testCase.key)
+			if err == nil && testCase.expectedError {
+				t.Errorf("expected an error, but got none")
+			}
+			if err != nil && !testCase.expectedError {
+				t.Errorf("expected no error, but got one: %v", err)
+			}
+			if !reflect.DeepEqual(testCase.expected, info) {
+				t.Errorf("didn't get correct elements: %v", diff.ObjectReflectDiff(testCase.expected, info))
+			}
+		})
+	}
+}
+
+func TestOperateOnCIOperatorConfigMap(t *testing.T) {
+	config := `build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`
+	indented := "    " + strings.Replace(strings.TrimSuffix(config, "\n"), "\n", "\n    ", -1)
+	configMap := `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ci-operator-misc-configs
+data:
+  super-duper-release-3.11__variant.yaml: |
+` + indented + `
+  super-duper-master.yaml: |
+` + indented + `
+`
+	dir, err := ioutil.TempDir("", "configmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "configmap.yaml")
+	if err := ioutil.WriteFile(path, []byte(configMap), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []Info
+	if err := OperateOnCIOperatorConfigMap(path, func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *Info) error {
+		if len(configSpec.Tests) != 1 || configSpec.Tests[0].As != "unit" {
+			t.Errorf("%s: unexpected configuration parsed: %#v", info.Filename, configSpec.Tests)
+		}
+		infos = append(infos, *info)
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Info{{
+		Org:      "super",
+		Repo:     "duper",
+		Branch:   "master",
+		Filename: "super-duper-master.yaml",
+	}, {
+		Org:      "super",
+		Repo:     "duper",
+		Branch:   "release-3.11",
+		Variant:  "variant",
+		Filename: "super-duper-release-3.11__variant.yaml",
+	}}
+	if !reflect.DeepEqual(expected, infos) {
+		t.Errorf("unexpected infos: %s", diff.ObjectReflectDiff(expected, infos))
+	}
+}
+
+func TestOperateOnCIOperatorConfigDirParallel(t *testing.T) {
+	config := []byte(`build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: unit
+  commands: make test-unit
+  container:
+    from: src
+`)
+	dir, err := ioutil.TempDir("", "configdir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []string{
+		"super/duper/super-duper-master.yaml",
+		"super/duper/super-duper-release-3.11.yaml",
+		"super/trooper/super-trooper-master.yaml",
+		"other/repo/other-repo-master.yaml",
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, config, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var lock sync.Mutex
+	var processed []string
+	err = OperateOnCIOperatorConfigDirParallel(dir, 3, func(configSpec *cioperatorapi.ReleaseBuildConfiguration, info *Info) error {
+		lock.Lock()
+		defer lock.Unlock()
+		processed = append(processed, info.Basename())
+		if info.Repo == "trooper" || info.Org == "other" {
+			return fmt.Errorf("failed for %s", info.Basename())
+		}
+		return nil
+	})
+
+	sort.Strings(processed)
+	expected := []string{"other-repo-master.yaml", "super-duper-master.yaml", "super-duper-release-3.11.yaml", "super-trooper-master.yaml"}
+	if !reflect.DeepEqual(expected, processed) {
+		t.Errorf("unexpected processed files: %s", diff.ObjectReflectDiff(expected, processed))
+	}
+	if err == nil {
+		t.Fatal("expected an error, got none")
+	}
+	for _, failed := range []string{"failed for other-repo-master.yaml", "failed for super-trooper-master.yaml"} {
+		if !strings.Contains(err.Error(), failed) {
+			t.Errorf("expected error to contain %q, got: %v", failed, err)
+		}
+	}
+}
+
+func TestValidateTestNames(t *testing.T) {
+	testCases := []struct {
+		name        string
+		tests       []cioperatorapi.TestStepConfiguration
+		expectedErr string
+	}{{
+		name:  "unique names",
+		tests: []cioperatorapi.TestStepConfiguration{{As: "unit"}, {As: "e2e"}},
+	}, {
+		name:        "duplicate name",
+		tests:       []cioperatorapi.TestStepConfiguration{{As: "unit"}, {As: "e2e"}, {As: "unit"}},
+		expectedErr: "tests defined more than once: unit",
+	}, {
+		name:        "multiple duplicate names",
+		tests:       []cioperatorapi.TestStepConfiguration{{As: "unit"}, {As: "e2e"}, {As: "e2e"}, {As: "unit"}},
+		expectedErr: "tests defined more than once: e2e, unit",
+	}, {
+		name:        "name defined three times",
+		tests:       []cioperatorapi.TestStepConfiguration{{As: "unit"}, {As: "unit"}, {As: "unit"}},
+		expectedErr: "tests defined more than once: unit",
+	}}
+	for _, tc := range testCases {
+		err := validateTestNames(tc.tests)
+		if tc.expectedErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if tc.expectedErr != "" && (err == nil || err.Error() != tc.expectedErr) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestValidateImageNames(t *testing.T) {
+	testCases := []struct {
+		name        string
+		images      []cioperatorapi.ProjectDirectoryImageBuildStepConfiguration
+		expectedErr string
+	}{{
+		name:   "unique names",
+		images: []cioperatorapi.ProjectDirectoryImageBuildStepConfiguration{{To: "cli"}, {To: "tests"}},
+	}, {
+		name:        "duplicate name",
+		images:      []cioperatorapi.ProjectDirectoryImageBuildStepConfiguration{{To: "cli"}, {To: "tests"}, {To: "cli"}},
+		expectedErr: "images built more than once: cli",
+	}}
+	for _, tc := range testCases {
+		err := validateImageNames(tc.images)
+		if tc.expectedErr == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+		if tc.expectedErr != "" && (err == nil || err.Error() != tc.expectedErr) {
+			t.Errorf("%s: expected error %q, got %v", tc.name, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestCompoundLoadPaths(t *testing.T) {
+	makeConfig := func(test string) string {
+		return `build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+tag_specification:
+  cluster: https://api.ci.openshift.org
+  name: origin-v4.0
+  namespace: openshift
+  tag: ''
+resources:
+  '*':
+    requests:
+      cpu: 10Mi
+tests:
+- as: ` + test + `
+  commands: make test
+  container:
+    from: src
+`
+	}
+	dir, err := ioutil.TempDir("", "compound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"canonical/org/repo/org-repo-master.yaml":    makeConfig("canonical"),
+		"extra/org/repo/org-repo-master.yaml":        makeConfig("extra"),
+		"extra/org/other/org-other-release-4.1.yaml": makeConfig("other"),
+	}
+	for path, content := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	config, err := CompoundLoadPaths(filepath.Join(dir, "canonical"), filepath.Join(dir, "extra"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests := map[string]string{}
+	for filename, configSpec := range config {
+		tests[filename] = configSpec.Tests[0].As
+	}
+	expected := map[string]string{"org-repo-master.yaml": "canonical", "org-other-release-4.1.yaml": "other"}
+	if !reflect.DeepEqual(expected, tests) {
+		t.Errorf("unexpected configs loaded: %s", diff.ObjectReflectDiff(expected, tests))
+	}
+
+	keyFormat, err := NewConfigMapKeyFormat("{{.Repo}}-{{.Branch}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err = CompoundLoadPathsWithKeyFormat(keyFormat, filepath.Join(dir, "canonical"), filepath.Join(dir, "extra"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tests = map[string]string{}
+	for key, configSpec := range config {
+		tests[key] = configSpec.Tests[0].As
+	}
+	expected = map[string]string{"repo-master": "canonical", "other-release-4.1": "other"}
+	if !reflect.DeepEqual(expected, tests) {
+		t.Errorf("unexpected configs loaded with a key format: %s", diff.ObjectReflectDiff(expected, tests))
+	}
+
+	if _, err := CompoundLoadPaths(filepath.Join(dir, "canonical"), filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error for a missing path, got none")
+	}
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	cron "gopkg.in/robfig/cron.v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+
+	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/promotion"
+)
+
+// ProwgenFile is the name of the file which holds the generator settings
+// for a repository, placed in the ORG/REPO directory next to the
+// ci-operator configuration files of the repository
+const ProwgenFile = ".config.prowgen"
+
+// Prowgen holds the generator settings for a repository, which do not
+// belong to the ci-operator configuration because ci-operator does not
+// use them
+type Prowgen struct {
+	// SkipImagesPresubmit disables the presubmit that builds images; the
+	// postsubmit promoting them is still generated
+	SkipImagesPresubmit bool `json:"skip_images_presubmit,omitempty"`
+
+	// AlwaysRun sets whether presubmits for tests run on every PR. When
+	// unset, they do and hand-edited values in job files are kept.
+	AlwaysRun *bool `json:"always_run,omitempty"`
+	// ImagesAlwaysRun does the same as AlwaysRun for the images presubmit,
+	// which is not affected by AlwaysRun
+	ImagesAlwaysRun *bool `json:"images_always_run,omitempty"`
+
+	// VariantSuffix places the variant of configuration files for variants
+	// after the test name in names and contexts of generated jobs, as in
+	// `ci/prow/TEST-VARIANT`, instead of before it
+	VariantSuffix bool `json:"variant_suffix,omitempty"`
+
+	// PRAuthorAccess sets whether ci-operator gives the author of a PR access
+	// to the namespace in which presubmits test the PR. When unset, it does.
+	PRAuthorAccess *bool `json:"pr_author_access,omitempty"`
+
+	// JobOverrides are partial jobs keyed by job name, layered onto the
+	// generated jobs with the same name before they are written
+	JobOverrides map[string]map[string]interface{} `json:"job_overrides,omitempty"`
+
+	// Scheduling applies to the pods of all generated jobs
+	Scheduling `json:",inline"`
+
+	// Tests holds settings for the jobs of single tests, keyed by the test
+	// name, which is `images` for the jobs building images
+	Tests map[string]ProwgenTest `json:"tests,omitempty"`
+}
+
+// ProwgenTest holds the generator settings for the jobs of a single test
+type ProwgenTest struct {
+	// Scheduling replaces the repository settings for the test where set
+	Scheduling `json:",inline"`
+
+	// Timeout and GracePeriod replace the decoration timeouts of the
+	// presubmit and the periodic for the test where set
+	Timeout     *pjapi.Duration `json:"timeout,omitempty"`
+	GracePeriod *pjapi.Duration `json:"grace_period,omitempty"`
+
+	// ClusterProfiles replace the cluster profile from the ci-operator
+	// configuration for tests using one, keyed by the branch flavor, as
+	// returned by promotion.FlavorForBranch for the configuration branch
+	ClusterProfiles map[string]cioperatorapi.ClusterProfile `json:"cluster_profiles,omitempty"`
+
+	// Leases makes the jobs for the test pass the lease server options to
+	// ci-operator, which the test needs to acquire leased resources
+	Leases bool `json:"leases,omitempty"`
+
+	// PRAuthorAccess replaces the repository setting for the presubmit for
+	// the test where set
+	PRAuthorAccess *bool `json:"pr_author_access,omitempty"`
+
+	// Optional adds an optional presubmit for the test, which only runs on
+	// request, next to the presubmit running on every PR
+	Optional bool `json:"optional,omitempty"`
+
+	// RunIfChanged makes the presubmit for the test run only on PRs changing
+	// files matching the regular expression, instead of on every PR. It
+	// replaces the always-run policy of the repository.
+	RunIfChanged string `json:"run_if_changed,omitempty"`
+
+	// Resources replace the requests and limits of the ci-operator container
+	// in the jobs for the test where set
+	Resources *Resources `json:"resources,omitempty"`
+
+	// Cron and Interval schedule a periodic running the test, in addition to
+	// its presubmit. At most one of them can be set.
+	Cron     string `json:"cron,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// Scheduling determines the nodes the pods of generated jobs run on
+type Scheduling struct {
+	NodeSelector map[string]string   `json:"node_selector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+}
+
+// Resources holds the requests and limits of the ci-operator container, keyed
+// by `cpu` or `memory`, as quantities like `4Gi` or `2`
+type Resources struct {
+	Requests map[string]string `json:"requests,omitempty"`
+	Limits   map[string]string `json:"limits,omitempty"`
+}
+
+// DefaultResourceRequests returns the requests of the ci-operator container
+// in generated jobs, which the requests set for a test replace per resource
+func DefaultResourceRequests() corev1.ResourceList {
+	return corev1.ResourceList{corev1.ResourceCPU: *resource.NewMilliQuantity(10, resource.DecimalSI)}
+}
+
+// resourceNames are the resources which can be requested and limited
+var resourceNames = sets.NewString(string(corev1.ResourceCPU), string(corev1.ResourceMemory))
+
+// parseResourceList parses the quantities of resources
+func parseResourceList(list map[string]string) (corev1.ResourceList, error) {
+	parsed := corev1.ResourceList{}
+	for _, name := range sets.StringKeySet(list).List() {
+		if !resourceNames.Has(name) {
+			return nil, fmt.Errorf("%s: only %s can be set", name, strings.Join(resourceNames.List(), " and "))
+		}
+		quantity, err := resource.ParseQuantity(list[name])
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid quantity %q (%v)", name, list[name], err)
+		}
+		parsed[corev1.ResourceName(name)] = quantity
+	}
+	return parsed, nil
+}
+
+func (r *Resources) validate() error {
+	requests, err := parseResourceList(r.Requests)
+	if err != nil {
+		return fmt.Errorf("requests.%v", err)
+	}
+	limits, err := parseResourceList(r.Limits)
+	if err != nil {
+		return fmt.Errorf("limits.%v", err)
+	}
+	defaults := DefaultResourceRequests()
+	for name, limit := range limits {
+		if request, ok := requests[name]; ok {
+			if request.Cmp(limit) > 0 {
+				return fmt.Errorf("requests.%s cannot be greater than limits.%s", name, name)
+			}
+		} else if request, ok := defaults[name]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("limits.%s cannot be lower than the default request of %s", name, request.String())
+		}
+	}
+	return nil
+}
+
+// ResourcesFor returns the requests and limits of the ci-operator container
+// in the jobs for a test which replace the defaults. Quantities that do not
+// parse are left out, as LoadProwgenConfig rejects them.
+func (p *Prowgen) ResourcesFor(test string) corev1.ResourceRequirements {
+	var requirements corev1.ResourceRequirements
+	if resources := p.Tests[test].Resources; resources != nil {
+		requirements.Requests, _ = parseResourceList(resources.Requests)
+		requirements.Limits, _ = parseResourceList(resources.Limits)
+	}
+	return requirements
+}
+
+// SchedulingFor returns the scheduling settings for the jobs of a test
+func (p *Prowgen) SchedulingFor(test string) Scheduling {
+	scheduling := p.Scheduling
+	if testSettings, ok := p.Tests[test]; ok {
+		if testSettings.NodeSelector != nil {
+			scheduling.NodeSelector = testSettings.NodeSelector
+		}
+		if testSettings.Tolerations != nil {
+			scheduling.Tolerations = testSettings.Tolerations
+		}
+	}
+	return scheduling
+}
+
+// PRAuthorAccessFor returns whether the author of a PR gets access to the
+// namespace of the presubmit for a test
+func (p *Prowgen) PRAuthorAccessFor(test string) bool {
+	if access := p.Tests[test].PRAuthorAccess; access != nil {
+		return *access
+	}
+	if p.PRAuthorAccess != nil {
+		return *p.PRAuthorAccess
+	}
+	return true
+}
+
+// ClusterProfileFor returns the cluster profile the jobs for the test use with
+// the configuration for the branch, or an empty one when the profile from the
+// ci-operator configuration is kept
+func (t ProwgenTest) ClusterProfileFor(branch string) cioperatorapi.ClusterProfile {
+	return t.ClusterProfiles[promotion.FlavorForBranch(branch)]
+}
+
+// Periodic tells whether a periodic is generated for the test
+func (t ProwgenTest) Periodic() bool {
+	return t.Cron != "" || t.Interval != ""
+}
+
+// NeedLeases returns the names of the tests which need leases, sorted
+func (p *Prowgen) NeedLeases() []string {
+	var tests []string
+	for name, test := range p.Tests {
+		if test.Leases {
+			tests = append(tests, name)
+		}
+	}
+	sort.Strings(tests)
+	return tests
+}
+
+// LoadProwgenConfig reads the generator settings for a repository from the
+// directory holding its ci-operator configuration files. Repositories with
+// no settings file get the defaults.
+func LoadProwgenConfig(dir string) (*Prowgen, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, ProwgenFile))
+	if os.IsNotExist(err) {
+		return &Prowgen{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prowgen config (%v)", err)
+	}
+	var prowgen Prowgen
+	if err := yaml.Unmarshal(data, &prowgen); err != nil {
+		return nil, fmt.Errorf("failed to load prowgen config (%v)", err)
+	}
+	if err := prowgen.validate(); err != nil {
+		return nil, fmt.Errorf("invalid prowgen config (%v)", err)
+	}
+	return &prowgen, nil
+}
+
+// branchFlavor matches the flavors returned by promotion.FlavorForBranch
+var branchFlavor = regexp.MustCompile(`^(master|3\.x|4\.[0-9]+|misc)$`)
+
+func (p *Prowgen) validate() error {
+	for name, test := range p.Tests {
+		if test.Timeout != nil && test.Timeout.Duration <= 0 {
+			return fmt.Errorf("tests.%s.timeout must be positive, got %s", name, test.Timeout.Duration)
+		}
+		if test.GracePeriod != nil && test.GracePeriod.Duration <= 0 {
+			return fmt.Errorf("tests.%s.grace_period must be positive, got %s", name, test.GracePeriod.Duration)
+		}
+		for flavor, profile := range test.ClusterProfiles {
+			if !branchFlavor.MatchString(flavor) {
+				return fmt.Errorf("tests.%s.cluster_profiles: %q is not a branch flavor, expected master, 3.x, 4.N or misc", name, flavor)
+			}
+			if profile == "" {
+				return fmt.Errorf("tests.%s.cluster_profiles.%s cannot be empty", name, flavor)
+			}
+		}
+		if test.RunIfChanged != "" {
+			if _, err := regexp.Compile(test.RunIfChanged); err != nil {
+				return fmt.Errorf("tests.%s.run_if_changed: invalid regular expression %q (%v)", name, test.RunIfChanged, err)
+			}
+		}
+		if test.Resources != nil {
+			if err := test.Resources.validate(); err != nil {
+				return fmt.Errorf("tests.%s.resources.%v", name, err)
+			}
+		}
+		if test.Periodic() {
+			if err := ValidateSchedule(name, test.Cron, test.Interval); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateSchedule checks the schedule of the periodic generated for a test
+// the way Prow does when loading jobs, so that mistakes are reported during
+// generation: exactly one of the cron expression and the interval needs to
+// be set and it needs to parse.
+func ValidateSchedule(test, cronExpr, interval string) error {
+	if cronExpr != "" && interval != "" {
+		return fmt.Errorf("test %s: cron and interval cannot be both set", test)
+	}
+	if cronExpr == "" && interval == "" {
+		return fmt.Errorf("test %s: either cron or interval needs to be set", test)
+	}
+	if cronExpr != "" {
+		if _, err := cron.Parse(cronExpr); err != nil {
+			return fmt.Errorf("test %s: invalid cron %q (%v)", test, cronExpr, err)
+		}
+		return nil
+	}
+	duration, err := time.ParseDuration(interval)
+	if err != nil {
+		return fmt.Errorf("test %s: invalid interval %q (%v)", test, interval, err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("test %s: interval %q must be positive", test, interval)
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/diff"
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+
+	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
+)
+
+func TestLoadProwgenConfig(t *testing.T) {
+	testCases := []struct {
+		name          string
+		content       *string
+		expected      *Prowgen
+		expectedError bool
+	}{
+		{
+			name:     "missing file results in defaults",
+			expected: &Prowgen{},
+		},
+		{
+			name:     "settings are loaded",
+			content:  strPtr("skip_images_presubmit: true\n"),
+			expected: &Prowgen{SkipImagesPresubmit: true},
+		},
+		{
+			name:    "job overrides are loaded",
+			content: strPtr("job_overrides:\n  pull-ci-org-repo-master-e2e:\n    max_concurrency: 1\n"),
+			expected: &Prowgen{JobOverrides: map[string]map[string]interface{}{
+				"pull-ci-org-repo-master-e2e": {"max_concurrency": float64(1)},
+			}},
+		},
+		{
+			name:    "scheduling settings are loaded",
+			content: strPtr("node_selector:\n  pool: heavy\ntests:\n  e2e:\n    tolerations:\n    - key: dedicated\n      operator: Equal\n      value: e2e\n      effect: NoSchedule\n"),
+			expected: &Prowgen{
+				Scheduling: Scheduling{NodeSelector: map[string]string{"pool": "heavy"}},
+				Tests: map[string]ProwgenTest{"e2e": {Scheduling: Scheduling{Tolerations: []corev1.Toleration{{
+					Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "e2e", Effect: corev1.TaintEffectNoSchedule,
+				}}}}},
+			},
+		},
+		{
+			name:    "timeouts are loaded",
+			content: strPtr("tests:\n  e2e:\n    timeout: 4h\n    grace_period: 15m\n"),
+			expected: &Prowgen{Tests: map[string]ProwgenTest{"e2e": {
+				Timeout:     &pjapi.Duration{Duration: 4 * time.Hour},
+				GracePeriod: &pjapi.Duration{Duration: 15 * time.Minute},
+			}}},
+		},
+		{
+			name:          "timeout that is not a duration fails to load",
+			content:       strPtr("tests:\n  e2e:\n    timeout: forever\n"),
+			expectedError: true,
+		},
+		{
+			name:          "negative grace period fails to load",
+			content:       strPtr("tests:\n  e2e:\n    grace_period: -15m\n"),
+			expectedError: true,
+		},
+		{
+			name:    "cluster profiles are loaded",
+			content: strPtr("tests:\n  e2e:\n    cluster_profiles:\n      master: aws\n      \"4.1\": gcp\n"),
+			expected: &Prowgen{Tests: map[string]ProwgenTest{"e2e": {
+				ClusterProfiles: map[string]cioperatorapi.ClusterProfile{"master": "aws", "4.1": "gcp"},
+			}}},
+		},
+		{
+			name:          "cluster profile for a branch instead of a flavor fails to load",
+			content:       strPtr("tests:\n  e2e:\n    cluster_profiles:\n      release-4.1: gcp\n"),
+			expectedError: true,
+		},
+		{
+			name:    "PR author access settings are loaded",
+			content: strPtr("pr_author_access: false\ntests:\n  unit:\n    pr_author_access: true\n"),
+			expected: &Prowgen{
+				PRAuthorAccess: boolPtr(false),
+				Tests:          map[string]ProwgenTest{"unit": {PRAuthorAccess: boolPtr(true)}},
+			},
+		},
+		{
+			name:     "schedules are loaded",
+			content:  strPtr("tests:\n  e2e:\n    cron: 0 4 * * *\n  unit:\n    interval: 24h\n"),
+			expected: &Prowgen{Tests: map[string]ProwgenTest{"e2e": {Cron: "0 4 * * *"}, "unit": {Interval: "24h"}}},
+		},
+		{
+			name:          "test with both cron and interval fails to load",
+			content:       strPtr("tests:\n  e2e:\n    cron: 0 4 * * *\n    interval: 24h\n"),
+			expectedError: true,
+		},
+		{
+			name:     "optional is loaded",
+			content:  strPtr("tests:\n  e2e:\n    optional: true\n"),
+			expected: &Prowgen{Tests: map[string]ProwgenTest{"e2e": {Optional: true}}},
+		},
+		{
+			name:     "run_if_changed is loaded",
+			content:  strPtr("tests:\n  e2e:\n    run_if_changed: ^pkg/\n"),
+			expected: &Prowgen{Tests: map[string]ProwgenTest{"e2e": {RunIfChanged: "^pkg/"}}},
+		},
+		{
+			name:          "run_if_changed that is not a regular expression fails to load",
+			content:       strPtr("tests:\n  e2e:\n    run_if_changed: \"(pkg\"\n"),
+			expectedError: true,
+		},
+		{
+			name:    "resources are loaded",
+			content: strPtr("tests:\n  e2e:\n    resources:\n      requests:\n        memory: 4Gi\n      limits:\n        cpu: \"2\"\n"),
+			expected: &Prowgen{Tests: map[string]ProwgenTest{"e2e": {Resources: &Resources{
+				Requests: map[string]string{"memory": "4Gi"},
+				Limits:   map[string]string{"cpu": "2"},
+			}}}},
+		},
+		{
+			name:          "resources with invalid quantity fail to load",
+			content:       strPtr("tests:\n  e2e:\n    resources:\n      requests:\n        memory: lots\n"),
+			expectedError: true,
+		},
+		{
+			name:          "invalid file fails to load",
+			content:       strPtr("skip_images_presubmit: [\n"),
+			expectedError: true,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "prowgen")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if testCase.content != nil {
+				if err := ioutil.WriteFile(filepath.Join(dir, ProwgenFile), []byte(*testCase.content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			prowgen, err := LoadProwgenConfig(dir)
+			if err == nil && testCase.expectedError {
+				t.Errorf("expected an error, but got none")
+			}
+			if err != nil && !testCase.expectedError {
+				t.Errorf("expected no error, but got one: %v", err)
+			}
+			if !reflect.DeepEqual(testCase.expected, prowgen) {
+				t.Errorf("unexpected prowgen config: %s", diff.ObjectReflectDiff(testCase.expected, prowgen))
+			}
+		})
+	}
+}
+
+func TestSchedulingFor(t *testing.T) {
+	repoTolerations := []corev1.Toleration{{Key: "repo", Operator: corev1.TolerationOpExists}}
+	testTolerations := []corev1.Toleration{{Key: "test", Operator: corev1.TolerationOpExists}}
+	prowgen := &Prowgen{
+		Scheduling: Scheduling{NodeSelector: map[string]string{"pool": "repo"}, Tolerations: repoTolerations},
+		Tests: map[string]ProwgenTest{
+			"e2e":    {Scheduling: Scheduling{NodeSelector: map[string]string{"pool": "e2e"}}},
+			"images": {Scheduling: Scheduling{Tolerations: testTolerations}},
+		},
+	}
+	testCases := []struct {
+		test     string
+		expected Scheduling
+	}{{
+		test:     "unit",
+		expected: Scheduling{NodeSelector: map[string]string{"pool": "repo"}, Tolerations: repoTolerations},
+	}, {
+		test:     "e2e",
+		expected: Scheduling{NodeSelector: map[string]string{"pool": "e2e"}, Tolerations: repoTolerations},
+	}, {
+		test:     "images",
+		expected: Scheduling{NodeSelector: map[string]string{"pool": "repo"}, Tolerations: testTolerations},
+	}}
+	for _, testCase := range testCases {
+		if scheduling := prowgen.SchedulingFor(testCase.test); !reflect.DeepEqual(testCase.expected, scheduling) {
+			t.Errorf("%s: unexpected scheduling: %s", testCase.test, diff.ObjectReflectDiff(testCase.expected, scheduling))
+		}
+	}
+}
+
+func TestValidateResources(t *testing.T) {
+	testCases := []struct {
+		name        string
+		resources   Resources
+		expectedErr string
+	}{
+		{
+			name:      "valid quantities",
+			resources: Resources{Requests: map[string]string{"cpu": "100m", "memory": "4Gi"}, Limits: map[string]string{"cpu": "2", "memory": "8Gi"}},
+		},
+		{
+			name:        "invalid quantity",
+			resources:   Resources{Limits: map[string]string{"memory": "4 GB"}},
+			expectedErr: `tests.e2e.resources.limits.memory: invalid quantity "4 GB"`,
+		},
+		{
+			name:        "unsupported resource",
+			resources:   Resources{Requests: map[string]string{"gpu": "1"}},
+			expectedErr: "tests.e2e.resources.requests.gpu: only cpu and memory can be set",
+		},
+		{
+			name:        "request greater than limit",
+			resources:   Resources{Requests: map[string]string{"memory": "8Gi"}, Limits: map[string]string{"memory": "4Gi"}},
+			expectedErr: "tests.e2e.resources.requests.memory cannot be greater than limits.memory",
+		},
+		{
+			name:        "limit lower than the default request",
+			resources:   Resources{Limits: map[string]string{"cpu": "5m"}},
+			expectedErr: "tests.e2e.resources.limits.cpu cannot be lower than the default request of 10m",
+		},
+		{
+			name:      "limit lower than the default request with a lower request",
+			resources: Resources{Requests: map[string]string{"cpu": "5m"}, Limits: map[string]string{"cpu": "5m"}},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			prowgen := &Prowgen{Tests: map[string]ProwgenTest{"e2e": {Resources: &testCase.resources}}}
+			err := prowgen.validate()
+			if testCase.expectedErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), testCase.expectedErr) {
+				t.Errorf("expected error starting with %q, got %v", testCase.expectedErr, err)
+			}
+		})
+	}
+}
+
+func TestResourcesFor(t *testing.T) {
+	prowgen := &Prowgen{Tests: map[string]ProwgenTest{
+		"e2e": {Resources: &Resources{Requests: map[string]string{"memory": "4Gi"}, Limits: map[string]string{"cpu": "2"}}},
+	}}
+	expected := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+		Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+	}
+	if resources := prowgen.ResourcesFor("e2e"); !equality.Semantic.DeepEqual(expected, resources) {
+		t.Errorf("unexpected resources: %s", diff.ObjectReflectDiff(expected, resources))
+	}
+	if resources := prowgen.ResourcesFor("unit"); len(resources.Requests)+len(resources.Limits) != 0 {
+		t.Errorf("expected no resources for a test without settings, got %v", resources)
+	}
+}
+
+func TestPRAuthorAccessFor(t *testing.T) {
+	testCases := []struct {
+		name     string
+		prowgen  *Prowgen
+		expected map[string]bool
+	}{
+		{
+			name:     "access is given by default",
+			prowgen:  &Prowgen{},
+			expected: map[string]bool{"unit": true, "images": true},
+		},
+		{
+			name:     "repository setting applies to all tests",
+			prowgen:  &Prowgen{PRAuthorAccess: boolPtr(false)},
+			expected: map[string]bool{"unit": false, "images": false},
+		},
+		{
+			name: "test setting replaces the repository setting",
+			prowgen: &Prowgen{
+				PRAuthorAccess: boolPtr(false),
+				Tests:          map[string]ProwgenTest{"unit": {PRAuthorAccess: boolPtr(true)}, "e2e": {Leases: true}},
+			},
+			expected: map[string]bool{"unit": true, "e2e": false, "images": false},
+		},
+		{
+			name:     "test setting without a repository setting",
+			prowgen:  &Prowgen{Tests: map[string]ProwgenTest{"e2e": {PRAuthorAccess: boolPtr(false)}}},
+			expected: map[string]bool{"unit": true, "e2e": false},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			for test, expected := range testCase.expected {
+				if access := testCase.prowgen.PRAuthorAccessFor(test); access != expected {
+					t.Errorf("%s: expected access %t, got %t", test, expected, access)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateSchedule(t *testing.T) {
+	testCases := []struct {
+		name          string
+		cron          string
+		interval      string
+		expectedError string
+	}{
+		{
+			name: "valid cron",
+			cron: "0 4 * * 1",
+		},
+		{
+			name: "cron descriptor",
+			cron: "@daily",
+		},
+		{
+			name:     "valid interval",
+			interval: "24h",
+		},
+		{
+			name:          "neither cron nor interval",
+			expectedError: "test e2e: either cron or interval needs to be set",
+		},
+		{
+			name:          "both cron and interval",
+			cron:          "@daily",
+			interval:      "24h",
+			expectedError: "test e2e: cron and interval cannot be both set",
+		},
+		{
+			name:          "invalid cron",
+			cron:          "0 4 * *",
+			expectedError: `test e2e: invalid cron "0 4 * *" (Expected 5 or 6 fields, found 4: 0 4 * *)`,
+		},
+		{
+			name:          "invalid interval",
+			interval:      "daily",
+			expectedError: `test e2e: invalid interval "daily" (time: invalid duration "daily")`,
+		},
+		{
+			name:          "negative interval",
+			interval:      "-24h",
+			expectedError: `test e2e: interval "-24h" must be positive`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var actualError string
+			if err := ValidateSchedule("e2e", testCase.cron, testCase.interval); err != nil {
+				actualError = err.Error()
+			}
+			if actualError != testCase.expectedError {
+				t.Errorf("expected error %q, got %q", testCase.expectedError, actualError)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -2,11 +2,13 @@ package config
 
 import (
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/util/sets"
 
 	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
 	prowconfig "k8s.io/test-infra/prow/config"
@@ -92,13 +94,24 @@ func NewLocalJobSpec(path string) (*pjdwapi.JobSpec, error) {
 }
 
 // GetAllConfigs loads all configuration from the working copy of the release repo (usually openshift/release).
+// ci-operator configuration is loaded from the canonical path and from `extraCiopConfigPaths`, relative to the
+// release repo, with the files under the canonical path taking precedence. The files are keyed by the ConfigMap
+// keys in `keyFormat`. Extra paths which do not exist, like those added by the PR when loading the base revision,
+// hold no configuration.
 // When an error occurs during some config loading, the error is not propagated, but the returned struct field will
 // have a nil value in the appropriate field. The error is only logged.
-func GetAllConfigs(releaseRepoPath string, logger *logrus.Entry) *ReleaseRepoConfig {
+func GetAllConfigs(releaseRepoPath string, logger *logrus.Entry, keyFormat *ConfigMapKeyFormat, extraCiopConfigPaths ...string) *ReleaseRepoConfig {
 	config := &ReleaseRepoConfig{}
 	var err error
-	ciopConfigPath := filepath.Join(releaseRepoPath, CiopConfigInRepoPath)
-	config.CiOperator, err = CompoundLoad(ciopConfigPath)
+	ciopConfigPaths := []string{filepath.Join(releaseRepoPath, CiopConfigInRepoPath)}
+	for _, path := range extraCiopConfigPaths {
+		if _, err := os.Stat(filepath.Join(releaseRepoPath, path)); os.IsNotExist(err) {
+			logger.WithField("path", path).Info("extra ci-operator config path does not exist, loading no configuration from it")
+			continue
+		}
+		ciopConfigPaths = append(ciopConfigPaths, filepath.Join(releaseRepoPath, path))
+	}
+	config.CiOperator, err = CompoundLoadPathsWithKeyFormat(keyFormat, ciopConfigPaths...)
 	if err != nil {
 		logger.WithError(err).Warn("failed to load ci-operator configuration from release repo")
 	}
@@ -118,7 +131,7 @@ func GetAllConfigs(releaseRepoPath string, logger *logrus.Entry) *ReleaseRepoCon
 // revision that was checked out in the working copy when this method was called. Errors occurred during these git
 // manipulations are propagated in the error return value. Errors occurred during the actual config loading are not
 // propagated, but the returned struct field will have a nil value in the appropriate field. The error is only logged.
-func GetAllConfigsFromSHA(releaseRepoPath, sha string, logger *logrus.Entry) (*ReleaseRepoConfig, error) {
+func GetAllConfigsFromSHA(releaseRepoPath, sha string, logger *logrus.Entry, keyFormat *ConfigMapKeyFormat, extraCiopConfigPaths ...string) (*ReleaseRepoConfig, error) {
 	currentSHA, err := revParse(releaseRepoPath, "HEAD")
 	if err != nil {
 		return nil, fmt.Errorf("failed to get SHA of current HEAD: %v", err)
@@ -134,7 +147,7 @@ func GetAllConfigsFromSHA(releaseRepoPath, sha string, logger *logrus.Entry) (*R
 		return nil, fmt.Errorf("could not checkout worktree: %v", err)
 	}
 
-	config := GetAllConfigs(releaseRepoPath, logger)
+	config := GetAllConfigs(releaseRepoPath, logger, keyFormat, extraCiopConfigPaths...)
 
 	if err := gitCheckout(releaseRepoPath, restoreRev); err != nil {
 		return config, fmt.Errorf("failed to check out tested revision back: %v", err)
@@ -161,6 +174,29 @@ func GetChangedClusterProfiles(path, baseRev string) ([]ConfigMapSource, error)
 	return getRevChanges(path, ClusterProfilesPath, baseRev, false)
 }
 
+// GetChangedCiopConfigRepos returns the ORG/REPO directories under `dir`, a
+// directory with ci-operator configuration files in a git repository, holding
+// files that were added, modified or removed since revision `baseRev`. Jobs
+// are generated from the working tree, so uncommitted and untracked files
+// count as changed as well.
+func GetChangedCiopConfigRepos(dir, baseRev string) ([]string, error) {
+	diff, err := git(dir, "diff", "--name-only", "--no-renames", "--relative", baseRev, "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(dir, "ls-files", "--others", "--exclude-standard", "--", ".")
+	if err != nil {
+		return nil, err
+	}
+	repos := sets.NewString()
+	for _, l := range strings.Split(strings.TrimSpace(diff+untracked), "\n") {
+		if parts := strings.Split(l, "/"); len(parts) > 2 {
+			repos.Insert(filepath.Join(parts[0], parts[1]))
+		}
+	}
+	return repos.List(), nil
+}
+
 // getRevChanges returns the name and a hash of the contents of files under
 // `path` that were added/modified since revision `base` in the repository at
 // `root`.  Paths are relative to `root`.
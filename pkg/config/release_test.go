@@ -7,9 +7,11 @@ import (
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"testing"
 
+	"github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/diff"
 )
 
@@ -116,3 +118,129 @@ git mv renameme/file renamed/file
 	}}
 	compareChanges(t, ClusterProfilesPath, files, cmd, GetChangedClusterProfiles, expected)
 }
+
+func TestGetChangedCiopConfigRepos(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, CiopConfigInRepoPath)
+	for _, f := range []string{
+		"org/unchanged/org-unchanged-master.yaml", "org/changed/org-changed-master.yaml",
+		"org/removed/org-removed-master.yaml", "org/uncommitted/org-uncommitted-master.yaml",
+		"other/settings/.config.prowgen", "README.md",
+	} {
+		n := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(n), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(n, []byte(f+"content"), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := exec.Command("sh", "-ec", fmt.Sprintf(`
+git init --quiet .
+git config user.name test
+git config user.email test
+git add .
+git commit --quiet -m initial
+cd %s
+> org/changed/org-changed-master.yaml
+git rm --quiet org/removed/org-removed-master.yaml
+mkdir -p org/new
+> org/new/org-new-master.yaml
+git add org/new/org-new-master.yaml
+> other/settings/.config.prowgen
+> README.md
+git commit --quiet --all --message changes
+> org/uncommitted/org-uncommitted-master.yaml
+mkdir -p org/untracked
+> org/untracked/org-untracked-master.yaml
+git rev-parse HEAD^
+`, CiopConfigInRepoPath))
+	p.Dir = tmp
+	out, err := p.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%q failed, output:\n%s", p.Args, out)
+	}
+	changed, err := GetChangedCiopConfigRepos(dir, strings.TrimSpace(string(out)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"org/changed", "org/new", "org/removed", "org/uncommitted", "org/untracked", "other/settings"}
+	if !reflect.DeepEqual(expected, changed) {
+		t.Fatal(diff.ObjectDiff(expected, changed))
+	}
+}
+
+func TestGetAllConfigsFromSHAExtraPathOnlyInPR(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	content := `build_root:
+  image_stream_tag:
+    cluster: https://api.ci.openshift.org
+    namespace: openshift
+    name: release
+    tag: golang-1.10
+resources:
+  '*':
+    requests:
+      cpu: 10m
+tests:
+- as: unit
+  commands: make test
+  container:
+    from: src
+`
+	for _, f := range []string{filepath.Join(CiopConfigInRepoPath, "org/repo/org-repo-master.yaml"), "extra/org/other/org-other-master.yaml"} {
+		n := filepath.Join(tmp, f)
+		if err := os.MkdirAll(filepath.Dir(n), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(n, []byte(content), 0664); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := exec.Command("sh", "-ec", `
+git init --quiet .
+git config user.name test
+git config user.email test
+git add ci-operator
+git commit --quiet -m initial
+git add extra
+git commit --quiet -m extra
+git rev-parse HEAD^
+`)
+	p.Dir = tmp
+	out, err := p.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%q failed, output:\n%s", p.Args, out)
+	}
+	logger := logrus.NewEntry(logrus.New())
+
+	master, err := GetAllConfigsFromSHA(tmp, strings.TrimSpace(string(out)), logger, nil, "extra")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var masterConfigs []string
+	for key := range master.CiOperator {
+		masterConfigs = append(masterConfigs, key)
+	}
+	if expected := []string{"org-repo-master.yaml"}; !reflect.DeepEqual(expected, masterConfigs) {
+		t.Errorf("unexpected configs at the base revision: %s", diff.ObjectReflectDiff(expected, masterConfigs))
+	}
+
+	pr := GetAllConfigs(tmp, logger, nil, "extra")
+	var prConfigs []string
+	for key := range pr.CiOperator {
+		prConfigs = append(prConfigs, key)
+	}
+	sort.Strings(prConfigs)
+	if expected := []string{"org-other-master.yaml", "org-repo-master.yaml"}; !reflect.DeepEqual(expected, prConfigs) {
+		t.Errorf("unexpected configs in the PR: %s", diff.ObjectReflectDiff(expected, prConfigs))
+	}
+}
@@ -16,6 +16,7 @@ import (
 	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
 
 	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
 )
 
 const (
@@ -39,6 +40,8 @@ const (
 	chosenJob            = "Job has been chosen for rehearsal"
 	newCiopConfigMsg     = "New ci-operator config file"
 	changedCiopConfigMsg = "ci-operator config file changed"
+
+	danglingCiopConfigMsg = "Job uses a ci-operator config file that does not exist"
 )
 
 func GetChangedCiopConfigs(masterConfig, prConfig config.CompoundCiopConfig, logger *logrus.Entry) (config.CompoundCiopConfig, map[string]sets.String) {
@@ -61,6 +64,9 @@ func GetChangedCiopConfigs(masterConfig, prConfig config.CompoundCiopConfig, log
 		withoutTestsNewConfig := *prConfig[filename]
 		withoutTestsNewConfig.Tests = nil
 
+		// Changes outside of tests may affect any job using the config, including
+		// the images presubmit which has no test entry, so the affected jobs are
+		// not restricted and all of them are rehearsed
 		if !equality.Semantic.DeepEqual(withoutTestsOldConfig, withoutTestsNewConfig) {
 			logger.WithField(logCiopConfig, filename).Info(changedCiopConfigMsg)
 			ret[filename] = newConfig
@@ -70,24 +76,43 @@ func GetChangedCiopConfigs(masterConfig, prConfig config.CompoundCiopConfig, log
 		oldTests := getTestsByName(oldConfig.Tests)
 		newTests := getTestsByName(newConfig.Tests)
 
+		// tests are matched by name, so reordering them changes nothing and
+		// only the jobs for added or changed tests are affected
 		for as, test := range newTests {
 			if !equality.Semantic.DeepEqual(oldTests[as], test) {
-				logger.WithField(logCiopConfig, filename).Info(changedCiopConfigMsg)
-				ret[filename] = newConfig
 				jobs.Insert(as)
 			}
 		}
 
 		if len(jobs) > 0 {
+			logger.WithField(logCiopConfig, filename).Info(changedCiopConfigMsg)
+			ret[filename] = newConfig
 			affectedJobs[filename] = jobs
 		}
 	}
 	return ret, affectedJobs
 }
 
-// GetChangedPresubmits returns a mapping of repo to presubmits to execute.
-func GetChangedPresubmits(prowMasterConfig, prowPRConfig *prowconfig.Config, logger *logrus.Entry) config.Presubmits {
+// ChangeDetails holds descriptions readable by humans of the changes for
+// which jobs were selected for rehearsal, keyed by job name
+type ChangeDetails map[string][]string
+
+func (d ChangeDetails) add(job, detail string) {
+	d[job] = append(d[job], detail)
+}
+
+// AddAll adds all descriptions from another ChangeDetails
+func (d ChangeDetails) AddAll(other ChangeDetails) {
+	for job, details := range other {
+		d[job] = append(d[job], details...)
+	}
+}
+
+// GetChangedPresubmits returns a mapping of repo to presubmits to execute,
+// together with the differences between the versions of the presubmits.
+func GetChangedPresubmits(prowMasterConfig, prowPRConfig *prowconfig.Config, logger *logrus.Entry) (config.Presubmits, ChangeDetails) {
 	ret := config.Presubmits{}
+	details := ChangeDetails{}
 
 	masterJobs := getJobsByRepoAndName(prowMasterConfig.JobConfig.Presubmits)
 	for repo, jobs := range prowPRConfig.JobConfig.Presubmits {
@@ -101,6 +126,7 @@ func GetChangedPresubmits(prowMasterConfig, prowPRConfig *prowconfig.Config, log
 					logFields[logDiffs] = convertToReadableDiff(masterJob.Agent, job.Agent, objectAgent)
 					logger.WithFields(logFields).Info(chosenJob)
 					ret.Add(repo, job)
+					details.add(job.Name, logFields[logDiffs].(string))
 					continue
 				}
 
@@ -108,11 +134,12 @@ func GetChangedPresubmits(prowMasterConfig, prowPRConfig *prowconfig.Config, log
 					logFields[logDiffs] = convertToReadableDiff(masterJob.Spec, job.Spec, objectSpec)
 					logger.WithFields(logFields).Info(chosenJob)
 					ret.Add(repo, job)
+					details.add(job.Name, logFields[logDiffs].(string))
 				}
 			}
 		}
 	}
-	return ret
+	return ret, details
 }
 
 // To compare two maps of slices, instead of iterating through the slice
@@ -152,8 +179,12 @@ func convertToReadableDiff(a, b interface{}, objName string) string {
 	return d
 }
 
-func GetPresubmitsForCiopConfigs(prowConfig *prowconfig.Config, ciopConfigs config.CompoundCiopConfig, logger *logrus.Entry, affectedJobs map[string]sets.String) config.Presubmits {
+// GetPresubmitsForCiopConfigs returns the presubmits which use the given
+// ci-operator config files, restricted to the affected jobs for files where
+// only some tests changed, together with the files that changed for them.
+func GetPresubmitsForCiopConfigs(prowConfig *prowconfig.Config, ciopConfigs config.CompoundCiopConfig, logger *logrus.Entry, affectedJobs map[string]sets.String) (config.Presubmits, ChangeDetails) {
 	ret := config.Presubmits{}
+	details := ChangeDetails{}
 
 	for repo, jobs := range prowConfig.JobConfig.Presubmits {
 		for _, job := range jobs {
@@ -169,9 +200,12 @@ func GetPresubmitsForCiopConfigs(prowConfig *prowconfig.Config, ciopConfigs conf
 				}
 				if config.IsCiopConfigCM(env.ValueFrom.ConfigMapKeyRef.Name) {
 					if _, ok := ciopConfigs[env.ValueFrom.ConfigMapKeyRef.Key]; ok {
-						testName := strings.TrimPrefix(job.Name, "pull-ci-")
-						orgRepo := strings.Replace(repo, "/", "-", -1)
-						testName = strings.TrimPrefix(testName, fmt.Sprintf("%s-%s-", orgRepo, job.Brancher.Branches[0]))
+						orgRepo := strings.SplitN(repo, "/", 2)
+						testName, ok := jc.PresubmitTestName(job.Name, orgRepo[0], orgRepo[1], job.Brancher.Branches[0])
+						if !ok {
+							// jobs for glob branches are named after the branch without the regex characters
+							testName, _ = jc.PresubmitTestName(job.Name, orgRepo[0], orgRepo[1], jc.MakeRegexFilenameLabel(job.Brancher.Branches[0]))
+						}
 
 						affectedJob, ok := affectedJobs[env.ValueFrom.ConfigMapKeyRef.Key]
 						if ok && !affectedJob.Has(testName) {
@@ -179,12 +213,48 @@ func GetPresubmitsForCiopConfigs(prowConfig *prowconfig.Config, ciopConfigs conf
 						}
 
 						ret.Add(repo, job)
+						details.add(job.Name, fmt.Sprintf("ci-operator config %s changed", env.ValueFrom.ConfigMapKeyRef.Key))
 					}
 				}
 			}
 		}
 	}
 
+	return ret, details
+}
+
+// GetPresubmitsWithDanglingCiopConfigs returns the presubmits from the Prow
+// configuration which read their ci-operator config file from a ConfigMap key
+// that does not correspond to any of the known ci-operator config files. Such
+// jobs are broken, because the key will not be present in the ConfigMap.
+func GetPresubmitsWithDanglingCiopConfigs(prowConfig *prowconfig.Config, logger *logrus.Entry, knownConfigs ...config.CompoundCiopConfig) config.Presubmits {
+	ret := config.Presubmits{}
+
+	for repo, jobs := range prowConfig.JobConfig.Presubmits {
+		for _, job := range jobs {
+			if job.Agent != string(pjapi.KubernetesAgent) || job.Spec == nil {
+				continue
+			}
+			for _, env := range job.Spec.Containers[0].Env {
+				if env.ValueFrom == nil || env.ValueFrom.ConfigMapKeyRef == nil || !config.IsCiopConfigCM(env.ValueFrom.ConfigMapKeyRef.Name) {
+					continue
+				}
+				filename := env.ValueFrom.ConfigMapKeyRef.Key
+				known := false
+				for _, configs := range knownConfigs {
+					if _, ok := configs[filename]; ok {
+						known = true
+						break
+					}
+				}
+				if !known {
+					logger.WithFields(logrus.Fields{logRepo: repo, logJobName: job.Name, logCiopConfig: filename}).Warn(danglingCiopConfigMsg)
+					ret.Add(repo, job)
+				}
+			}
+		}
+	}
+
 	return ret
 }
 
@@ -207,14 +277,9 @@ func GetPresubmitsForClusterProfiles(prowConfig *prowconfig.Config, profiles []c
 		if job.Agent != string(pjapi.KubernetesAgent) {
 			return false
 		}
-		for _, v := range job.Spec.Volumes {
-			if v.Name != "cluster-profile" || v.Projected == nil {
-				continue
-			}
-			for _, s := range v.Projected.Sources {
-				if s.ConfigMap != nil && names.Has(s.ConfigMap.Name) {
-					return true
-				}
+		for _, s := range clusterProfileSources(job.Spec) {
+			if s.ConfigMap != nil && names.Has(s.ConfigMap.Name) {
+				return true
 			}
 		}
 		return false
@@ -1,8 +1,10 @@
 package diffs
 
 import (
+	"fmt"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 
 	"github.com/getlantern/deepcopy"
@@ -136,6 +138,66 @@ func TestGetChangedCiopConfigs(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "reordered tests",
+			configGenerator: func() (config.CompoundCiopConfig, config.CompoundCiopConfig) {
+				before := config.CompoundCiopConfig{"org-repo-branch.yaml": &baseCiopConfig}
+				afterConfig := cioperatorapi.ReleaseBuildConfiguration{}
+				deepcopy.Copy(&afterConfig, baseCiopConfig)
+				afterConfig.Tests[0], afterConfig.Tests[2] = afterConfig.Tests[2], afterConfig.Tests[0]
+				after := config.CompoundCiopConfig{"org-repo-branch.yaml": &afterConfig}
+				return before, after
+			},
+			expected:             func() config.CompoundCiopConfig { return config.CompoundCiopConfig{} },
+			expectedAffectedJobs: map[string]sets.String{},
+		},
+		{
+			name: "reordered and changed tests",
+			configGenerator: func() (config.CompoundCiopConfig, config.CompoundCiopConfig) {
+				before := config.CompoundCiopConfig{"org-repo-branch.yaml": &baseCiopConfig}
+				afterConfig := cioperatorapi.ReleaseBuildConfiguration{}
+				deepcopy.Copy(&afterConfig, baseCiopConfig)
+				afterConfig.Tests[0], afterConfig.Tests[2] = afterConfig.Tests[2], afterConfig.Tests[0]
+				afterConfig.Tests[1].Commands = "changed commands"
+				afterConfig.Tests[2].Commands = "changed commands"
+				after := config.CompoundCiopConfig{"org-repo-branch.yaml": &afterConfig}
+				return before, after
+			},
+			expected: func() config.CompoundCiopConfig {
+				expected := cioperatorapi.ReleaseBuildConfiguration{}
+				deepcopy.Copy(&expected, baseCiopConfig)
+				expected.Tests[0], expected.Tests[2] = expected.Tests[2], expected.Tests[0]
+				expected.Tests[1].Commands = "changed commands"
+				expected.Tests[2].Commands = "changed commands"
+				return config.CompoundCiopConfig{"org-repo-branch.yaml": &expected}
+			},
+			expectedAffectedJobs: map[string]sets.String{
+				"org-repo-branch.yaml": {
+					"e2e":  sets.Empty{},
+					"unit": sets.Empty{},
+				},
+			},
+		},
+		{
+			name: "reordered tests with a renamed and a removed test",
+			configGenerator: func() (config.CompoundCiopConfig, config.CompoundCiopConfig) {
+				before := config.CompoundCiopConfig{"org-repo-branch.yaml": &baseCiopConfig}
+				afterConfig := cioperatorapi.ReleaseBuildConfiguration{}
+				deepcopy.Copy(&afterConfig, baseCiopConfig)
+				afterConfig.Tests = []cioperatorapi.TestStepConfiguration{afterConfig.Tests[2], afterConfig.Tests[0]}
+				afterConfig.Tests[1].As = "unit-renamed"
+				after := config.CompoundCiopConfig{"org-repo-branch.yaml": &afterConfig}
+				return before, after
+			},
+			expected: func() config.CompoundCiopConfig {
+				expected := cioperatorapi.ReleaseBuildConfiguration{}
+				deepcopy.Copy(&expected, baseCiopConfig)
+				expected.Tests = []cioperatorapi.TestStepConfiguration{expected.Tests[2], expected.Tests[0]}
+				expected.Tests[1].As = "unit-renamed"
+				return config.CompoundCiopConfig{"org-repo-branch.yaml": &expected}
+			},
+			expectedAffectedJobs: map[string]sets.String{"org-repo-branch.yaml": {"unit-renamed": sets.Empty{}}},
+		},
 	}
 
 	for _, tc := range testCases {
@@ -213,6 +275,16 @@ func TestGetChangedPresubmits(t *testing.T) {
 				}(),
 			},
 		},
+		{
+			name: "different annotations are not identified as a diff",
+			configGenerator: func() (*prowconfig.Config, *prowconfig.Config) {
+				var p []prowconfig.Presubmit
+				deepcopy.Copy(&p, basePresubmit)
+				p[0].Annotations = map[string]string{"ci-operator.openshift.io/promotion-namespace": "ocp"}
+				return makeConfig(basePresubmit), makeConfig(p)
+			},
+			expected: config.Presubmits{},
+		},
 		{
 			name: "different agent is identified as a diff (from jenkins to kubernetes)",
 			configGenerator: func() (*prowconfig.Config, *prowconfig.Config) {
@@ -279,10 +351,18 @@ func TestGetChangedPresubmits(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
 			before, after := testCase.configGenerator()
-			p := GetChangedPresubmits(before, after, logrus.NewEntry(logrus.New()))
+			p, details := GetChangedPresubmits(before, after, logrus.NewEntry(logrus.New()))
 			if !equality.Semantic.DeepEqual(p, testCase.expected) {
 				t.Fatalf("Name:%s\nExpected %#v\nFound:%#v\n", testCase.name, testCase.expected["org/repo"], p["org/repo"])
 			}
+			if len(details) != len(p["org/repo"]) {
+				t.Errorf("Expected details for %d changed jobs, got %v", len(p["org/repo"]), details)
+			}
+			for _, job := range p["org/repo"] {
+				if len(details[job.Name]) != 1 || details[job.Name][0] == "" {
+					t.Errorf("Expected a single difference for job %s, got %v", job.Name, details[job.Name])
+				}
+			}
 		})
 	}
 }
@@ -329,6 +409,18 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 		"org-repo-branch.yaml": {
 			"testjob": sets.Empty{},
 		},
+		"org-repo-release-4._.yaml": {
+			"testjob": sets.Empty{},
+		},
+	}
+
+	globPresubmit := func(name string) prowconfig.Presubmit {
+		ret := prowconfig.Presubmit{}
+		deepcopy.Copy(&ret, &basePresubmitWithCiop)
+		ret.Name = name
+		ret.Branches = []string{"release-4.*"}
+		ret.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key = "org-repo-release-4._.yaml"
+		return ret
 	}
 
 	testCases := []struct {
@@ -336,6 +428,7 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 		prow        *prowconfig.Config
 		ciop        config.CompoundCiopConfig
 		expected    config.Presubmits
+		details     ChangeDetails
 	}{{
 		description: "return a presubmit using one of the input ciop configs",
 		prow: &prowconfig.Config{
@@ -345,7 +438,7 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 						func() prowconfig.Presubmit {
 							ret := prowconfig.Presubmit{}
 							deepcopy.Copy(&ret, &basePresubmitWithCiop)
-							ret.Name = "org-repo-branch-testjob"
+							ret.Name = "pull-ci-org-repo-branch-testjob"
 							ret.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key = baseCiopConfig.Filename
 							return ret
 						}(),
@@ -357,11 +450,12 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 			func() prowconfig.Presubmit {
 				ret := prowconfig.Presubmit{}
 				deepcopy.Copy(&ret, &basePresubmitWithCiop)
-				ret.Name = "org-repo-branch-testjob"
+				ret.Name = "pull-ci-org-repo-branch-testjob"
 				ret.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key = baseCiopConfig.Filename
 				return ret
 			}(),
 		}},
+		details: ChangeDetails{"pull-ci-org-repo-branch-testjob": {"ci-operator config org-repo-branch.yaml changed"}},
 	}, {
 		description: "do not return a presubmit using a ciop config not present in input",
 		prow: &prowconfig.Config{
@@ -371,7 +465,7 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 						func() prowconfig.Presubmit {
 							ret := prowconfig.Presubmit{}
 							deepcopy.Copy(&ret, &basePresubmitWithCiop)
-							ret.Name = "org-repo-branch-testjob"
+							ret.Name = "pull-ci-org-repo-branch-testjob"
 							ret.Spec.Containers[0].Env[0].ValueFrom.ConfigMapKeyRef.Key = baseCiopConfig.Filename
 							return ret
 						}(),
@@ -389,7 +483,7 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 						func() prowconfig.Presubmit {
 							ret := prowconfig.Presubmit{}
 							deepcopy.Copy(&ret, &basePresubmitWithCiop)
-							ret.Name = "org-repo-branch-testjob"
+							ret.Name = "pull-ci-org-repo-branch-testjob"
 							ret.Agent = string(pjapi.JenkinsAgent)
 							ret.Spec.Containers[0].Env = []v1.EnvVar{}
 							return ret
@@ -399,20 +493,78 @@ func TestGetPresubmitsForCiopConfigs(t *testing.T) {
 		},
 		ciop:     config.CompoundCiopConfig{},
 		expected: config.Presubmits{},
+	}, {
+		description: "return only the affected presubmits for a ciop config with a glob branch",
+		prow: &prowconfig.Config{
+			JobConfig: prowconfig.JobConfig{
+				Presubmits: map[string][]prowconfig.Presubmit{
+					"org/repo": {
+						globPresubmit("pull-ci-org-repo-release-4-testjob"),
+						globPresubmit("pull-ci-org-repo-release-4-otherjob"),
+					}},
+			},
+		},
+		ciop:     config.CompoundCiopConfig{"org-repo-release-4._.yaml": &cioperatorapi.ReleaseBuildConfiguration{}},
+		expected: config.Presubmits{"org/repo": {globPresubmit("pull-ci-org-repo-release-4-testjob")}},
+		details:  ChangeDetails{"pull-ci-org-repo-release-4-testjob": {"ci-operator config org-repo-release-4._.yaml changed"}},
 	},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
-			presubmits := GetPresubmitsForCiopConfigs(tc.prow, tc.ciop, logrus.NewEntry(logrus.New()), affectedJobs)
+			presubmits, details := GetPresubmitsForCiopConfigs(tc.prow, tc.ciop, logrus.NewEntry(logrus.New()), affectedJobs)
 
 			if !reflect.DeepEqual(tc.expected, presubmits) {
 				t.Errorf("Returned presubmits differ from expected:\n%s", diff.ObjectDiff(tc.expected, presubmits))
 			}
+			if tc.details == nil {
+				tc.details = ChangeDetails{}
+			}
+			if !reflect.DeepEqual(tc.details, details) {
+				t.Errorf("Returned details differ from expected:\n%s", diff.ObjectReflectDiff(tc.details, details))
+			}
 		})
 	}
 }
 
+func TestGetPresubmitsWithDanglingCiopConfigs(t *testing.T) {
+	makePresubmit := func(name, cm, key string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{
+			JobBase: prowconfig.JobBase{
+				Name:  name,
+				Agent: string(pjapi.KubernetesAgent),
+				Spec: &v1.PodSpec{
+					Containers: []v1.Container{{
+						Env: []v1.EnvVar{{
+							ValueFrom: &v1.EnvVarSource{
+								ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+									LocalObjectReference: v1.LocalObjectReference{Name: cm},
+									Key:                  key,
+								},
+							},
+						}},
+					}},
+				},
+			},
+		}
+	}
+	inMaster := makePresubmit("in-master", "ci-operator-master-configs", "org-repo-master.yaml")
+	inPR := makePresubmit("in-pr", "ci-operator-master-configs", "org-repo-new.yaml")
+	dangling := makePresubmit("dangling", "ci-operator-master-configs", "org-repo-gone.yaml")
+	otherCM := makePresubmit("other-cm", "some-other-configmap", "org-repo-gone.yaml")
+	jenkins := makePresubmit("jenkins", "ci-operator-master-configs", "org-repo-gone.yaml")
+	jenkins.Agent = string(pjapi.JenkinsAgent)
+
+	prowConfig := makeConfig([]prowconfig.Presubmit{inMaster, inPR, dangling, otherCM, jenkins})
+	master := config.CompoundCiopConfig{"org-repo-master.yaml": &cioperatorapi.ReleaseBuildConfiguration{}}
+	pr := config.CompoundCiopConfig{"org-repo-new.yaml": &cioperatorapi.ReleaseBuildConfiguration{}}
+
+	expected := config.Presubmits{"org/repo": {dangling}}
+	if presubmits := GetPresubmitsWithDanglingCiopConfigs(prowConfig, logrus.NewEntry(logrus.New()), master, pr); !reflect.DeepEqual(expected, presubmits) {
+		t.Errorf("Returned presubmits differ from expected:\n%s", diff.ObjectDiff(expected, presubmits))
+	}
+}
+
 func TestGetPresubmitsForClusterProfiles(t *testing.T) {
 	makePresubmit := func(name string, agent pjapi.ProwJobAgent, profiles []string) prowconfig.Presubmit {
 		ret := prowconfig.Presubmit{
@@ -527,3 +679,90 @@ func TestGetPresubmitsForClusterProfiles(t *testing.T) {
 		})
 	}
 }
+
+func TestGetPresubmitsForChangedCiopConfigsImages(t *testing.T) {
+	info := config.Info{Org: "org", Repo: "repo", Branch: "branch"}
+	makePresubmit := func(test string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{
+			Brancher: prowconfig.Brancher{Branches: []string{info.Branch}},
+			JobBase: prowconfig.JobBase{
+				Name:  fmt.Sprintf("pull-ci-org-repo-branch-%s", test),
+				Agent: string(pjapi.KubernetesAgent),
+				Spec: &v1.PodSpec{
+					Containers: []v1.Container{{
+						Env: []v1.EnvVar{{
+							ValueFrom: &v1.EnvVarSource{
+								ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+									LocalObjectReference: v1.LocalObjectReference{Name: info.ConfigMapName()},
+									Key:                  info.Basename(),
+								},
+							},
+						}},
+					}},
+				},
+			},
+		}
+	}
+	prow := &prowconfig.Config{JobConfig: prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {makePresubmit("unit"), makePresubmit("images")}},
+	}}
+	makeConfig := func() *cioperatorapi.ReleaseBuildConfiguration {
+		return &cioperatorapi.ReleaseBuildConfiguration{
+			InputConfiguration: cioperatorapi.InputConfiguration{
+				BaseImages: map[string]cioperatorapi.ImageStreamTagReference{"base": {Name: "base", Tag: "latest"}},
+			},
+			Images: []cioperatorapi.ProjectDirectoryImageBuildStepConfiguration{{To: "component"}},
+			Tests:  []cioperatorapi.TestStepConfiguration{{As: "unit", Commands: "make unit"}},
+		}
+	}
+
+	testCases := []struct {
+		description string
+		change      func(*cioperatorapi.ReleaseBuildConfiguration)
+		expected    []string
+	}{
+		{
+			description: "changed base images rehearse the images presubmit",
+			change: func(c *cioperatorapi.ReleaseBuildConfiguration) {
+				c.BaseImages["base"] = cioperatorapi.ImageStreamTagReference{Name: "base", Tag: "other"}
+			},
+			expected: []string{"pull-ci-org-repo-branch-images", "pull-ci-org-repo-branch-unit"},
+		},
+		{
+			description: "changed images rehearse the images presubmit",
+			change: func(c *cioperatorapi.ReleaseBuildConfiguration) {
+				c.Images[0].To = "other"
+			},
+			expected: []string{"pull-ci-org-repo-branch-images", "pull-ci-org-repo-branch-unit"},
+		},
+		{
+			description: "changed tests do not rehearse the images presubmit",
+			change: func(c *cioperatorapi.ReleaseBuildConfiguration) {
+				c.Tests[0].Commands = "make other"
+			},
+			expected: []string{"pull-ci-org-repo-branch-unit"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			logger := logrus.NewEntry(logrus.New())
+			after := makeConfig()
+			tc.change(after)
+			changed, affectedJobs := GetChangedCiopConfigs(
+				config.CompoundCiopConfig{info.Basename(): makeConfig()},
+				config.CompoundCiopConfig{info.Basename(): after},
+				logger,
+			)
+
+			var names []string
+			presubmits, _ := GetPresubmitsForCiopConfigs(prow, changed, logger, affectedJobs)
+			for _, job := range presubmits["org/repo"] {
+				names = append(names, job.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(tc.expected, names) {
+				t.Errorf("Rehearsed presubmits differ from expected:\n%s", diff.ObjectReflectDiff(tc.expected, names))
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package diffs
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// RepoJobsDiff lists names of jobs for a single repository that differ
+// between two job configurations
+type RepoJobsDiff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+// GetJobConfigDiffs compares jobs of two job configurations and returns, for each
+// org/repo with differences, which jobs were added, removed or semantically
+// changed. Periodics are attributed to the repository of their first extra ref
+// or to an empty key if they have none. Job names in each list are sorted.
+func GetJobConfigDiffs(before, after *prowconfig.JobConfig) map[string]*RepoJobsDiff {
+	diffs := map[string]*RepoJobsDiff{}
+	record := func(repo string, beforeJobs, afterJobs map[string]interface{}) {
+		repoDiff := &RepoJobsDiff{}
+		for name, afterJob := range afterJobs {
+			if beforeJob, existed := beforeJobs[name]; !existed {
+				repoDiff.Added = append(repoDiff.Added, name)
+			} else if !equality.Semantic.DeepEqual(beforeJob, afterJob) {
+				repoDiff.Changed = append(repoDiff.Changed, name)
+			}
+		}
+		for name := range beforeJobs {
+			if _, exists := afterJobs[name]; !exists {
+				repoDiff.Removed = append(repoDiff.Removed, name)
+			}
+		}
+		if len(repoDiff.Added)+len(repoDiff.Removed)+len(repoDiff.Changed) == 0 {
+			return
+		}
+		if diffs[repo] == nil {
+			diffs[repo] = &RepoJobsDiff{}
+		}
+		diffs[repo].Added = append(diffs[repo].Added, repoDiff.Added...)
+		diffs[repo].Removed = append(diffs[repo].Removed, repoDiff.Removed...)
+		diffs[repo].Changed = append(diffs[repo].Changed, repoDiff.Changed...)
+	}
+
+	presubmitsByName := func(jobs []prowconfig.Presubmit) map[string]interface{} {
+		byName := map[string]interface{}{}
+		for _, job := range jobs {
+			byName[job.Name] = job
+		}
+		return byName
+	}
+	postsubmitsByName := func(jobs []prowconfig.Postsubmit) map[string]interface{} {
+		byName := map[string]interface{}{}
+		for _, job := range jobs {
+			byName[job.Name] = job
+		}
+		return byName
+	}
+	for _, repo := range sets.StringKeySet(before.Presubmits).Union(sets.StringKeySet(after.Presubmits)).List() {
+		record(repo, presubmitsByName(before.Presubmits[repo]), presubmitsByName(after.Presubmits[repo]))
+	}
+	for _, repo := range sets.StringKeySet(before.Postsubmits).Union(sets.StringKeySet(after.Postsubmits)).List() {
+		record(repo, postsubmitsByName(before.Postsubmits[repo]), postsubmitsByName(after.Postsubmits[repo]))
+	}
+	beforePeriodics, afterPeriodics := periodicsByRepo(before.Periodics), periodicsByRepo(after.Periodics)
+	for _, repo := range sets.StringKeySet(beforePeriodics).Union(sets.StringKeySet(afterPeriodics)).List() {
+		record(repo, beforePeriodics[repo], afterPeriodics[repo])
+	}
+
+	for _, repoDiff := range diffs {
+		sort.Strings(repoDiff.Added)
+		sort.Strings(repoDiff.Removed)
+		sort.Strings(repoDiff.Changed)
+	}
+	return diffs
+}
+
+// comparablePeriodic holds the exported fields of a periodic, as the semantic
+// comparison cannot handle the unexported ones
+type comparablePeriodic struct {
+	prowconfig.JobBase
+	Interval string
+	Cron     string
+	Tags     []string
+}
+
+// periodicsByRepo groups periodics by the repository of their first extra ref
+// and by name
+func periodicsByRepo(jobs []prowconfig.Periodic) map[string]map[string]interface{} {
+	byRepo := map[string]map[string]interface{}{}
+	for _, job := range jobs {
+		repo := ""
+		if len(job.ExtraRefs) > 0 {
+			repo = fmt.Sprintf("%s/%s", job.ExtraRefs[0].Org, job.ExtraRefs[0].Repo)
+		}
+		if byRepo[repo] == nil {
+			byRepo[repo] = map[string]interface{}{}
+		}
+		byRepo[repo][job.Name] = comparablePeriodic{JobBase: job.JobBase, Interval: job.Interval, Cron: job.Cron, Tags: job.Tags}
+	}
+	return byRepo
+}
+
+// PrintJobConfigDiffs writes a human-readable listing of the differences
+// returned by GetJobConfigDiffs, sorted by repository, followed by totals
+func PrintJobConfigDiffs(out io.Writer, repoDiffs map[string]*RepoJobsDiff) {
+	if len(repoDiffs) == 0 {
+		fmt.Fprintln(out, "No jobs were added, removed or changed")
+		return
+	}
+
+	var repos []string
+	for repo := range repoDiffs {
+		repos = append(repos, repo)
+	}
+	sort.Strings(repos)
+
+	var added, removed, changed int
+	for _, repo := range repos {
+		repoDiff := repoDiffs[repo]
+		fmt.Fprintf(out, "%s: %d added, %d removed, %d changed\n", repo, len(repoDiff.Added), len(repoDiff.Removed), len(repoDiff.Changed))
+		for _, name := range repoDiff.Added {
+			fmt.Fprintf(out, "  + %s\n", name)
+		}
+		for _, name := range repoDiff.Removed {
+			fmt.Fprintf(out, "  - %s\n", name)
+		}
+		for _, name := range repoDiff.Changed {
+			fmt.Fprintf(out, "  ~ %s\n", name)
+		}
+		added += len(repoDiff.Added)
+		removed += len(repoDiff.Removed)
+		changed += len(repoDiff.Changed)
+	}
+	fmt.Fprintf(out, "\nTotal: %d repos, %d added, %d removed, %d changed\n", len(repos), added, removed, changed)
+}
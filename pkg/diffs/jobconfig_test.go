@@ -0,0 +1,108 @@
+package diffs
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestGetJobConfigDiffs(t *testing.T) {
+	presubmit := func(name, arg string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{JobBase: prowconfig.JobBase{
+			Name: name,
+			Spec: &v1.PodSpec{Containers: []v1.Container{{Args: []string{arg}}}},
+		}}
+	}
+	postsubmit := func(name, arg string) prowconfig.Postsubmit {
+		return prowconfig.Postsubmit{JobBase: prowconfig.JobBase{
+			Name: name,
+			Spec: &v1.PodSpec{Containers: []v1.Container{{Args: []string{arg}}}},
+		}}
+	}
+	periodic := func(name, arg string) prowconfig.Periodic {
+		return prowconfig.Periodic{JobBase: prowconfig.JobBase{
+			Name:          name,
+			Spec:          &v1.PodSpec{Containers: []v1.Container{{Args: []string{arg}}}},
+			UtilityConfig: prowconfig.UtilityConfig{ExtraRefs: []pjapi.Refs{{Org: "org", Repo: "repo"}}},
+		}}
+	}
+	before := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{
+			"org/repo":      {presubmit("unchanged", "a"), presubmit("changed", "a"), presubmit("removed", "a")},
+			"org/unchanged": {presubmit("unchanged", "a")},
+			"org/removed":   {presubmit("removed", "a")},
+		},
+		Postsubmits: map[string][]prowconfig.Postsubmit{
+			"org/repo": {postsubmit("post-changed", "a")},
+		},
+		Periodics: []prowconfig.Periodic{periodic("periodic-unchanged", "a"), periodic("periodic-changed", "a"), periodic("periodic-removed", "a")},
+	}
+	after := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{
+			"org/repo":      {presubmit("added", "a"), presubmit("changed", "b"), presubmit("unchanged", "a")},
+			"org/unchanged": {presubmit("unchanged", "a")},
+			"org/added":     {presubmit("added", "a")},
+		},
+		Postsubmits: map[string][]prowconfig.Postsubmit{
+			"org/repo": {postsubmit("post-changed", "b"), postsubmit("post-added", "a")},
+		},
+		Periodics: []prowconfig.Periodic{periodic("periodic-changed", "b"), periodic("periodic-unchanged", "a")},
+	}
+
+	expected := map[string]*RepoJobsDiff{
+		"org/repo": {
+			Added:   []string{"added", "post-added"},
+			Removed: []string{"periodic-removed", "removed"},
+			Changed: []string{"changed", "periodic-changed", "post-changed"},
+		},
+		"org/removed": {Removed: []string{"removed"}},
+		"org/added":   {Added: []string{"added"}},
+	}
+	if diffs := GetJobConfigDiffs(before, after); !reflect.DeepEqual(expected, diffs) {
+		t.Errorf("unexpected diffs: %s", diff.ObjectReflectDiff(expected, diffs))
+	}
+}
+
+func TestPrintJobConfigDiffs(t *testing.T) {
+	testCases := []struct {
+		name      string
+		repoDiffs map[string]*RepoJobsDiff
+		expected  string
+	}{
+		{
+			name:     "no diffs",
+			expected: "No jobs were added, removed or changed\n",
+		},
+		{
+			name: "diffs are listed by repo",
+			repoDiffs: map[string]*RepoJobsDiff{
+				"org/repo":  {Added: []string{"added"}, Changed: []string{"changed"}},
+				"org/other": {Removed: []string{"removed"}},
+			},
+			expected: `org/other: 0 added, 1 removed, 0 changed
+  - removed
+org/repo: 1 added, 0 removed, 1 changed
+  + added
+  ~ changed
+
+Total: 2 repos, 1 added, 1 removed, 1 changed
+`,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			out := &bytes.Buffer{}
+			PrintJobConfigDiffs(out, testCase.repoDiffs)
+			if out.String() != testCase.expected {
+				t.Errorf("unexpected output: %s", diff.StringDiff(testCase.expected, out.String()))
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package diffs
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	kubeapi "k8s.io/api/core/v1"
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+)
+
+// clusterProfileSecretPrefix is the prefix of the secrets of cluster profiles
+// whose jobs mount no ConfigMap for the profile
+const clusterProfileSecretPrefix = "cluster-secrets-"
+
+// ClusterProfileUser is a job using a cluster profile
+type ClusterProfileUser struct {
+	// Repo is the org/repo of the job; for periodics, it is the repository
+	// of their first extra ref or empty if they have none
+	Repo string
+	Job  string
+}
+
+// clusterProfileSources returns the projections of the volumes through which
+// the pod mounts a cluster profile
+func clusterProfileSources(spec *kubeapi.PodSpec) []kubeapi.VolumeProjection {
+	if spec == nil {
+		return nil
+	}
+	var sources []kubeapi.VolumeProjection
+	for _, v := range spec.Volumes {
+		if v.Name != "cluster-profile" || v.Projected == nil {
+			continue
+		}
+		sources = append(sources, v.Projected.Sources...)
+	}
+	return sources
+}
+
+// clusterProfileName returns the name of the cluster profile the pod mounts,
+// or an empty string if it mounts none. Profiles are recognized by their
+// ConfigMap and, as some profiles have none, by their secret.
+func clusterProfileName(spec *kubeapi.PodSpec) string {
+	var fromSecret string
+	for _, s := range clusterProfileSources(spec) {
+		if s.ConfigMap != nil && strings.HasPrefix(s.ConfigMap.Name, config.ClusterProfilePrefix) {
+			return strings.TrimPrefix(s.ConfigMap.Name, config.ClusterProfilePrefix)
+		}
+		if s.Secret != nil && strings.HasPrefix(s.Secret.Name, clusterProfileSecretPrefix) {
+			fromSecret = strings.TrimPrefix(s.Secret.Name, clusterProfileSecretPrefix)
+		}
+	}
+	return fromSecret
+}
+
+// GetClusterProfileUsage returns the jobs from the Prow configuration which
+// use each cluster profile, keyed by the profile name and sorted by repository
+// and job name
+func GetClusterProfileUsage(prowConfig *prowconfig.Config) map[string][]ClusterProfileUser {
+	usage := map[string][]ClusterProfileUser{}
+	record := func(repo, job string, spec *kubeapi.PodSpec) {
+		if profile := clusterProfileName(spec); profile != "" {
+			usage[profile] = append(usage[profile], ClusterProfileUser{Repo: repo, Job: job})
+		}
+	}
+
+	for repo, jobs := range prowConfig.JobConfig.Presubmits {
+		for _, job := range jobs {
+			record(repo, job.Name, job.Spec)
+		}
+	}
+	for repo, jobs := range prowConfig.JobConfig.Postsubmits {
+		for _, job := range jobs {
+			record(repo, job.Name, job.Spec)
+		}
+	}
+	for _, job := range prowConfig.JobConfig.Periodics {
+		repo := ""
+		if len(job.ExtraRefs) > 0 {
+			repo = fmt.Sprintf("%s/%s", job.ExtraRefs[0].Org, job.ExtraRefs[0].Repo)
+		}
+		record(repo, job.Name, job.Spec)
+	}
+
+	for _, users := range usage {
+		sort.Slice(users, func(i, j int) bool {
+			if users[i].Repo != users[j].Repo {
+				return users[i].Repo < users[j].Repo
+			}
+			return users[i].Job < users[j].Job
+		})
+	}
+	return usage
+}
@@ -0,0 +1,66 @@
+package diffs
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestGetClusterProfileUsage(t *testing.T) {
+	spec := func(sources ...v1.VolumeProjection) *v1.PodSpec {
+		return &v1.PodSpec{Volumes: []v1.Volume{{
+			Name:         "cluster-profile",
+			VolumeSource: v1.VolumeSource{Projected: &v1.ProjectedVolumeSource{Sources: sources}},
+		}}}
+	}
+	secret := func(name string) v1.VolumeProjection {
+		return v1.VolumeProjection{Secret: &v1.SecretProjection{LocalObjectReference: v1.LocalObjectReference{Name: name}}}
+	}
+	configMap := func(name string) v1.VolumeProjection {
+		return v1.VolumeProjection{ConfigMap: &v1.ConfigMapProjection{LocalObjectReference: v1.LocalObjectReference{Name: name}}}
+	}
+	prowConfig := &prowconfig.Config{JobConfig: prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{
+			"org/repo": {
+				{JobBase: prowconfig.JobBase{Name: "pull-ci-org-repo-master-e2e-aws", Spec: spec(secret("cluster-secrets-aws"))}},
+				{JobBase: prowconfig.JobBase{Name: "pull-ci-org-repo-master-e2e-gcp", Spec: spec(secret("cluster-secrets-gcp"), configMap("cluster-profile-gcp"))}},
+				{JobBase: prowconfig.JobBase{Name: "pull-ci-org-repo-master-unit", Spec: &v1.PodSpec{}}},
+				{JobBase: prowconfig.JobBase{Name: "pull-ci-org-repo-master-jenkins"}},
+			},
+			"org/other": {
+				{JobBase: prowconfig.JobBase{Name: "pull-ci-org-other-master-e2e-aws", Spec: spec(secret("cluster-secrets-aws"))}},
+			},
+		},
+		Postsubmits: map[string][]prowconfig.Postsubmit{
+			"org/repo": {
+				{JobBase: prowconfig.JobBase{Name: "branch-ci-org-repo-master-e2e-gcp-crio", Spec: spec(secret("cluster-secrets-gcp"), configMap("cluster-profile-gcp-crio"))}},
+			},
+		},
+		Periodics: []prowconfig.Periodic{
+			{JobBase: prowconfig.JobBase{Name: "periodic-e2e-aws", Spec: spec(secret("cluster-secrets-aws"))}},
+			{JobBase: prowconfig.JobBase{
+				Name:          "periodic-org-repo-e2e-aws",
+				Spec:          spec(secret("cluster-secrets-aws")),
+				UtilityConfig: prowconfig.UtilityConfig{ExtraRefs: []pjapi.Refs{{Org: "org", Repo: "repo"}}},
+			}},
+		},
+	}}
+
+	expected := map[string][]ClusterProfileUser{
+		"aws": {
+			{Job: "periodic-e2e-aws"},
+			{Repo: "org/other", Job: "pull-ci-org-other-master-e2e-aws"},
+			{Repo: "org/repo", Job: "periodic-org-repo-e2e-aws"},
+			{Repo: "org/repo", Job: "pull-ci-org-repo-master-e2e-aws"},
+		},
+		"gcp":      {{Repo: "org/repo", Job: "pull-ci-org-repo-master-e2e-gcp"}},
+		"gcp-crio": {{Repo: "org/repo", Job: "branch-ci-org-repo-master-e2e-gcp-crio"}},
+	}
+	if usage := GetClusterProfileUsage(prowConfig); !reflect.DeepEqual(expected, usage) {
+		t.Errorf("unexpected cluster profile usage: %s", diff.ObjectReflectDiff(expected, usage))
+	}
+}
@@ -0,0 +1,76 @@
+package diffs
+
+import (
+	"fmt"
+	"strings"
+
+	kubeapi "k8s.io/api/core/v1"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// CiOperatorArgValues returns the values of all occurrences of the given flag
+// in ci-operator arguments. Both `--flag=value` and `--flag value` forms are
+// recognized, with one or two leading dashes. A flag without a value results
+// in an empty string.
+func CiOperatorArgValues(args []string, flag string) []string {
+	var values []string
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimPrefix(strings.TrimPrefix(args[i], "-"), "-")
+		if name == args[i] {
+			continue
+		}
+		if name == flag {
+			value := ""
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+				value = args[i]
+			}
+			values = append(values, value)
+		} else if strings.HasPrefix(name, flag+"=") {
+			values = append(values, strings.TrimPrefix(name, flag+"="))
+		}
+	}
+	return values
+}
+
+// GetTargetsByRepo returns, for each org/repo, how many times jobs invoke
+// ci-operator with each target. Periodics are attributed to the repository
+// of their first extra ref or to an empty key if they have none.
+func GetTargetsByRepo(jobConfig *prowconfig.JobConfig) map[string]map[string]int {
+	targets := map[string]map[string]int{}
+	count := func(repo string, spec *kubeapi.PodSpec) {
+		if spec == nil {
+			return
+		}
+		for _, container := range spec.Containers {
+			if len(container.Command) != 1 || container.Command[0] != "ci-operator" {
+				continue
+			}
+			for _, target := range CiOperatorArgValues(container.Args, "target") {
+				if targets[repo] == nil {
+					targets[repo] = map[string]int{}
+				}
+				targets[repo][target]++
+			}
+		}
+	}
+
+	for repo, jobs := range jobConfig.Presubmits {
+		for _, job := range jobs {
+			count(repo, job.Spec)
+		}
+	}
+	for repo, jobs := range jobConfig.Postsubmits {
+		for _, job := range jobs {
+			count(repo, job.Spec)
+		}
+	}
+	for _, job := range jobConfig.Periodics {
+		repo := ""
+		if len(job.ExtraRefs) > 0 {
+			repo = fmt.Sprintf("%s/%s", job.ExtraRefs[0].Org, job.ExtraRefs[0].Repo)
+		}
+		count(repo, job.Spec)
+	}
+	return targets
+}
@@ -0,0 +1,81 @@
+package diffs
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestCiOperatorArgValues(t *testing.T) {
+	testCases := []struct {
+		description string
+		args        []string
+		flag        string
+		expected    []string
+	}{{
+		description: "no occurrence",
+		args:        []string{"--artifact-dir=$(ARTIFACTS)", "--target=unit"},
+		flag:        "git-ref",
+	}, {
+		description: "all supported forms",
+		args:        []string{"--target=unit", "-target=e2e", "--target", "[images]", "-target", "lint", "--targets=other"},
+		flag:        "target",
+		expected:    []string{"unit", "e2e", "[images]", "lint"},
+	}, {
+		description: "flag without value",
+		args:        []string{"--git-ref", "--target=unit"},
+		flag:        "git-ref",
+		expected:    []string{""},
+	}, {
+		description: "positional argument is not a flag",
+		args:        []string{"target=unit"},
+		flag:        "target",
+	}}
+	for _, tc := range testCases {
+		if values := CiOperatorArgValues(tc.args, tc.flag); !reflect.DeepEqual(tc.expected, values) {
+			t.Errorf("%s: unexpected values: %s", tc.description, diff.ObjectReflectDiff(tc.expected, values))
+		}
+	}
+}
+
+func TestGetTargetsByRepo(t *testing.T) {
+	spec := func(command string, args ...string) *v1.PodSpec {
+		return &v1.PodSpec{Containers: []v1.Container{{Command: []string{command}, Args: args}}}
+	}
+	jobConfig := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{
+			"org/repo": {
+				{JobBase: prowconfig.JobBase{Name: "unit", Spec: spec("ci-operator", "--target=unit")}},
+				{JobBase: prowconfig.JobBase{Name: "images", Spec: spec("ci-operator", "--target=[images]", "--target=[release:latest]")}},
+				{JobBase: prowconfig.JobBase{Name: "other", Spec: spec("make", "--target=unit")}},
+				{JobBase: prowconfig.JobBase{Name: "jenkins"}},
+			},
+			"org/other": {
+				{JobBase: prowconfig.JobBase{Name: "unit", Spec: spec("ci-operator", "--target=unit")}},
+			},
+		},
+		Postsubmits: map[string][]prowconfig.Postsubmit{
+			"org/repo": {
+				{JobBase: prowconfig.JobBase{Name: "images", Spec: spec("ci-operator", "--target=[images]", "--promote")}},
+			},
+		},
+		Periodics: []prowconfig.Periodic{
+			{JobBase: prowconfig.JobBase{Name: "e2e", Spec: spec("ci-operator", "--target=e2e"), UtilityConfig: prowconfig.UtilityConfig{ExtraRefs: []pjapi.Refs{{Org: "org", Repo: "repo"}}}}},
+			{JobBase: prowconfig.JobBase{Name: "cleanup", Spec: spec("ci-operator", "--target=cleanup")}},
+		},
+	}
+	expected := map[string]map[string]int{
+		"org/repo":  {"unit": 1, "[images]": 2, "[release:latest]": 1, "e2e": 1},
+		"org/other": {"unit": 1},
+		"":          {"cleanup": 1},
+	}
+	if targets := GetTargetsByRepo(jobConfig); !reflect.DeepEqual(expected, targets) {
+		t.Errorf("unexpected targets: %s", diff.ObjectReflectDiff(expected, targets))
+	}
+}
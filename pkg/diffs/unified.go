@@ -0,0 +1,171 @@
+package diffs
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// unifiedContext is the number of unchanged lines shown around changes
+const unifiedContext = 3
+
+// lineOp is one line of a line diff: kept (' '), removed ('-') or added ('+')
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// UnifiedDiff returns the unified diff turning `before` into `after`, with the
+// files labeled `fromName` and `toName` in the header, or an empty string when
+// they do not differ
+func UnifiedDiff(fromName, toName, before, after string) string {
+	if before == after {
+		return ""
+	}
+	ops := diffLines(splitLines(before), splitLines(after))
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	// line numbers before the hunk being collected in both files
+	beforeLine, afterLine := 0, 0
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			beforeLine++
+			afterLine++
+			start++
+			continue
+		}
+		// include the context before the first change and extend the hunk
+		// until there are more unchanged lines than the context around two changes
+		hunkStart := start
+		for hunkStart > 0 && start-hunkStart < unifiedContext && ops[hunkStart-1].kind == ' ' {
+			hunkStart--
+		}
+		hunkEnd, unchanged := start, 0
+		for ; hunkEnd < len(ops) && unchanged <= 2*unifiedContext; hunkEnd++ {
+			if ops[hunkEnd].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		if unchanged > unifiedContext {
+			hunkEnd -= unchanged - unifiedContext
+		}
+
+		beforeStart, afterStart := beforeLine-(start-hunkStart), afterLine-(start-hunkStart)
+		var beforeCount, afterCount int
+		var lines bytes.Buffer
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.kind != '+' {
+				beforeCount++
+			}
+			if op.kind != '-' {
+				afterCount++
+			}
+			fmt.Fprintf(&lines, "%c%s\n", op.kind, op.line)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(beforeStart, beforeCount), hunkRange(afterStart, afterCount))
+		out.Write(lines.Bytes())
+
+		for _, op := range ops[start:hunkEnd] {
+			if op.kind != '+' {
+				beforeLine++
+			}
+			if op.kind != '-' {
+				afterLine++
+			}
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// hunkRange formats the range of a hunk in one file, given the number of
+// lines before the hunk and in it. Empty ranges name the line they follow.
+func hunkRange(before, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+// splitLines splits the content into lines without their line breaks
+func splitLines(content string) []string {
+	if content == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+}
+
+// diffLines returns the shortest edit script turning `a` into `b`, found
+// with the Myers algorithm
+func diffLines(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	// trace holds the furthest reaching x for every diagonal before each round
+	var trace [][]int
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+	// not reached: the script never needs more than n+m edits
+	return nil
+}
+
+// backtrack walks the rounds recorded by diffLines back from the end of both
+// inputs and returns the edit script in order
+func backtrack(a, b []string, trace [][]int, offset int) []lineOp {
+	var ops []lineOp
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var previousK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			previousK = k + 1
+		} else {
+			previousK = k - 1
+		}
+		previousX := v[offset+previousK]
+		previousY := previousX - previousK
+		for x > previousX && y > previousY {
+			ops = append(ops, lineOp{kind: ' ', line: a[x-1]})
+			x--
+			y--
+		}
+		if x == previousX {
+			ops = append(ops, lineOp{kind: '+', line: b[y-1]})
+			y--
+		} else {
+			ops = append(ops, lineOp{kind: '-', line: a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, lineOp{kind: ' ', line: a[x-1]})
+		x--
+		y--
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
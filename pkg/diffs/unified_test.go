@@ -0,0 +1,106 @@
+package diffs
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	testCases := []struct {
+		description string
+		before      string
+		after       string
+		expected    string
+	}{
+		{
+			description: "no changes",
+			before:      "a\nb\n",
+			after:       "a\nb\n",
+			expected:    "",
+		},
+		{
+			description: "new file",
+			before:      "",
+			after:       "a\nb\n",
+			expected: `--- before
++++ after
+@@ -0,0 +1,2 @@
++a
++b
+`,
+		},
+		{
+			description: "removed file",
+			before:      "a\n",
+			after:       "",
+			expected: `--- before
++++ after
+@@ -1,1 +0,0 @@
+-a
+`,
+		},
+		{
+			description: "change in the middle is shown with context",
+			before:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			after:       "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected: `--- before
++++ after
+@@ -2,7 +2,7 @@
+ 2
+ 3
+ 4
+-5
++five
+ 6
+ 7
+ 8
+`,
+		},
+		{
+			description: "distant changes are in separate hunks",
+			before:      "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			after:       "one\n2\n3\n4\n5\n6\n7\n8\n9\n10\neleven\n",
+			expected: `--- before
++++ after
+@@ -1,4 +1,4 @@
+-1
++one
+ 2
+ 3
+ 4
+@@ -8,3 +8,4 @@
+ 8
+ 9
+ 10
++eleven
+`,
+		},
+		{
+			description: "close changes share a hunk",
+			before:      "1\n2\n3\n4\n5\n6\n7\n8\n",
+			after:       "one\n2\n3\n4\n5\n6\n7\neight\n",
+			expected: `--- before
++++ after
+@@ -1,8 +1,8 @@
+-1
++one
+ 2
+ 3
+ 4
+ 5
+ 6
+ 7
+-8
++eight
+`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if actual := UnifiedDiff("before", "after", tc.before, tc.after); actual != tc.expected {
+				t.Errorf("unexpected diff: %s", diff.StringDiff(tc.expected, actual))
+			}
+		})
+	}
+}
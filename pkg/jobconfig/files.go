@@ -15,6 +15,7 @@ import (
 	"k8s.io/api/core/v1"
 
 	"k8s.io/apimachinery/pkg/util/sets"
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
 	prowconfig "k8s.io/test-infra/prow/config"
 )
 
@@ -22,6 +23,26 @@ const (
 	ProwJobLabelGenerated = "ci-operator.openshift.io/prowgen-controlled"
 	GeneratedStale        = "stale"
 	Generated             = "true"
+
+	// ProwJobAnnotationAlwaysRunPolicy marks generated presubmits whose
+	// `always_run` and `run_if_changed` are set by a repository policy; the
+	// generated values then replace the ones in existing job files
+	ProwJobAnnotationAlwaysRunPolicy = "ci-operator.openshift.io/prowgen-always-run-policy"
+
+	// ProwJobAnnotationPromotionNamespace and ProwJobAnnotationPromotionName
+	// record where the images promoted by a generated postsubmit are published
+	ProwJobAnnotationPromotionNamespace = "ci-operator.openshift.io/promotion-namespace"
+	ProwJobAnnotationPromotionName      = "ci-operator.openshift.io/promotion-name"
+)
+
+// FileGrouping determines how the jobs of a repository are sharded into files
+type FileGrouping string
+
+const (
+	// GroupByBranch puts jobs into ORG-REPO-BRANCH-TYPE.yaml files
+	GroupByBranch FileGrouping = "branch"
+	// GroupByRepo puts jobs into ORG-REPO-TYPE.yaml files
+	GroupByRepo FileGrouping = "repo"
 )
 
 // DataWithInfo describes the metadata for a Prow job configuration file
@@ -38,7 +59,7 @@ type Info struct {
 // Basename returns the unique name for this file in the config
 func (i *Info) Basename() string {
 	parts := []string{i.Org, i.Repo, i.Branch, i.Type}
-	if i.Type == "periodics" && i.Branch == "" {
+	if i.Branch == "" {
 		parts = []string{i.Org, i.Repo, i.Type}
 	}
 	return fmt.Sprintf("%s.yaml", strings.Join(parts, "-"))
@@ -85,11 +106,12 @@ func extractInfoFromPath(configFilePath string) (*Info, error) {
 	typeIndex := strings.LastIndex(branchType, "-")
 	var branch, jobType string
 	if typeIndex == -1 {
-		if branchType != "periodics" {
+		// periodics and jobs grouped by repo are not sharded by branch
+		if branchType != "periodics" && branchType != "presubmits" && branchType != "postsubmits" {
 			return nil, fmt.Errorf("file name does not contain job type: %q", basenameWithoutSuffix)
 		}
 		branch = ""
-		jobType = "periodics"
+		jobType = branchType
 	} else {
 		branch = branchType[:typeIndex]
 		jobType = branchType[typeIndex+1:]
@@ -112,7 +134,7 @@ func OperateOnJobConfigDir(configDir string, callback func(*prowconfig.JobConfig
 			return nil
 		}
 
-		if !info.IsDir() && filepath.Ext(path) == ".yaml" {
+		if !info.IsDir() && filepath.Ext(path) == ".yaml" && info.Name() != KustomizationFile {
 			var configPart *prowconfig.JobConfig
 			if configPart, err = readFromFile(path); err != nil {
 				logger.WithError(err).Error("Failed to read Prow job config")
@@ -176,6 +198,7 @@ func mergeConfigs(dest, part *prowconfig.JobConfig) {
 			}
 		}
 	}
+	dest.Periodics = append(dest.Periodics, part.Periodics...)
 }
 
 // readFromFile reads Prow job config from a YAML file
@@ -196,23 +219,50 @@ func readFromFile(path string) (*prowconfig.JobConfig, error) {
 	return jobConfig, nil
 }
 
+// PeriodicRefs returns the refs of ORG/REPO among the extra refs of a
+// periodic, which generated periodics use to test a branch of the repository,
+// or nil if the periodic does not test the repository
+func PeriodicRefs(job *prowconfig.Periodic, org, repo string) *pjapi.Refs {
+	for i := range job.ExtraRefs {
+		if job.ExtraRefs[i].Org == org && job.ExtraRefs[i].Repo == repo {
+			return &job.ExtraRefs[i]
+		}
+	}
+	return nil
+}
+
 // Given a JobConfig and a target directory, write the Prow job configuration
-// into files in that directory. Jobs are sharded by branch and by type. If
-// target files already exist and contain Prow job configuration, the jobs will
-// be merged.
+// into files in that directory. Jobs are sharded by branch and by type;
+// periodics are written when they test ORG/REPO and sharded by the branch
+// they test. If target files already exist and contain Prow job configuration,
+// the jobs will be merged.
 func WriteToDir(jobDir, org, repo string, jobConfig *prowconfig.JobConfig) error {
+	return WriteToDirGrouped(jobDir, org, repo, jobConfig, GroupByBranch, 0)
+}
+
+// WriteToDirGrouped behaves like WriteToDir, but shards the jobs by type and
+// the given grouping. Files written with different groupings are not merged,
+// so existing files need to be removed when the grouping changes. The written
+// files get the given mode as described in WriteFile.
+func WriteToDirGrouped(jobDir, org, repo string, jobConfig *prowconfig.JobConfig, grouping FileGrouping, mode os.FileMode) error {
+	fileFor := func(branches []string, jobType string) string {
+		info := Info{Org: org, Repo: repo, Type: jobType}
+		if grouping != GroupByRepo {
+			info.Branch = "master"
+			if len(branches) > 0 {
+				// branches may be regexps, strip regexp characters and trailing dashes / slashes
+				info.Branch = MakeRegexFilenameLabel(branches[0])
+			}
+		}
+		return info.Basename()
+	}
+
 	allJobs := sets.String{}
 	files := map[string]*prowconfig.JobConfig{}
 	key := fmt.Sprintf("%s/%s", org, repo)
 	for _, job := range jobConfig.Presubmits[key] {
 		allJobs.Insert(job.Name)
-		branch := "master"
-		if len(job.Branches) > 0 {
-			branch = job.Branches[0]
-			// branches may be regexps, strip regexp characters and trailing dashes / slashes
-			branch = MakeRegexFilenameLabel(branch)
-		}
-		file := fmt.Sprintf("%s-%s-%s-presubmits.yaml", org, repo, branch)
+		file := fileFor(job.Branches, "presubmits")
 		if _, ok := files[file]; ok {
 			files[file].Presubmits[key] = append(files[file].Presubmits[key], job)
 		} else {
@@ -223,13 +273,7 @@ func WriteToDir(jobDir, org, repo string, jobConfig *prowconfig.JobConfig) error
 	}
 	for _, job := range jobConfig.Postsubmits[key] {
 		allJobs.Insert(job.Name)
-		branch := "master"
-		if len(job.Branches) > 0 {
-			branch = job.Branches[0]
-			// branches may be regexps, strip regexp characters and trailing dashes / slashes
-			branch = MakeRegexFilenameLabel(branch)
-		}
-		file := fmt.Sprintf("%s-%s-%s-postsubmits.yaml", org, repo, branch)
+		file := fileFor(job.Branches, "postsubmits")
 		if _, ok := files[file]; ok {
 			files[file].Postsubmits[key] = append(files[file].Postsubmits[key], job)
 		} else {
@@ -239,12 +283,26 @@ func WriteToDir(jobDir, org, repo string, jobConfig *prowconfig.JobConfig) error
 		}
 	}
 
+	for _, job := range jobConfig.Periodics {
+		refs := PeriodicRefs(&job, org, repo)
+		if refs == nil {
+			continue
+		}
+		allJobs.Insert(job.Name)
+		file := fileFor([]string{refs.BaseRef}, "periodics")
+		if _, ok := files[file]; ok {
+			files[file].Periodics = append(files[file].Periodics, job)
+		} else {
+			files[file] = &prowconfig.JobConfig{Periodics: []prowconfig.Periodic{job}}
+		}
+	}
+
 	jobDirForComponent := filepath.Join(jobDir, org, repo)
 	if err := os.MkdirAll(jobDirForComponent, os.ModePerm); err != nil {
 		return err
 	}
 	for file := range files {
-		if err := mergeJobsIntoFile(filepath.Join(jobDirForComponent, file), files[file], allJobs); err != nil {
+		if err := mergeJobsIntoFile(filepath.Join(jobDirForComponent, file), files[file], allJobs, mode); err != nil {
 			return err
 		}
 	}
@@ -267,6 +325,11 @@ func labelGeneratedJobs(jobConfig *prowconfig.JobConfig, label string) {
 			}
 		}
 	}
+	for _, job := range jobConfig.Periodics {
+		if _, isGenerated := job.Labels[ProwJobLabelGenerated]; isGenerated {
+			job.Labels[ProwJobLabelGenerated] = label
+		}
+	}
 }
 
 func pruneStaleGeneratedJobs(jobConfig *prowconfig.JobConfig, staleLabel string) {
@@ -290,6 +353,16 @@ func pruneStaleGeneratedJobs(jobConfig *prowconfig.JobConfig, staleLabel string)
 		}
 		jobConfig.Postsubmits[repo] = jobs[:i]
 	}
+	if jobConfig.Periodics != nil {
+		i := 0
+		for _, job := range jobConfig.Periodics {
+			if label, isGenerated := job.Labels[ProwJobLabelGenerated]; !isGenerated || label != staleLabel {
+				jobConfig.Periodics[i] = job
+				i++
+			}
+		}
+		jobConfig.Periodics = jobConfig.Periodics[:i]
+	}
 }
 
 // Given a JobConfig and a file path, write YAML representation of the config
@@ -304,7 +377,7 @@ func pruneStaleGeneratedJobs(jobConfig *prowconfig.JobConfig, staleLabel string)
 //
 // Note that jobs generated by Prowgen present in destination, but not in the
 // source will not be included in the destination.
-func mergeJobsIntoFile(prowConfigPath string, jobConfig *prowconfig.JobConfig, allJobs sets.String) error {
+func mergeJobsIntoFile(prowConfigPath string, jobConfig *prowconfig.JobConfig, allJobs sets.String, mode os.FileMode) error {
 	existingJobConfig, err := readFromFile(prowConfigPath)
 	if err != nil {
 		existingJobConfig = &prowconfig.JobConfig{}
@@ -317,7 +390,7 @@ func mergeJobsIntoFile(prowConfigPath string, jobConfig *prowconfig.JobConfig, a
 
 	sortConfigFields(existingJobConfig)
 
-	return writeToFile(prowConfigPath, existingJobConfig)
+	return writeToFile(prowConfigPath, existingJobConfig, mode)
 }
 
 // Given two JobConfig, merge jobs from the `source` one to to `destination`
@@ -389,6 +462,22 @@ func mergeJobConfig(destination, source *prowconfig.JobConfig, allJobs sets.Stri
 			destination.Postsubmits[repo] = mergedJobs
 		}
 	}
+	if source.Periodics != nil {
+		newJobs := map[string]prowconfig.Periodic{}
+		for _, job := range source.Periodics {
+			newJobs[job.Name] = job
+		}
+		var mergedJobs []prowconfig.Periodic
+		for _, oldJob := range destination.Periodics {
+			if _, updated := newJobs[oldJob.Name]; !updated && !allJobs.Has(oldJob.Name) {
+				mergedJobs = append(mergedJobs, oldJob)
+			}
+		}
+		for _, newJob := range newJobs {
+			mergedJobs = append(mergedJobs, newJob)
+		}
+		destination.Periodics = mergedJobs
+	}
 }
 
 // mergePresubmits merges the two configurations, preferring fields
@@ -397,8 +486,10 @@ func mergeJobConfig(destination, source *prowconfig.JobConfig, allJobs sets.Stri
 func mergePresubmits(old, new *prowconfig.Presubmit) prowconfig.Presubmit {
 	merged := *new
 
-	merged.AlwaysRun = old.AlwaysRun
-	merged.RunIfChanged = old.RunIfChanged
+	if _, isPolicy := new.Annotations[ProwJobAnnotationAlwaysRunPolicy]; !isPolicy {
+		merged.AlwaysRun = old.AlwaysRun
+		merged.RunIfChanged = old.RunIfChanged
+	}
 	merged.Optional = old.Optional
 	merged.MaxConcurrency = old.MaxConcurrency
 	merged.SkipReport = old.SkipReport
@@ -440,6 +531,14 @@ func sortConfigFields(jobConfig *prowconfig.JobConfig) {
 			}
 		}
 	}
+	sort.Slice(jobConfig.Periodics, func(i, j int) bool {
+		return jobConfig.Periodics[i].Name < jobConfig.Periodics[j].Name
+	})
+	for job := range jobConfig.Periodics {
+		if jobConfig.Periodics[job].Spec != nil {
+			sortPodSpec(jobConfig.Periodics[job].Spec)
+		}
+	}
 }
 
 func sortPodSpec(spec *v1.PodSpec) {
@@ -473,18 +572,31 @@ func sortPodSpec(spec *v1.PodSpec) {
 }
 
 // writeToFile writes Prow job config to a YAML file
-func writeToFile(path string, jobConfig *prowconfig.JobConfig) error {
+func writeToFile(path string, jobConfig *prowconfig.JobConfig, mode os.FileMode) error {
 	jobConfigAsYaml, err := yaml.Marshal(*jobConfig)
 	if err != nil {
 		return fmt.Errorf("failed to marshal the job config (%v)", err)
 	}
-	if err := ioutil.WriteFile(path, jobConfigAsYaml, 0664); err != nil {
+	if err := WriteFile(path, jobConfigAsYaml, mode); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// WriteFile writes data to a file. When mode is zero, new files are created
+// with 0664 restricted by the umask and existing files keep their mode.
+// Otherwise, the file gets exactly the given mode regardless of both.
+func WriteFile(path string, data []byte, mode os.FileMode) error {
+	if mode == 0 {
+		return ioutil.WriteFile(path, data, 0664)
+	}
+	if err := ioutil.WriteFile(path, data, mode); err != nil {
+		return err
+	}
+	return os.Chmod(path, mode)
+}
+
 var regexParts = regexp.MustCompile(`[^\w\-\.]+`)
 
 func MakeRegexFilenameLabel(possibleRegex string) string {
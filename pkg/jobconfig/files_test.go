@@ -1,12 +1,17 @@
 package jobconfig
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"testing"
 
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/util/diff"
 	"k8s.io/apimachinery/pkg/util/sets"
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
 	prowconfig "k8s.io/test-infra/prow/config"
 )
 
@@ -400,6 +405,50 @@ func TestMergePresubmits(t *testing.T) {
 				RerunCommand:        "something",
 			},
 		},
+		{
+			name: "always_run set by a policy replaces the old value",
+			old: &prowconfig.Presubmit{
+				JobBase:   prowconfig.JobBase{Name: "pull-ci-super-duper"},
+				AlwaysRun: true,
+				Optional:  true,
+			},
+			new: &prowconfig.Presubmit{
+				JobBase: prowconfig.JobBase{
+					Name:        "pull-ci-super-duper",
+					Annotations: map[string]string{ProwJobAnnotationAlwaysRunPolicy: "true"},
+				},
+				AlwaysRun: false,
+			},
+			expected: prowconfig.Presubmit{
+				JobBase: prowconfig.JobBase{
+					Name:        "pull-ci-super-duper",
+					Annotations: map[string]string{ProwJobAnnotationAlwaysRunPolicy: "true"},
+				},
+				AlwaysRun: false,
+				Optional:  true,
+			},
+		},
+		{
+			name: "run_if_changed set by a policy replaces the old value",
+			old: &prowconfig.Presubmit{
+				JobBase:             prowconfig.JobBase{Name: "pull-ci-super-duper"},
+				RegexpChangeMatcher: prowconfig.RegexpChangeMatcher{RunIfChanged: "foo"},
+			},
+			new: &prowconfig.Presubmit{
+				JobBase: prowconfig.JobBase{
+					Name:        "pull-ci-super-duper",
+					Annotations: map[string]string{ProwJobAnnotationAlwaysRunPolicy: "true"},
+				},
+				RegexpChangeMatcher: prowconfig.RegexpChangeMatcher{RunIfChanged: "^pkg/"},
+			},
+			expected: prowconfig.Presubmit{
+				JobBase: prowconfig.JobBase{
+					Name:        "pull-ci-super-duper",
+					Annotations: map[string]string{ProwJobAnnotationAlwaysRunPolicy: "true"},
+				},
+				RegexpChangeMatcher: prowconfig.RegexpChangeMatcher{RunIfChanged: "^pkg/"},
+			},
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
@@ -554,6 +603,24 @@ func TestExtractRepoElementsFromPath(t *testing.T) {
 			expected:      nil,
 			expectedError: true,
 		},
+		{
+			name: "path for jobs grouped by repo parses fine",
+			path: "./org/repo/org-repo-postsubmits.yaml",
+			expected: &Info{
+				Org:      "org",
+				Repo:     "repo",
+				Branch:   "",
+				Type:     "postsubmits",
+				Filename: "./org/repo/org-repo-postsubmits.yaml",
+			},
+			expectedError: false,
+		},
+		{
+			name:          "path without job type fails to parse",
+			path:          "./org/repo/org-repo-branch.yaml",
+			expected:      nil,
+			expectedError: true,
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.name, func(t *testing.T) {
@@ -597,6 +664,16 @@ func TestInfo_Basename(t *testing.T) {
 			},
 			expected: "org-repo-periodics.yaml",
 		},
+		{
+			name: "path for presubmits without branch creates complex basename",
+			info: &Info{
+				Org:    "org",
+				Repo:   "repo",
+				Branch: "",
+				Type:   "presubmits",
+			},
+			expected: "org-repo-presubmits.yaml",
+		},
 	}
 	for _, testCase := range testCases {
 		t.Run(testCase.expected, func(t *testing.T) {
@@ -833,6 +910,16 @@ func TestPruneStaleGeneratedJobs(t *testing.T) {
 					Labels: map[string]string{ProwJobLabelGenerated: "NOT STALE"},
 				}},
 			}},
+			Periodics: []prowconfig.Periodic{
+				{JobBase: prowconfig.JobBase{
+					Name:   "org-repo-stale-periodic",
+					Labels: map[string]string{ProwJobLabelGenerated: staleLabel},
+				}},
+				{JobBase: prowconfig.JobBase{
+					Name:   "org-repo-periodic",
+					Labels: map[string]string{ProwJobLabelGenerated: "NOT STALE"},
+				}},
+			},
 		},
 		expected: &prowconfig.JobConfig{
 			Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {
@@ -847,6 +934,12 @@ func TestPruneStaleGeneratedJobs(t *testing.T) {
 					Labels: map[string]string{ProwJobLabelGenerated: "NOT STALE"},
 				}},
 			}},
+			Periodics: []prowconfig.Periodic{
+				{JobBase: prowconfig.JobBase{
+					Name:   "org-repo-periodic",
+					Labels: map[string]string{ProwJobLabelGenerated: "NOT STALE"},
+				}},
+			},
 		},
 	},
 	}
@@ -859,3 +952,143 @@ func TestPruneStaleGeneratedJobs(t *testing.T) {
 		})
 	}
 }
+
+func TestWriteToDirGrouped(t *testing.T) {
+	jobConfig := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {
+			{JobBase: prowconfig.JobBase{Name: "pull-master"}, Brancher: prowconfig.Brancher{Branches: []string{"^master$"}}},
+			{JobBase: prowconfig.JobBase{Name: "pull-release"}, Brancher: prowconfig.Brancher{Branches: []string{"^release-4.1$"}}},
+		}},
+		Postsubmits: map[string][]prowconfig.Postsubmit{"org/repo": {
+			{JobBase: prowconfig.JobBase{Name: "branch-master"}, Brancher: prowconfig.Brancher{Branches: []string{"^master$"}}},
+		}},
+		Periodics: []prowconfig.Periodic{{JobBase: prowconfig.JobBase{
+			Name:          "periodic-master",
+			UtilityConfig: prowconfig.UtilityConfig{ExtraRefs: []pjapi.Refs{{Org: "org", Repo: "repo", BaseRef: "master"}}},
+		}, Cron: "0 4 * * *"}},
+	}
+	testCases := []struct {
+		grouping      FileGrouping
+		expectedFiles []string
+	}{
+		{
+			grouping:      GroupByBranch,
+			expectedFiles: []string{"org-repo-master-periodics.yaml", "org-repo-master-postsubmits.yaml", "org-repo-master-presubmits.yaml", "org-repo-release-4.1-presubmits.yaml"},
+		},
+		{
+			grouping:      GroupByRepo,
+			expectedFiles: []string{"org-repo-periodics.yaml", "org-repo-postsubmits.yaml", "org-repo-presubmits.yaml"},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(string(testCase.grouping), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "jobconfig")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			if err := WriteToDirGrouped(dir, "org", "repo", jobConfig, testCase.grouping, 0); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			infos, err := ioutil.ReadDir(filepath.Join(dir, "org", "repo"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var files []string
+			for _, info := range infos {
+				files = append(files, info.Name())
+			}
+			if !reflect.DeepEqual(testCase.expectedFiles, files) {
+				t.Errorf("unexpected files written: %s", diff.ObjectReflectDiff(testCase.expectedFiles, files))
+			}
+
+			var read []*Info
+			if err := OperateOnJobConfigDir(dir, func(_ *prowconfig.JobConfig, info *Info) error {
+				read = append(read, info)
+				return nil
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(read) != len(testCase.expectedFiles) {
+				t.Errorf("expected all %d written files to be read back, read %d", len(testCase.expectedFiles), len(read))
+			}
+
+			readConfig, err := ReadFromDir(dir)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			sort.Slice(readConfig.Presubmits["org/repo"], func(i, j int) bool {
+				return readConfig.Presubmits["org/repo"][i].Name < readConfig.Presubmits["org/repo"][j].Name
+			})
+			// periodics have unexported fields which the semantic comparison cannot handle
+			if !reflect.DeepEqual(jobConfig.Periodics, readConfig.Periodics) {
+				t.Errorf("periodics did not round-trip: %s", diff.ObjectReflectDiff(jobConfig.Periodics, readConfig.Periodics))
+			}
+			expected, actual := *jobConfig, *readConfig
+			expected.Periodics, actual.Periodics = nil, nil
+			if !equality.Semantic.DeepEqual(expected, actual) {
+				t.Errorf("jobs did not round-trip: %s", diff.ObjectReflectDiff(expected, actual))
+			}
+		})
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	testCases := []struct {
+		name         string
+		existingMode os.FileMode
+		mode         os.FileMode
+		expectedMode os.FileMode
+	}{
+		{
+			name:         "existing file keeps its mode by default",
+			existingMode: 0600,
+			expectedMode: 0600,
+		},
+		{
+			name:         "existing file gets the requested mode",
+			existingMode: 0600,
+			mode:         0644,
+			expectedMode: 0644,
+		},
+		{
+			name:         "new file gets the requested mode regardless of umask",
+			mode:         0666,
+			expectedMode: 0666,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "jobconfig")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "file.yaml")
+			if testCase.existingMode != 0 {
+				if err := ioutil.WriteFile(path, []byte("old"), testCase.existingMode); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.Chmod(path, testCase.existingMode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err := WriteFile(path, []byte("new"), testCase.mode); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != testCase.expectedMode {
+				t.Errorf("expected mode %o, got %o", testCase.expectedMode, mode)
+			}
+			if data, err := ioutil.ReadFile(path); err != nil || string(data) != "new" {
+				t.Errorf("expected the new content to be written, got %q (%v)", data, err)
+			}
+		})
+	}
+}
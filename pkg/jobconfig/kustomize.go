@@ -0,0 +1,120 @@
+package jobconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+// KustomizationFile is the name of the Kustomize index file in a directory
+const KustomizationFile = "kustomization.yaml"
+
+// jobConfigMapPrefix prefixes the names of the ConfigMaps generated from the
+// job files in a directory
+const jobConfigMapPrefix = "job-config"
+
+// kustomization is the part of a Kustomize index that lists the indices of
+// subdirectories as resources and generates ConfigMaps from the job files,
+// which are not Kubernetes objects themselves
+type kustomization struct {
+	APIVersion         string                  `json:"apiVersion"`
+	Kind               string                  `json:"kind"`
+	Resources          []string                `json:"resources,omitempty"`
+	ConfigMapGenerator []configMapGeneratorArg `json:"configMapGenerator,omitempty"`
+	GeneratorOptions   *generatorOptions       `json:"generatorOptions,omitempty"`
+}
+
+// configMapGeneratorArg generates a ConfigMap with the listed files as keys
+type configMapGeneratorArg struct {
+	Name  string   `json:"name"`
+	Files []string `json:"files"`
+}
+
+// generatorOptions modify all ConfigMaps generated by an index
+type generatorOptions struct {
+	DisableNameSuffixHash bool `json:"disableNameSuffixHash"`
+}
+
+// invalidConfigMapNameCharacters are the characters which cannot be used in
+// ConfigMap names
+var invalidConfigMapNameCharacters = regexp.MustCompile(`[^a-z0-9.-]+`)
+
+// jobConfigMapName returns the name of the ConfigMap generated from the job
+// files in the directory at the given path relative to the job directory
+func jobConfigMapName(relPath string) string {
+	if relPath == "." {
+		return jobConfigMapPrefix
+	}
+	name := strings.Join([]string{jobConfigMapPrefix, filepath.ToSlash(relPath)}, "-")
+	return invalidConfigMapNameCharacters.ReplaceAllString(strings.ToLower(name), "-")
+}
+
+// WriteKustomizations maintains a Kustomize index in every directory under
+// jobDir. The index lists the subdirectories which have an index themselves
+// as resources and generates a ConfigMap named after the directory from the
+// job files in it, keyed by their names. Prow mounts job config ConfigMaps
+// by name, so their names are not suffixed with a hash. Indices of
+// directories with nothing to list are removed, so the indices follow job
+// files being added or removed. The indices get the given mode as described
+// in WriteFile.
+func WriteKustomizations(jobDir string, mode os.FileMode) error {
+	_, err := writeKustomization(jobDir, ".", mode)
+	return err
+}
+
+// writeKustomization maintains the index in the directory at the path
+// relative to the job directory and returns whether the directory has one
+func writeKustomization(jobDir, relPath string, mode os.FileMode) (bool, error) {
+	dir := filepath.Join(jobDir, relPath)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, fmt.Errorf("failed to list directory %s (%v)", dir, err)
+	}
+	var resources, files []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			indexed, err := writeKustomization(jobDir, filepath.Join(relPath, entry.Name()), mode)
+			if err != nil {
+				return false, err
+			}
+			if indexed {
+				resources = append(resources, entry.Name())
+			}
+		} else if filepath.Ext(entry.Name()) == ".yaml" && entry.Name() != KustomizationFile {
+			files = append(files, entry.Name())
+		}
+	}
+
+	path := filepath.Join(dir, KustomizationFile)
+	if len(resources) == 0 && len(files) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to remove %s (%v)", path, err)
+		}
+		return false, nil
+	}
+	index := kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+	}
+	if len(files) > 0 {
+		sort.Strings(files)
+		index.ConfigMapGenerator = []configMapGeneratorArg{{Name: jobConfigMapName(relPath), Files: files}}
+		index.GeneratorOptions = &generatorOptions{DisableNameSuffixHash: true}
+	}
+	sort.Strings(index.Resources)
+	data, err := yaml.Marshal(index)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal %s (%v)", path, err)
+	}
+	if err := WriteFile(path, data, mode); err != nil {
+		return false, fmt.Errorf("failed to write %s (%v)", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,107 @@
+package jobconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func TestWriteKustomizations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kustomize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := []string{
+		"org/repo/org-repo-master-presubmits.yaml",
+		"org/repo/org-repo-master-postsubmits.yaml",
+		"org/other/org-other-master-presubmits.yaml",
+		"org/other/OWNERS",
+		"org/empty/OWNERS",
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte{}, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := WriteKustomizations(dir, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"":    "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n- org\n",
+		"org": "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n- other\n- repo\n",
+		"org/repo": `apiVersion: kustomize.config.k8s.io/v1beta1
+configMapGenerator:
+- files:
+  - org-repo-master-postsubmits.yaml
+  - org-repo-master-presubmits.yaml
+  name: job-config-org-repo
+generatorOptions:
+  disableNameSuffixHash: true
+kind: Kustomization
+`,
+		"org/other": `apiVersion: kustomize.config.k8s.io/v1beta1
+configMapGenerator:
+- files:
+  - org-other-master-presubmits.yaml
+  name: job-config-org-other
+generatorOptions:
+  disableNameSuffixHash: true
+kind: Kustomization
+`,
+	}
+	for subdir, content := range expected {
+		data, err := ioutil.ReadFile(filepath.Join(dir, subdir, KustomizationFile))
+		if err != nil {
+			t.Errorf("%s: failed to read kustomization: %v", subdir, err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: unexpected kustomization: %s", subdir, diff.StringDiff(content, string(data)))
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "org/empty", KustomizationFile)); !os.IsNotExist(err) {
+		t.Errorf("expected no kustomization for a directory with no job files, got: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(dir, "org/other/org-other-master-presubmits.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteKustomizations(dir, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "org/other", KustomizationFile)); !os.IsNotExist(err) {
+		t.Errorf("expected the kustomization to be removed with the last job file, got: %v", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "org", KustomizationFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "apiVersion: kustomize.config.k8s.io/v1beta1\nkind: Kustomization\nresources:\n- repo\n"; string(data) != expected {
+		t.Errorf("unexpected kustomization after removal: %s", diff.StringDiff(expected, string(data)))
+	}
+}
+
+func TestJobConfigMapName(t *testing.T) {
+	testCases := []struct {
+		relPath  string
+		expected string
+	}{
+		{relPath: ".", expected: "job-config"},
+		{relPath: "org/repo", expected: "job-config-org-repo"},
+		{relPath: "Org/repo_name", expected: "job-config-org-repo-name"},
+	}
+	for _, tc := range testCases {
+		if name := jobConfigMapName(tc.relPath); name != tc.expected {
+			t.Errorf("%s: expected %q, got %q", tc.relPath, tc.expected, name)
+		}
+	}
+}
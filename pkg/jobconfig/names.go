@@ -0,0 +1,103 @@
+package jobconfig
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// MaxJobNameLength is the longest job name Prow can use as a label value
+// on the resources it creates for the job
+const MaxJobNameLength = 63
+
+// WarnLongJobName warns when the name of a job generated for a test is longer
+// than MaxJobNameLength, but the prefix before the test name left enough room
+// to choose a shorter one
+func WarnLongJobName(jobName, test string) {
+	if len(jobName) > MaxJobNameLength && len(jobName)-len(test) < MaxJobNameLength-10 {
+		logrus.WithField("name", jobName).Warnf("Generated job name is longer than %d characters. This may cause issues when Prow attempts to label resources with job name. Consider a shorter name.", MaxJobNameLength)
+	}
+}
+
+const (
+	presubmitPrefix  = "pull"
+	postsubmitPrefix = "branch"
+	periodicPrefix   = "periodic"
+)
+
+// jobNamePrefix returns the prefix of names of generated jobs of a type for
+// a branch of ORG/REPO, which the test name follows
+func jobNamePrefix(jobType, org, repo, branch string) string {
+	return fmt.Sprintf("%s-ci-%s-%s-%s-", jobType, org, repo, branch)
+}
+
+// PresubmitName returns the name of the presubmit generated for a test of a
+// branch of ORG/REPO
+func PresubmitName(org, repo, branch, test string) string {
+	return jobNamePrefix(presubmitPrefix, org, repo, branch) + test
+}
+
+// PostsubmitName returns the name of the postsubmit generated for a test of
+// a branch of ORG/REPO. Characters of the branch which are not allowed in job
+// names, like those in regular expressions, are left out.
+func PostsubmitName(org, repo, branch, test string) string {
+	return jobNamePrefix(postsubmitPrefix, org, repo, MakeRegexFilenameLabel(branch)) + test
+}
+
+// PeriodicName returns the name of the periodic generated for a test of a
+// branch of ORG/REPO
+func PeriodicName(org, repo, branch, test string) string {
+	return jobNamePrefix(periodicPrefix, org, repo, MakeRegexFilenameLabel(branch)) + test
+}
+
+// PresubmitTestName returns the name of the test from the name of a presubmit
+// generated for a branch of ORG/REPO, or false if the name does not belong to
+// such presubmit
+func PresubmitTestName(presubmit, org, repo, branch string) (string, bool) {
+	prefix := jobNamePrefix(presubmitPrefix, org, repo, branch)
+	if !strings.HasPrefix(presubmit, prefix) || len(presubmit) == len(prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(presubmit, prefix), true
+}
+
+// PairedPostsubmitName returns the name of the postsubmit generated for the
+// same test as the presubmit with the given name, which was generated for a
+// branch of ORG/REPO, or false if the name does not belong to such presubmit.
+// This pairs the `images` presubmit with the postsubmit promoting the images.
+func PairedPostsubmitName(presubmit, org, repo, branch string) (string, bool) {
+	test, ok := PresubmitTestName(presubmit, org, repo, branch)
+	if !ok {
+		return "", false
+	}
+	return PostsubmitName(org, repo, branch, test), true
+}
+
+// LongJobNames returns sorted names of all jobs in the config which are
+// longer than MaxJobNameLength
+func LongJobNames(jobConfig *prowconfig.JobConfig) []string {
+	var names []string
+	check := func(name string) {
+		if len(name) > MaxJobNameLength {
+			names = append(names, name)
+		}
+	}
+	for _, jobs := range jobConfig.Presubmits {
+		for _, job := range jobs {
+			check(job.Name)
+		}
+	}
+	for _, jobs := range jobConfig.Postsubmits {
+		for _, job := range jobs {
+			check(job.Name)
+		}
+	}
+	for _, job := range jobConfig.Periodics {
+		check(job.Name)
+	}
+	sort.Strings(names)
+	return names
+}
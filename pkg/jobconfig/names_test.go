@@ -0,0 +1,85 @@
+package jobconfig
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestLongJobNames(t *testing.T) {
+	long := func(prefix string) string {
+		return prefix + strings.Repeat("x", MaxJobNameLength-len(prefix)+1)
+	}
+	fits := strings.Repeat("x", MaxJobNameLength)
+	jobConfig := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{
+			"org/repo": {{JobBase: prowconfig.JobBase{Name: fits}}, {JobBase: prowconfig.JobBase{Name: long("pull-")}}},
+		},
+		Postsubmits: map[string][]prowconfig.Postsubmit{
+			"org/repo": {{JobBase: prowconfig.JobBase{Name: long("branch-")}}},
+		},
+		Periodics: []prowconfig.Periodic{{JobBase: prowconfig.JobBase{Name: long("periodic-")}}, {JobBase: prowconfig.JobBase{Name: "short"}}},
+	}
+	expected := []string{long("branch-"), long("periodic-"), long("pull-")}
+	if names := LongJobNames(jobConfig); !reflect.DeepEqual(expected, names) {
+		t.Errorf("unexpected long names: %s", diff.ObjectReflectDiff(expected, names))
+	}
+}
+
+func TestPairedPostsubmitName(t *testing.T) {
+	testCases := []struct {
+		description string
+		presubmit   string
+		branch      string
+		expected    string
+		expectedOK  bool
+	}{
+		{
+			description: "presubmit is paired with the postsubmit for the same test",
+			presubmit:   "pull-ci-org-repo-master-images",
+			branch:      "master",
+			expected:    "branch-ci-org-repo-master-images",
+			expectedOK:  true,
+		},
+		{
+			description: "test names with dashes are kept whole",
+			presubmit:   "pull-ci-org-repo-release-4.1-e2e-aws",
+			branch:      "release-4.1",
+			expected:    "branch-ci-org-repo-release-4.1-e2e-aws",
+			expectedOK:  true,
+		},
+		{
+			description: "regex characters of the branch are left out of the postsubmit name",
+			presubmit:   "pull-ci-org-repo-^feature-.*$-images",
+			branch:      "^feature-.*$",
+			expected:    "branch-ci-org-repo-feature-images",
+			expectedOK:  true,
+		},
+		{
+			description: "presubmit for another branch is not paired",
+			presubmit:   "pull-ci-org-repo-master-images",
+			branch:      "release-4.1",
+		},
+		{
+			description: "job not generated for the repo is not paired",
+			presubmit:   "pull-ci-org-other-master-images",
+			branch:      "master",
+		},
+		{
+			description: "name without a test is not paired",
+			presubmit:   "pull-ci-org-repo-master-",
+			branch:      "master",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			name, ok := PairedPostsubmitName(tc.presubmit, "org", "repo", tc.branch)
+			if name != tc.expected || ok != tc.expectedOK {
+				t.Errorf("expected (%q, %t), got (%q, %t)", tc.expected, tc.expectedOK, name, ok)
+			}
+		})
+	}
+}
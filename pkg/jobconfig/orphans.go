@@ -0,0 +1,80 @@
+package jobconfig
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// OrphanedJobs lists the generated jobs in a job file which are no longer
+// generated from any ci-operator configuration file
+type OrphanedJobs struct {
+	// Filename is the path to the job file
+	Filename string
+	// Jobs are the sorted names of the orphaned jobs
+	Jobs []string
+	// WholeFile is true when the file holds no other jobs and can be removed
+	WholeFile bool
+}
+
+// JobNames returns the names of all jobs in the config
+func JobNames(jobConfig *prowconfig.JobConfig) sets.String {
+	names := sets.NewString()
+	for _, jobs := range jobConfig.Presubmits {
+		for _, job := range jobs {
+			names.Insert(job.Name)
+		}
+	}
+	for _, jobs := range jobConfig.Postsubmits {
+		for _, job := range jobs {
+			names.Insert(job.Name)
+		}
+	}
+	for _, job := range jobConfig.Periodics {
+		names.Insert(job.Name)
+	}
+	return names
+}
+
+// FindOrphans walks the job files in the directory and returns, sorted by
+// file name, the jobs generated by prowgen whose names are not in `generated`.
+// Jobs without the generated label were written by hand and are never
+// considered orphaned.
+func FindOrphans(jobDir string, generated sets.String) ([]OrphanedJobs, error) {
+	var orphans []OrphanedJobs
+	if err := OperateOnJobConfigDir(jobDir, func(jobConfig *prowconfig.JobConfig, info *Info) error {
+		var names []string
+		jobs := 0
+		check := func(base prowconfig.JobBase) {
+			jobs++
+			if _, isGenerated := base.Labels[ProwJobLabelGenerated]; isGenerated && !generated.Has(base.Name) {
+				names = append(names, base.Name)
+			}
+		}
+		for _, presubmits := range jobConfig.Presubmits {
+			for _, job := range presubmits {
+				check(job.JobBase)
+			}
+		}
+		for _, postsubmits := range jobConfig.Postsubmits {
+			for _, job := range postsubmits {
+				check(job.JobBase)
+			}
+		}
+		for _, job := range jobConfig.Periodics {
+			check(job.JobBase)
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			orphans = append(orphans, OrphanedJobs{Filename: info.Filename, Jobs: names, WholeFile: len(names) == jobs})
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	sort.Slice(orphans, func(i, j int) bool {
+		return orphans[i].Filename < orphans[j].Filename
+	})
+	return orphans, nil
+}
@@ -0,0 +1,65 @@
+package jobconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestFindOrphans(t *testing.T) {
+	generatedLabels := map[string]string{ProwJobLabelGenerated: Generated}
+	presubmit := func(name string, labels map[string]string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{JobBase: prowconfig.JobBase{Name: name, Labels: labels}}
+	}
+	files := map[string]*prowconfig.JobConfig{
+		"org/repo/org-repo-master-presubmits.yaml": {Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {
+			presubmit("pull-ci-org-repo-master-unit", generatedLabels),
+			presubmit("pull-ci-org-repo-master-removed", generatedLabels),
+			presubmit("pull-ci-org-repo-master-hand-written", nil),
+		}}},
+		"org/repo/org-repo-release-1.0-presubmits.yaml": {Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {
+			presubmit("pull-ci-org-repo-release-1.0-unit", generatedLabels),
+			presubmit("pull-ci-org-repo-release-1.0-e2e", generatedLabels),
+		}}},
+		"org/repo/org-repo-master-postsubmits.yaml": {Postsubmits: map[string][]prowconfig.Postsubmit{"org/repo": {{
+			JobBase: prowconfig.JobBase{Name: "branch-ci-org-repo-master-images", Labels: generatedLabels},
+		}}}},
+	}
+	dir, err := ioutil.TempDir("", "orphans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for file, jobConfig := range files {
+		path := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeToFile(path, jobConfig, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	generated := sets.NewString("pull-ci-org-repo-master-unit", "branch-ci-org-repo-master-images")
+	orphans, err := FindOrphans(dir, generated)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []OrphanedJobs{{
+		Filename: filepath.Join(dir, "org/repo/org-repo-master-presubmits.yaml"),
+		Jobs:     []string{"pull-ci-org-repo-master-removed"},
+	}, {
+		Filename:  filepath.Join(dir, "org/repo/org-repo-release-1.0-presubmits.yaml"),
+		Jobs:      []string{"pull-ci-org-repo-release-1.0-e2e", "pull-ci-org-repo-release-1.0-unit"},
+		WholeFile: true,
+	}}
+	if !reflect.DeepEqual(expected, orphans) {
+		t.Errorf("unexpected orphans: %s", diff.ObjectReflectDiff(expected, orphans))
+	}
+}
@@ -0,0 +1,89 @@
+package jobconfig
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// ApplyOverrides layers the overrides onto the jobs in the config, matching
+// the jobs by name. Each override is a partial job in its serialized form,
+// applied with JSON merge patch semantics: objects are merged recursively,
+// `null` values remove fields and all other values, including lists, replace
+// the ones in the job. Overrides not matching any job are ignored.
+func ApplyOverrides(jobConfig *prowconfig.JobConfig, overrides map[string]map[string]interface{}) error {
+	if len(overrides) == 0 {
+		return nil
+	}
+	for repo := range jobConfig.Presubmits {
+		for i := range jobConfig.Presubmits[repo] {
+			job := &jobConfig.Presubmits[repo][i]
+			if override, ok := overrides[job.Name]; ok {
+				if err := applyOverride(job, override); err != nil {
+					return fmt.Errorf("failed to override job %s (%v)", job.Name, err)
+				}
+			}
+		}
+	}
+	for repo := range jobConfig.Postsubmits {
+		for i := range jobConfig.Postsubmits[repo] {
+			job := &jobConfig.Postsubmits[repo][i]
+			if override, ok := overrides[job.Name]; ok {
+				if err := applyOverride(job, override); err != nil {
+					return fmt.Errorf("failed to override job %s (%v)", job.Name, err)
+				}
+			}
+		}
+	}
+	for i := range jobConfig.Periodics {
+		job := &jobConfig.Periodics[i]
+		if override, ok := overrides[job.Name]; ok {
+			if err := applyOverride(job, override); err != nil {
+				return fmt.Errorf("failed to override job %s (%v)", job.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// applyOverride merges the override into the serialized job and loads the
+// job back from the result
+func applyOverride(job interface{}, override map[string]interface{}) error {
+	raw, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	var serialized map[string]interface{}
+	if err := json.Unmarshal(raw, &serialized); err != nil {
+		return err
+	}
+	if raw, err = json.Marshal(mergePatch(serialized, override)); err != nil {
+		return err
+	}
+	// reset the job so that fields removed by the override do not survive
+	value := reflect.ValueOf(job).Elem()
+	value.Set(reflect.Zero(value.Type()))
+	return json.Unmarshal(raw, job)
+}
+
+// mergePatch merges the patch into the target following RFC 7386
+func mergePatch(target, patch map[string]interface{}) map[string]interface{} {
+	if target == nil {
+		target = map[string]interface{}{}
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		if patchObject, ok := value.(map[string]interface{}); ok {
+			targetObject, _ := target[key].(map[string]interface{})
+			target[key] = mergePatch(targetObject, patchObject)
+			continue
+		}
+		target[key] = value
+	}
+	return target
+}
@@ -0,0 +1,106 @@
+package jobconfig
+
+import (
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestApplyOverrides(t *testing.T) {
+	makeJobConfig := func() *prowconfig.JobConfig {
+		spec := func() *v1.PodSpec {
+			return &v1.PodSpec{Containers: []v1.Container{{
+				Args: []string{"--target=e2e"},
+				Resources: v1.ResourceRequirements{
+					Requests: v1.ResourceList{"cpu": resource.MustParse("10m")},
+				},
+			}}}
+		}
+		return &prowconfig.JobConfig{
+			Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {{
+				JobBase:   prowconfig.JobBase{Name: "pull-e2e", MaxConcurrency: 2, Spec: spec()},
+				AlwaysRun: true,
+				Reporter:  prowconfig.Reporter{Context: "ci/prow/e2e"},
+			}}},
+			Postsubmits: map[string][]prowconfig.Postsubmit{"org/repo": {{
+				JobBase: prowconfig.JobBase{Name: "branch-images", Spec: spec()},
+			}}},
+		}
+	}
+
+	testCases := []struct {
+		name      string
+		overrides string
+		expected  func() *prowconfig.JobConfig
+	}{
+		{
+			name:      "no overrides leave jobs untouched",
+			overrides: "{}",
+			expected:  makeJobConfig,
+		},
+		{
+			name:      "overrides for unknown jobs are ignored",
+			overrides: "pull-unknown:\n  max_concurrency: 1\n",
+			expected:  makeJobConfig,
+		},
+		{
+			name: "top-level fields are replaced and removed",
+			overrides: `pull-e2e:
+  always_run: false
+  max_concurrency: null
+branch-images:
+  max_concurrency: 1
+`,
+			expected: func() *prowconfig.JobConfig {
+				jobConfig := makeJobConfig()
+				jobConfig.Presubmits["org/repo"][0].AlwaysRun = false
+				jobConfig.Presubmits["org/repo"][0].MaxConcurrency = 0
+				jobConfig.Postsubmits["org/repo"][0].MaxConcurrency = 1
+				return jobConfig
+			},
+		},
+		{
+			name: "nested objects are merged and lists are replaced",
+			overrides: `pull-e2e:
+  spec:
+    containers:
+    - args:
+      - --target=e2e
+      - --extra
+      resources:
+        requests:
+          memory: 1Gi
+`,
+			expected: func() *prowconfig.JobConfig {
+				jobConfig := makeJobConfig()
+				jobConfig.Presubmits["org/repo"][0].Spec.Containers = []v1.Container{{
+					Args: []string{"--target=e2e", "--extra"},
+					Resources: v1.ResourceRequirements{
+						Requests: v1.ResourceList{"memory": resource.MustParse("1Gi")},
+					},
+				}}
+				return jobConfig
+			},
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			var overrides map[string]map[string]interface{}
+			if err := yaml.Unmarshal([]byte(testCase.overrides), &overrides); err != nil {
+				t.Fatal(err)
+			}
+			jobConfig := makeJobConfig()
+			if err := ApplyOverrides(jobConfig, overrides); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if expected := testCase.expected(); !equality.Semantic.DeepEqual(expected, jobConfig) {
+				t.Errorf("unexpected jobs after overrides: %s", diff.ObjectReflectDiff(expected, jobConfig))
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package jobconfig
+
+import (
+	"sort"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	"github.com/openshift/ci-operator-prowgen/pkg/promotion"
+)
+
+// ConfigsWithoutPromotion returns sorted names of the ci-operator config files
+// which promote official images, but which no postsubmit in the job config uses
+// to run ci-operator with `--promote`. Such configs usually started promoting
+// without their jobs being regenerated.
+func ConfigsWithoutPromotion(jobConfig *prowconfig.JobConfig, configs config.CompoundCiopConfig) []string {
+	promoted := map[string]bool{}
+	for _, jobs := range jobConfig.Postsubmits {
+		for _, job := range jobs {
+			if job.Spec == nil {
+				continue
+			}
+			for _, container := range job.Spec.Containers {
+				if !hasArg(container.Args, "--promote") {
+					continue
+				}
+				for _, env := range container.Env {
+					if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && config.IsCiopConfigCM(env.ValueFrom.ConfigMapKeyRef.Name) {
+						promoted[env.ValueFrom.ConfigMapKeyRef.Key] = true
+					}
+				}
+			}
+		}
+	}
+
+	var filenames []string
+	for filename, configSpec := range configs {
+		if promotion.PromotesOfficialImages(configSpec) && !promoted[filename] {
+			filenames = append(filenames, filename)
+		}
+	}
+	sort.Strings(filenames)
+	return filenames
+}
+
+func hasArg(args []string, arg string) bool {
+	for _, a := range args {
+		if a == arg {
+			return true
+		}
+	}
+	return false
+}
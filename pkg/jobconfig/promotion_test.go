@@ -0,0 +1,57 @@
+package jobconfig
+
+import (
+	"reflect"
+	"testing"
+
+	cioperatorapi "github.com/openshift/ci-operator/pkg/api"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+)
+
+func TestConfigsWithoutPromotion(t *testing.T) {
+	postsubmit := func(key string, args ...string) prowconfig.Postsubmit {
+		return prowconfig.Postsubmit{JobBase: prowconfig.JobBase{
+			Name: "branch-ci-" + key,
+			Spec: &v1.PodSpec{Containers: []v1.Container{{
+				Args: args,
+				Env: []v1.EnvVar{{
+					Name: "CONFIG_SPEC",
+					ValueFrom: &v1.EnvVarSource{ConfigMapKeyRef: &v1.ConfigMapKeySelector{
+						LocalObjectReference: v1.LocalObjectReference{Name: "ci-operator-master-configs"},
+						Key:                  key,
+					}},
+				}},
+			}}},
+		}}
+	}
+	official := &cioperatorapi.ReleaseBuildConfiguration{
+		PromotionConfiguration: &cioperatorapi.PromotionConfiguration{Namespace: "ocp", Name: "4.1"},
+	}
+	configs := config.CompoundCiopConfig{
+		"org-repo-master.yaml":          official,
+		"org-repo-release-4.1.yaml":     official,
+		"org-repo-no-promote.yaml":      official,
+		"org-repo-master__variant.yaml": official,
+		"org-other-master.yaml": &cioperatorapi.ReleaseBuildConfiguration{
+			PromotionConfiguration: &cioperatorapi.PromotionConfiguration{Namespace: "ci", Name: "other"},
+		},
+		"org-disabled-master.yaml": &cioperatorapi.ReleaseBuildConfiguration{
+			PromotionConfiguration: &cioperatorapi.PromotionConfiguration{Namespace: "ocp", Name: "4.1", Disabled: true},
+		},
+	}
+	jobConfig := &prowconfig.JobConfig{Postsubmits: map[string][]prowconfig.Postsubmit{
+		"org/repo": {
+			postsubmit("org-repo-master.yaml", "--target=[images]", "--promote"),
+			postsubmit("org-repo-no-promote.yaml", "--target=[images]"),
+		},
+	}}
+
+	expected := []string{"org-repo-master__variant.yaml", "org-repo-no-promote.yaml", "org-repo-release-4.1.yaml"}
+	if actual := ConfigsWithoutPromotion(jobConfig, configs); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("unexpected configs: %s", diff.ObjectReflectDiff(expected, actual))
+	}
+}
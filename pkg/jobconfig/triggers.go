@@ -0,0 +1,40 @@
+package jobconfig
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// TriggerFor returns the trigger of the presubmit generated for a test. The
+// test name is escaped, so that characters like dots in it match only
+// themselves.
+func TriggerFor(test string) string {
+	return prowconfig.DefaultTriggerFor(regexp.QuoteMeta(test))
+}
+
+// InvalidTriggers returns sorted descriptions of the presubmits in the config
+// whose trigger does not compile or does not match their rerun command, which
+// Prow would need to run them on request
+func InvalidTriggers(jobConfig *prowconfig.JobConfig) []string {
+	var invalid []string
+	for _, jobs := range jobConfig.Presubmits {
+		for _, job := range jobs {
+			if job.Trigger == "" {
+				continue
+			}
+			re, err := regexp.Compile(job.Trigger)
+			if err != nil {
+				invalid = append(invalid, fmt.Sprintf("%s: trigger %q does not compile (%v)", job.Name, job.Trigger, err))
+				continue
+			}
+			if !re.MatchString(job.RerunCommand) {
+				invalid = append(invalid, fmt.Sprintf("%s: trigger %q does not match rerun command %q", job.Name, job.Trigger, job.RerunCommand))
+			}
+		}
+	}
+	sort.Strings(invalid)
+	return invalid
+}
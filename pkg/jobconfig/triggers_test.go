@@ -0,0 +1,80 @@
+package jobconfig
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestTriggerFor(t *testing.T) {
+	testCases := []struct {
+		test     string
+		matches  []string
+		rejected []string
+	}{
+		{
+			test:     "unit",
+			matches:  []string{"/test unit", "/test all unit", "/test unit,"},
+			rejected: []string{"/test units", "/test e2e"},
+		},
+		{
+			test:     "e2e-4.1",
+			matches:  []string{"/test e2e-4.1"},
+			rejected: []string{"/test e2e-4x1"},
+		},
+		{
+			test:     "c++",
+			matches:  []string{"/test c++"},
+			rejected: []string{"/test ccc"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.test, func(t *testing.T) {
+			re, err := regexp.Compile(TriggerFor(tc.test))
+			if err != nil {
+				t.Fatalf("trigger does not compile: %v", err)
+			}
+			for _, comment := range tc.matches {
+				if !re.MatchString(comment) {
+					t.Errorf("trigger %q does not match %q", re, comment)
+				}
+			}
+			for _, comment := range tc.rejected {
+				if re.MatchString(comment) {
+					t.Errorf("trigger %q matches %q", re, comment)
+				}
+			}
+		})
+	}
+}
+
+func TestInvalidTriggers(t *testing.T) {
+	presubmit := func(name, trigger string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{
+			JobBase:      prowconfig.JobBase{Name: name},
+			RerunCommand: "/test " + name,
+			Trigger:      trigger,
+		}
+	}
+	jobConfig := &prowconfig.JobConfig{
+		Presubmits: map[string][]prowconfig.Presubmit{
+			"org/repo": {
+				presubmit("e2e-4.1", TriggerFor("e2e-4.1")),
+				presubmit("c++", TriggerFor("c++")),
+				presubmit("unset", ""),
+				presubmit("broken", prowconfig.DefaultTriggerFor("c++(")),
+				presubmit("other", TriggerFor("unit")),
+			},
+		},
+	}
+	expected := []string{
+		`broken: trigger "(?m)^/test( | .* )c++(,?($|\\s.*)" does not compile (error parsing regexp: invalid nested repetition operator: ` + "`++`" + `)`,
+		`other: trigger "(?m)^/test( | .* )unit,?($|\\s.*)" does not match rerun command "/test other"`,
+	}
+	if invalid := InvalidTriggers(jobConfig); !reflect.DeepEqual(expected, invalid) {
+		t.Errorf("unexpected invalid triggers: %s", diff.ObjectReflectDiff(expected, invalid))
+	}
+}
@@ -0,0 +1,65 @@
+package jobconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// ValidateWithProw checks the jobs the way Prow does when it loads them
+// together with the Prow configuration at `prowConfigPath`, which provides
+// defaults like the decoration config. It returns the errors for the jobs
+// Prow would reject, keyed by job name. Problems which only show with all the
+// jobs together, like duplicate jobs, are returned under an empty name.
+func ValidateWithProw(prowConfigPath string, jobConfig *prowconfig.JobConfig) (map[string]error, error) {
+	if _, err := prowconfig.Load(prowConfigPath, ""); err != nil {
+		return nil, fmt.Errorf("failed to load Prow configuration (%v)", err)
+	}
+	tmpDir, err := ioutil.TempDir("", "prow-validation")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory (%v)", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "jobs.yaml")
+
+	// loading all jobs at once is much faster than one by one, which is
+	// only needed to find out which jobs are rejected
+	type part struct {
+		name string
+		jobs *prowconfig.JobConfig
+	}
+	parts := []part{{jobs: jobConfig}}
+	for repo, presubmits := range jobConfig.Presubmits {
+		for _, job := range presubmits {
+			parts = append(parts, part{name: job.Name, jobs: &prowconfig.JobConfig{Presubmits: map[string][]prowconfig.Presubmit{repo: {job}}}})
+		}
+	}
+	for repo, postsubmits := range jobConfig.Postsubmits {
+		for _, job := range postsubmits {
+			parts = append(parts, part{name: job.Name, jobs: &prowconfig.JobConfig{Postsubmits: map[string][]prowconfig.Postsubmit{repo: {job}}}})
+		}
+	}
+	for _, job := range jobConfig.Periodics {
+		parts = append(parts, part{name: job.Name, jobs: &prowconfig.JobConfig{Periodics: []prowconfig.Periodic{job}}})
+	}
+
+	invalid := map[string]error{}
+	for i, part := range parts {
+		if err := writeToFile(path, part.jobs, 0); err != nil {
+			return nil, err
+		}
+		if _, err := prowconfig.Load(prowConfigPath, path); err != nil {
+			invalid[part.name] = err
+		} else if i == 0 {
+			return nil, nil
+		}
+	}
+	if len(invalid) > 1 {
+		// the error for all jobs is one of the errors for single jobs
+		delete(invalid, "")
+	}
+	return invalid, nil
+}
@@ -0,0 +1,76 @@
+package jobconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestValidateWithProw(t *testing.T) {
+	prowConfig := `plank:
+  default_decoration_config:
+    utility_images:
+      clonerefs: clonerefs:latest
+      initupload: initupload:latest
+      entrypoint: entrypoint:latest
+      sidecar: sidecar:latest
+    gcs_configuration:
+      bucket: bucket
+      path_strategy: single
+      default_org: org
+      default_repo: repo
+    gcs_credentials_secret: gce-sa-credentials-gcs-publisher
+`
+	tmp, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	prowConfigPath := filepath.Join(tmp, "config.yaml")
+	if err := ioutil.WriteFile(prowConfigPath, []byte(prowConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	presubmit := func(name string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{
+			JobBase: prowconfig.JobBase{
+				Name:          name,
+				Agent:         "kubernetes",
+				Spec:          &v1.PodSpec{Containers: []v1.Container{{Image: "ci-operator:latest", Command: []string{"ci-operator"}}}},
+				UtilityConfig: prowconfig.UtilityConfig{Decorate: true},
+			},
+			AlwaysRun:    true,
+			RerunCommand: "/test " + name,
+			Trigger:      `(?m)^/test( | .* )` + name + `,?($|\s.*)`,
+			Reporter:     prowconfig.Reporter{Context: "ci/prow/" + name},
+			Brancher:     prowconfig.Brancher{Branches: []string{"^master$"}},
+		}
+	}
+	valid := presubmit("pull-ci-org-repo-master-unit")
+	invalid := presubmit("pull-ci-org-repo-master-e2e")
+	invalid.MaxConcurrency = -1
+
+	rejected, err := ValidateWithProw(prowConfigPath, &prowconfig.JobConfig{Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {valid}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Errorf("expected no rejected jobs, got %v", rejected)
+	}
+
+	rejected, err = ValidateWithProw(prowConfigPath, &prowconfig.JobConfig{Presubmits: map[string][]prowconfig.Presubmit{"org/repo": {valid, invalid}}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := rejected[invalid.Name]; !ok || len(rejected) != 1 {
+		t.Errorf("expected only %s to be rejected, got %v", invalid.Name, rejected)
+	}
+
+	if _, err := ValidateWithProw(filepath.Join(tmp, "missing.yaml"), &prowconfig.JobConfig{}); err == nil {
+		t.Error("expected an error for a missing Prow configuration")
+	}
+}
@@ -24,6 +24,15 @@ func PromotesOfficialImages(configSpec *cioperatorapi.ReleaseBuildConfiguration)
 	return !isDisabled(configSpec) && buildOfficialImages(configSpec)
 }
 
+// PromotesToStream determines if a configuration will result in images being
+// promoted to the imagestream with the given namespace and name. Configurations
+// promoting by tag do not promote to a single imagestream and never match.
+func PromotesToStream(configSpec *cioperatorapi.ReleaseBuildConfiguration, namespace, name string) bool {
+	promotionNamespace := extractPromotionNamespace(configSpec)
+	promotionName := extractPromotionName(configSpec)
+	return !isDisabled(configSpec) && promotionName != "" && promotionNamespace == namespace && promotionName == name
+}
+
 func isDisabled(configSpec *cioperatorapi.ReleaseBuildConfiguration) bool {
 	return configSpec.PromotionConfiguration != nil && configSpec.PromotionConfiguration.Disabled
 }
@@ -70,6 +70,88 @@ func TestPromotesOfficialImages(t *testing.T) {
 	}
 }
 
+func TestPromotesToStream(t *testing.T) {
+	var testCases = []struct {
+		name              string
+		configSpec        *cioperatorapi.ReleaseBuildConfiguration
+		namespace, stream string
+		expected          bool
+	}{
+		{
+			name:       "config without promotion does not promote to a stream",
+			configSpec: &cioperatorapi.ReleaseBuildConfiguration{},
+			expected:   false,
+		},
+		{
+			name: "config promoting to the stream promotes to it",
+			configSpec: &cioperatorapi.ReleaseBuildConfiguration{
+				PromotionConfiguration: &cioperatorapi.PromotionConfiguration{
+					Namespace: "ocp",
+					Name:      "4.1",
+				},
+			},
+			namespace: "ocp",
+			stream:    "4.1",
+			expected:  true,
+		},
+		{
+			name: "config with disabled promotion to the stream does not promote to it",
+			configSpec: &cioperatorapi.ReleaseBuildConfiguration{
+				PromotionConfiguration: &cioperatorapi.PromotionConfiguration{
+					Namespace: "ocp",
+					Name:      "4.1",
+					Disabled:  true,
+				},
+			},
+			namespace: "ocp",
+			stream:    "4.1",
+			expected:  false,
+		},
+		{
+			name: "config promoting to another stream in the namespace does not promote to the stream",
+			configSpec: &cioperatorapi.ReleaseBuildConfiguration{
+				PromotionConfiguration: &cioperatorapi.PromotionConfiguration{
+					Namespace: "ocp",
+					Name:      "4.2",
+				},
+			},
+			namespace: "ocp",
+			stream:    "4.1",
+			expected:  false,
+		},
+		{
+			name: "config promoting to the stream name in another namespace does not promote to the stream",
+			configSpec: &cioperatorapi.ReleaseBuildConfiguration{
+				PromotionConfiguration: &cioperatorapi.PromotionConfiguration{
+					Namespace: "openshift",
+					Name:      "4.1",
+				},
+			},
+			namespace: "ocp",
+			stream:    "4.1",
+			expected:  false,
+		},
+		{
+			name: "config promoting by tag does not promote to a stream",
+			configSpec: &cioperatorapi.ReleaseBuildConfiguration{
+				PromotionConfiguration: &cioperatorapi.PromotionConfiguration{
+					Namespace: "ocp",
+					Tag:       "4.1",
+				},
+			},
+			namespace: "ocp",
+			expected:  false,
+		},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			if actual, expected := PromotesToStream(testCase.configSpec, testCase.namespace, testCase.stream), testCase.expected; actual != expected {
+				t.Errorf("%s: did not identify promotion to %s/%s correctly, expected %v got %v", testCase.name, testCase.namespace, testCase.stream, expected, actual)
+			}
+		})
+	}
+}
+
 func TestDetermineReleaseBranches(t *testing.T) {
 	var testCases = []struct {
 		name                                         string
@@ -2,10 +2,14 @@ package rehearse
 
 import (
 	"fmt"
+	"hash/fnv"
+	"io/ioutil"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/getlantern/deepcopy"
 	"github.com/ghodss/yaml"
@@ -19,6 +23,8 @@ import (
 	"k8s.io/apimachinery/pkg/selection"
 	kerrors "k8s.io/apimachinery/pkg/util/errors"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
 
 	"k8s.io/client-go/kubernetes/fake"
 	coreclientset "k8s.io/client-go/kubernetes/typed/core/v1"
@@ -33,6 +39,8 @@ import (
 	"k8s.io/test-infra/prow/pjutil"
 
 	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	"github.com/openshift/ci-operator-prowgen/pkg/diffs"
+	jc "github.com/openshift/ci-operator-prowgen/pkg/jobconfig"
 )
 
 const (
@@ -41,10 +49,31 @@ const (
 	logRehearsalJob              = "rehearsal-job"
 	logCiopConfigFile            = "ciop-config-file"
 	logCiopConfigRepo            = "ciop-config-repo"
+	logCiopConfigBranch          = "ciop-config-branch"
+	logTargetRepo                = "target-repo"
+	logTargetBranch              = "target-branch"
+	logTargetJob                 = "target-job"
+
+	// rehearsalSourceAnnotation holds the name of the source job of
+	// rehearsals whose names had to be truncated
+	rehearsalSourceAnnotation = "ci.openshift.org/rehearse-source"
+	// rehearsalRepoAnnotation holds the org/repo the rehearsed job targets
+	rehearsalRepoAnnotation = "ci.openshift.org/rehearse-repo"
+
+	// RehearsalPausedLabel marks rehearsal ProwJobs which were created paused.
+	// Prow does not know about pausing, so the controller running the jobs
+	// (plank) needs to ignore ProwJobs with this label, for example with the
+	// `!ci.openshift.org/rehearse-paused` label selector. Removing the label
+	// from a ProwJob triggers the rehearsal.
+	RehearsalPausedLabel = "ci.openshift.org/rehearse-paused"
 
 	clusterTypeEnvName = "CLUSTER_TYPE"
 )
 
+// templateClusterTypes are the cluster types for which jobs are picked to
+// rehearse changed templates
+var templateClusterTypes = []string{"aws", "gcs", "openstack", "libvirt", "vsphere", "gcp"}
+
 // Loggers holds the two loggers that will be used for normal and debug logging respectively.
 type Loggers struct {
 	Job, Debug logrus.FieldLogger
@@ -91,7 +120,16 @@ func makeRehearsalPresubmit(source *prowconfig.Presubmit, repo string, prNumber
 	var rehearsal prowconfig.Presubmit
 	deepcopy.Copy(&rehearsal, source)
 
-	rehearsal.Name = fmt.Sprintf("rehearse-%d-%s", prNumber, source.Name)
+	if rehearsal.Annotations == nil {
+		rehearsal.Annotations = make(map[string]string, 2)
+	}
+	rehearsal.Annotations[rehearsalRepoAnnotation] = repo
+
+	var truncated bool
+	rehearsal.Name, truncated = rehearsalName(source.Name, prNumber)
+	if truncated {
+		rehearsal.Annotations[rehearsalSourceAnnotation] = source.Name
+	}
 
 	branch := strings.TrimPrefix(strings.TrimSuffix(source.Branches[0], "$"), "^")
 	shortName := strings.TrimPrefix(source.Context, "ci/prow/")
@@ -101,6 +139,9 @@ func makeRehearsalPresubmit(source *prowconfig.Presubmit, repo string, prNumber
 	gitrefArg := fmt.Sprintf("--git-ref=%s@%s", repo, branch)
 	rehearsal.Spec.Containers[0].Args = append(source.Spec.Containers[0].Args, gitrefArg)
 	rehearsal.Optional = true
+	// rehearsals are submitted for PRs to the release repository, whose
+	// changed files have nothing to do with the paths of the source repository
+	rehearsal.RunIfChanged = ""
 
 	if rehearsal.Labels == nil {
 		rehearsal.Labels = make(map[string]string, 1)
@@ -110,22 +151,91 @@ func makeRehearsalPresubmit(source *prowconfig.Presubmit, repo string, prNumber
 	return &rehearsal, nil
 }
 
-func filterJobs(changedPresubmits map[string][]prowconfig.Presubmit, allowVolumes bool, logger logrus.FieldLogger) config.Presubmits {
+// rehearsalName prefixes the name of the source job with the PR number. Prow
+// uses job names as label values, which cannot be longer than 63 characters,
+// so longer names are truncated and suffixed with a hash of the full name to
+// keep them unique. The second return value tells whether that happened.
+func rehearsalName(sourceName string, prNumber int) (string, bool) {
+	name := fmt.Sprintf("rehearse-%d-%s", prNumber, sourceName)
+	if len(name) <= jc.MaxJobNameLength {
+		return name, false
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(name))
+	suffix := fmt.Sprintf("-%08x", hash.Sum32())
+	return strings.TrimRight(name[:jc.MaxJobNameLength-len(suffix)], ".-") + suffix, true
+}
+
+// JobFilter holds settings which exclude jobs from rehearsal regardless
+// of whether or how their configuration changed
+type JobFilter struct {
+	// ExcludedBranches holds patterns matching branches whose jobs are never rehearsed
+	ExcludedBranches []*regexp.Regexp
+	// ExcludedRepos holds org/repo names whose maintainers opted out of rehearsals
+	ExcludedRepos sets.String
+	// ClusterType, when set, restricts rehearsals to jobs with this CLUSTER_TYPE;
+	// jobs without a cluster type are not rehearsed then
+	ClusterType string
+}
+
+// LoadExcludedRepos reads org/repo names from a file with one name per line.
+// Empty lines and lines starting with `#` are ignored.
+func LoadExcludedRepos(path string) (sets.String, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read excluded repos (%v)", err)
+	}
+	repos := sets.NewString()
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if parts := strings.Split(line, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("%s:%d: expected org/repo, got %q", path, i+1, line)
+		}
+		repos.Insert(line)
+	}
+	return repos, nil
+}
+
+// excludesBranch returns true if a branch matches any of the excluded patterns
+func (f JobFilter) excludesBranch(branch string) bool {
+	for _, pattern := range f.ExcludedBranches {
+		if pattern.MatchString(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// filterJobs returns the jobs which can be rehearsed, together with the jobs
+// which cannot and why
+func filterJobs(changedPresubmits map[string][]prowconfig.Presubmit, allowVolumes bool, filter JobFilter, logger logrus.FieldLogger) (config.Presubmits, SkippedJobs) {
 	ret := config.Presubmits{}
+	var skipped SkippedJobs
 	for repo, jobs := range changedPresubmits {
+		if filter.ExcludedRepos.Has(repo) {
+			logger.WithField(logTargetRepo, repo).Warn("repository opted out of rehearsals, not rehearsing its jobs")
+			for _, job := range jobs {
+				skipped = append(skipped, SkippedJob{Repo: repo, Job: job.Name, Reason: "repository opted out of rehearsals"})
+			}
+			continue
+		}
 		for _, job := range jobs {
-			jobLogger := logger.WithFields(logrus.Fields{"repo": repo, "job": job.Name})
-			if err := filterJob(&job, allowVolumes); err != nil {
+			jobLogger := logger.WithFields(targetJobFields(repo, &job))
+			if err := filterJob(&job, allowVolumes, filter); err != nil {
 				jobLogger.WithError(err).Warn("could not rehearse job")
+				skipped = append(skipped, SkippedJob{Repo: repo, Job: job.Name, Reason: err.Error()})
 				continue
 			}
 			ret.Add(repo, job)
 		}
 	}
-	return ret
+	return ret, skipped
 }
 
-func filterJob(source *prowconfig.Presubmit, allowVolumes bool) error {
+func filterJob(source *prowconfig.Presubmit, allowVolumes bool, filter JobFilter) error {
 	// there will always be exactly one container.
 	container := source.Spec.Containers[0]
 
@@ -133,12 +243,10 @@ func filterJob(source *prowconfig.Presubmit, allowVolumes bool) error {
 		return fmt.Errorf("cannot rehearse jobs that have Command different from simple 'ci-operator'")
 	}
 
-	for _, arg := range container.Args {
-		if strings.HasPrefix(arg, "--git-ref") || strings.HasPrefix(arg, "-git-ref") {
-			return fmt.Errorf("cannot rehearse jobs that call ci-operator with '--git-ref' arg")
-		}
+	if len(diffs.CiOperatorArgValues(container.Args, "git-ref")) > 0 {
+		return fmt.Errorf("cannot rehearse jobs that call ci-operator with '--git-ref' arg")
 	}
-	if len(source.Spec.Volumes) > 0 && !allowVolumes {
+	if hasAdditionalVolumes(source.Spec) && !allowVolumes {
 		return fmt.Errorf("jobs that need additional volumes mounted are not allowed")
 	}
 
@@ -149,9 +257,77 @@ func filterJob(source *prowconfig.Presubmit, allowVolumes bool) error {
 	if len(source.Branches) != 1 {
 		return fmt.Errorf("cannot rehearse jobs that run over multiple branches")
 	}
+
+	branch := jobBranch(source)
+	if !concreteBranch.MatchString(branch) {
+		return fmt.Errorf("cannot rehearse jobs that run over all branches matching %s", branch)
+	}
+	if filter.excludesBranch(branch) {
+		return fmt.Errorf("jobs for branch %s are excluded from rehearsals", branch)
+	}
+
+	if filter.ClusterType != "" && !hasClusterType(*source, filter.ClusterType) {
+		return fmt.Errorf("only jobs for cluster type %s are rehearsed", filter.ClusterType)
+	}
 	return nil
 }
 
+// generatedSecretVolumes are the names of the secret volumes the generator
+// adds to jobs which acquire leases and pull from registries of their build
+// cluster. Rehearsals run where the jobs do and can mount them as they are.
+var generatedSecretVolumes = sets.NewString("boskos", "pull-secret")
+
+// hasAdditionalVolumes tells whether the pod mounts volumes other than the
+// secrets mounted by all generated jobs using leases or pull secrets
+func hasAdditionalVolumes(spec *v1.PodSpec) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret == nil || !generatedSecretVolumes.Has(volume.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// concreteBranch matches the branches of jobs which run on a single named
+// branch, which rehearsals check out, rather than on all branches matching a
+// regular expression, like jobs generated for glob branches
+var concreteBranch = regexp.MustCompile(`^[\w\-\./]+$`)
+
+// jobBranch returns the branch a job runs on, or the raw branch
+// patterns when there is not exactly one of them
+func jobBranch(job *prowconfig.Presubmit) string {
+	if len(job.Branches) != 1 {
+		return strings.Join(job.Branches, ",")
+	}
+	return strings.TrimPrefix(strings.TrimSuffix(job.Branches[0], "$"), "^")
+}
+
+// ciopConfigFilename returns the name of the ci-operator config file a job
+// uses from a `ci-operator-configs` ConfigMap, if any
+func ciopConfigFilename(job *prowconfig.Presubmit) string {
+	if job.Spec == nil {
+		return ""
+	}
+	for _, container := range job.Spec.Containers {
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.ConfigMapKeyRef != nil && config.IsCiopConfigCM(env.ValueFrom.ConfigMapKeyRef.Name) {
+				return env.ValueFrom.ConfigMapKeyRef.Key
+			}
+		}
+	}
+	return ""
+}
+
+// targetJobFields returns the log fields identifying a rehearsed job and
+// the ci-operator config it was generated from
+func targetJobFields(repo string, job *prowconfig.Presubmit) logrus.Fields {
+	fields := logrus.Fields{logTargetRepo: repo, logTargetBranch: jobBranch(job), logTargetJob: job.Name}
+	if filename := ciopConfigFilename(job); filename != "" {
+		fields[logCiopConfigFile] = filename
+	}
+	return fields
+}
+
 // inlineCiOpConfig detects whether a job needs a ci-operator config file
 // provided by a `ci-operator-configs` ConfigMap and if yes, returns a copy
 // of the job where a reference to this ConfigMap is replaced by the content
@@ -173,7 +349,7 @@ func inlineCiOpConfig(job *prowconfig.Presubmit, targetRepo string, ciopConfigs
 			if config.IsCiopConfigCM(env.ValueFrom.ConfigMapKeyRef.Name) {
 				filename := env.ValueFrom.ConfigMapKeyRef.Key
 
-				logFields := logrus.Fields{logCiopConfigFile: filename, logCiopConfigRepo: targetRepo, logRehearsalJob: job.Name}
+				logFields := logrus.Fields{logCiopConfigFile: filename, logCiopConfigRepo: targetRepo, logCiopConfigBranch: jobBranch(job), logRehearsalJob: job.Name}
 				loggers.Debug.WithFields(logFields).Debug("Rehearsal job uses ci-operator config ConfigMap, needed content will be inlined")
 
 				ciopConfig, ok := ciopConfigs[filename]
@@ -183,7 +359,7 @@ func inlineCiOpConfig(job *prowconfig.Presubmit, targetRepo string, ciopConfigs
 
 				ciOpConfigContent, err := yaml.Marshal(ciopConfig)
 				if err != nil {
-					loggers.Job.WithError(err).Error("Failed to marshal ci-operator config file")
+					loggers.Job.WithFields(logFields).WithError(err).Error("Failed to marshal ci-operator config file")
 					return nil, err
 				}
 
@@ -197,8 +373,10 @@ func inlineCiOpConfig(job *prowconfig.Presubmit, targetRepo string, ciopConfigs
 }
 
 // ConfigureRehearsalJobs filters the jobs that should be rehearsed, then return a list of them re-configured with the
-// ci-operator's configuration inlined.
-func ConfigureRehearsalJobs(toBeRehearsed config.Presubmits, ciopConfigs config.CompoundCiopConfig, prNumber int, loggers Loggers, allowVolumes bool, templates []config.ConfigMapSource, profiles []config.ConfigMapSource) []*prowconfig.Presubmit {
+// ci-operator's configuration inlined. The rehearsals are grouped by the repository their source jobs target, with
+// both repositories and jobs sorted by name. The jobs which cannot be rehearsed are returned with the reasons, sorted
+// the same way, so that they can be reported.
+func ConfigureRehearsalJobs(toBeRehearsed config.Presubmits, ciopConfigs config.CompoundCiopConfig, prNumber int, loggers Loggers, allowVolumes bool, filter JobFilter, templates []config.ConfigMapSource, profiles []config.ConfigMapSource) ([]*prowconfig.Presubmit, SkippedJobs) {
 	var templateMap map[string]string
 	if allowVolumes {
 		templateMap = make(map[string]string, len(templates))
@@ -208,19 +386,26 @@ func ConfigureRehearsalJobs(toBeRehearsed config.Presubmits, ciopConfigs config.
 	}
 	rehearsals := []*prowconfig.Presubmit{}
 
-	rehearsalsFiltered := filterJobs(toBeRehearsed, allowVolumes, loggers.Job)
-	for repo, jobs := range rehearsalsFiltered {
+	rehearsalsFiltered, skipped := filterJobs(toBeRehearsed, allowVolumes, filter, loggers.Job)
+	for _, repo := range sets.StringKeySet(rehearsalsFiltered).List() {
+		jobs := rehearsalsFiltered[repo]
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
 		for _, job := range jobs {
-			jobLogger := loggers.Job.WithFields(logrus.Fields{"target-repo": repo, "target-job": job.Name})
+			jobLogger := loggers.Job.WithFields(targetJobFields(repo, &job))
 			rehearsal, err := makeRehearsalPresubmit(&job, repo, prNumber)
 			if err != nil {
 				jobLogger.WithError(err).Warn("Failed to make a rehearsal presubmit")
+				skipped = append(skipped, SkippedJob{Repo: repo, Job: job.Name, Reason: err.Error()})
 				continue
 			}
+			if _, truncated := rehearsal.Annotations[rehearsalSourceAnnotation]; truncated {
+				jobLogger.WithField(logRehearsalJob, rehearsal.Name).Info("Rehearsal job name was truncated to fit Prow's length limit")
+			}
 
 			rehearsal, err = inlineCiOpConfig(rehearsal, repo, ciopConfigs, loggers)
 			if err != nil {
 				jobLogger.WithError(err).Warn("Failed to inline ci-operator-config into rehearsal job")
+				skipped = append(skipped, SkippedJob{Repo: repo, Job: job.Name, Reason: err.Error()})
 				continue
 			}
 
@@ -233,26 +418,54 @@ func ConfigureRehearsalJobs(toBeRehearsed config.Presubmits, ciopConfigs config.
 			rehearsals = append(rehearsals, rehearsal)
 		}
 	}
+	skipped.sort()
 
-	return rehearsals
+	return rehearsals, skipped
+}
+
+// OverrideCiOperatorImage makes the ci-operator containers of the rehearsal jobs
+// run the given image instead of the one the jobs are configured with. This
+// allows rehearsing jobs with a modified ci-operator, like one built for a PR.
+// Every replaced image is logged so that it is clear the rehearsals did not run
+// the configured ci-operator.
+func OverrideCiOperatorImage(rehearsals []*prowconfig.Presubmit, image string, logger *logrus.Entry) {
+	for _, rehearsal := range rehearsals {
+		for i := range rehearsal.Spec.Containers {
+			container := &rehearsal.Spec.Containers[i]
+			if len(container.Command) == 0 || container.Command[0] != "ci-operator" {
+				continue
+			}
+			logger.WithFields(logrus.Fields{
+				logRehearsalJob:  rehearsal.Name,
+				"original-image": container.Image,
+				"image":          image,
+			}).Info("Overriding ci-operator image of a rehearsal job")
+			container.Image = image
+		}
+	}
 }
 
 // AddRandomJobsForChangedTemplates finds jobs from the PR config that are using a specific template with a specific cluster type.
-// The job selection is done by iterating in an unspecified order, which avoids picking the same job
 // So if a template will be changed, find the jobs that are using a template in combination with the `aws`,`openstack`,`gcs` and `libvirt` cluster types.
-func AddRandomJobsForChangedTemplates(templates []config.ConfigMapSource, toBeRehearsed config.Presubmits, prConfigPresubmits map[string][]prowconfig.Presubmit, loggers Loggers, prNumber int) config.Presubmits {
+// The job selection is deterministic: by default, it is derived from the PR number, so rehearsals of the same PR always pick
+// the same jobs. A non-empty `seed` replaces the PR number in the selection, which allows picking different jobs on demand.
+// The selection stays reproducible when the same seed is passed again.
+func AddRandomJobsForChangedTemplates(templates []config.ConfigMapSource, toBeRehearsed config.Presubmits, prConfigPresubmits map[string][]prowconfig.Presubmit, loggers Loggers, prNumber int, seed string) config.Presubmits {
 	rehearsals := make(config.Presubmits)
+	if seed == "" {
+		seed = strconv.Itoa(prNumber)
+	}
 
 	for _, template := range templates {
 		templateFile := filepath.Base(template.Filename)
-		for _, clusterType := range []string{"aws", "gcs", "openstack", "libvirt", "vsphere", "gcp"} {
+		for _, clusterType := range templateClusterTypes {
 
 			if isAlreadyRehearsed(toBeRehearsed, clusterType, templateFile) {
 				continue
 			}
 
-			if repo, job := pickTemplateJob(prConfigPresubmits, templateFile, clusterType); job != nil {
-				jobLogger := loggers.Job.WithFields(logrus.Fields{"target-repo": repo, "target-job": job.Name})
+			if repo, job := pickTemplateJob(prConfigPresubmits, templateFile, clusterType, seed); job != nil {
+				jobLogger := loggers.Job.WithFields(targetJobFields(repo, job)).WithField("seed", seed)
 				jobLogger.Info("Picking job to rehearse the template changes")
 				rehearsals[repo] = append(rehearsals[repo], *job)
 			}
@@ -261,6 +474,120 @@ func AddRandomJobsForChangedTemplates(templates []config.ConfigMapSource, toBeRe
 	return rehearsals
 }
 
+// SampleIdenticalChanges picks representative jobs among the jobs selected for rehearsal for identical changes, which
+// happens when a change to shared defaults touches many jobs the same way. The changes for which jobs were selected are
+// described by `details`, keyed by job name. When more than `threshold` jobs were selected for the same changes, only
+// one of them is kept for each repository and cluster type; jobs without details are always kept. Only jobs which
+// ConfigureRehearsalJobs rehearses with the same arguments are candidates, the others are kept so that it reports why
+// they are not rehearsed. Like with templates, the pick only depends on the seed, which defaults to the PR number, and on
+// the candidate jobs. The jobs that are not kept are returned as skipped, so that the sampling is reported.
+func SampleIdenticalChanges(toBeRehearsed config.Presubmits, details map[string][]string, threshold int, ciopConfigs config.CompoundCiopConfig, allowVolumes bool, filter JobFilter, loggers Loggers, prNumber int, seed string) (config.Presubmits, SkippedJobs) {
+	if seed == "" {
+		seed = strconv.Itoa(prNumber)
+	}
+	type candidate struct {
+		repo string
+		job  prowconfig.Presubmit
+	}
+	groups := map[string][]candidate{}
+	sampled := config.Presubmits{}
+	for _, repo := range sets.StringKeySet(toBeRehearsed).List() {
+		for _, job := range toBeRehearsed[repo] {
+			if len(details[job.Name]) == 0 || !rehearsable(&job, repo, ciopConfigs, prNumber, allowVolumes, filter, loggers) {
+				sampled.Add(repo, job)
+				continue
+			}
+			change := strings.Join(details[job.Name], "\n")
+			groups[change] = append(groups[change], candidate{repo: repo, job: job})
+		}
+	}
+
+	var skipped SkippedJobs
+	for change, candidates := range groups {
+		if len(candidates) <= threshold {
+			for _, c := range candidates {
+				sampled.Add(c.repo, c.job)
+			}
+			continue
+		}
+		buckets := map[string][]candidate{}
+		for _, c := range candidates {
+			var clusterType string
+			for _, env := range c.job.Spec.Containers[0].Env {
+				if env.Name == clusterTypeEnvName {
+					clusterType = env.Value
+				}
+			}
+			bucket := c.repo + "/" + clusterType
+			buckets[bucket] = append(buckets[bucket], c)
+		}
+		for bucket, bucketCandidates := range buckets {
+			sort.Slice(bucketCandidates, func(i, j int) bool {
+				return bucketCandidates[i].job.Name < bucketCandidates[j].job.Name
+			})
+			hash := fnv.New32a()
+			hash.Write([]byte(strings.Join([]string{seed, bucket, change}, "/")))
+			picked := bucketCandidates[hash.Sum32()%uint32(len(bucketCandidates))]
+			loggers.Job.WithFields(targetJobFields(picked.repo, &picked.job)).WithField("seed", seed).Info("Picking job to represent identically changed jobs")
+			sampled.Add(picked.repo, picked.job)
+			for _, c := range bucketCandidates {
+				if c.job.Name != picked.job.Name {
+					reason := fmt.Sprintf("%d jobs changed identically, `%s` is rehearsed for this repository and cluster type", len(candidates), picked.job.Name)
+					skipped = append(skipped, SkippedJob{Repo: c.repo, Job: c.job.Name, Reason: reason})
+				}
+			}
+		}
+	}
+	for _, jobs := range sampled {
+		sort.Slice(jobs, func(i, j int) bool { return jobs[i].Name < jobs[j].Name })
+	}
+	skipped.sort()
+	return sampled, skipped
+}
+
+// rehearsable tells whether ConfigureRehearsalJobs makes a rehearsal of the job with the same arguments
+func rehearsable(job *prowconfig.Presubmit, repo string, ciopConfigs config.CompoundCiopConfig, prNumber int, allowVolumes bool, filter JobFilter, loggers Loggers) bool {
+	if filter.ExcludedRepos.Has(repo) {
+		return false
+	}
+	if err := filterJob(job, allowVolumes, filter); err != nil {
+		return false
+	}
+	rehearsal, err := makeRehearsalPresubmit(job, repo, prNumber)
+	if err != nil {
+		return false
+	}
+	_, err = inlineCiOpConfig(rehearsal, repo, ciopConfigs, loggers)
+	return err == nil
+}
+
+// UncoveredTemplateClusterTypes returns the cluster types of jobs using the changed templates for which
+// AddRandomJobsForChangedTemplates never picks a job, because they are not among the cluster types it knows, and which
+// are not covered by the jobs already selected for rehearsal. Such template changes would go unrehearsed silently.
+func UncoveredTemplateClusterTypes(templates []config.ConfigMapSource, toBeRehearsed config.Presubmits, prConfigPresubmits map[string][]prowconfig.Presubmit) []string {
+	known := sets.NewString(templateClusterTypes...)
+	uncovered := sets.NewString()
+	for _, template := range templates {
+		templateFile := filepath.Base(template.Filename)
+		for _, jobs := range prConfigPresubmits {
+			for _, job := range jobs {
+				if job.Agent != string(pjapi.KubernetesAgent) || !hasTemplateFile(job, templateFile) {
+					continue
+				}
+				for _, env := range job.Spec.Containers[0].Env {
+					if env.Name != clusterTypeEnvName || env.Value == "" || known.Has(env.Value) {
+						continue
+					}
+					if !isAlreadyRehearsed(toBeRehearsed, env.Value, templateFile) {
+						uncovered.Insert(fmt.Sprintf("%s (template %s)", env.Value, templateFile))
+					}
+				}
+			}
+		}
+	}
+	return uncovered.List()
+}
+
 func isAlreadyRehearsed(toBeRehearsed config.Presubmits, clusterType, templateFile string) bool {
 	for _, jobs := range toBeRehearsed {
 		for _, job := range jobs {
@@ -282,24 +609,44 @@ func replaceCMTemplateName(volumeMounts []v1.VolumeMount, volumes []v1.Volume, m
 	}
 }
 
-func pickTemplateJob(presubmits map[string][]prowconfig.Presubmit, templateFile, clusterType string) (string, *prowconfig.Presubmit) {
+// pickTemplateJob picks one of the jobs using the template with the cluster
+// type. The pick only depends on the seed and the set of candidate jobs.
+func pickTemplateJob(presubmits map[string][]prowconfig.Presubmit, templateFile, clusterType, seed string) (string, *prowconfig.Presubmit) {
 	var keys []string
 	for k := range presubmits {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
+
+	type candidate struct {
+		repo string
+		job  prowconfig.Presubmit
+	}
+	var candidates []candidate
 	for _, repo := range keys {
+		var repoCandidates []candidate
 		for _, job := range presubmits[repo] {
 			if job.Agent != string(pjapi.KubernetesAgent) {
 				continue
 			}
 
 			if hasClusterType(job, clusterType) && hasTemplateFile(job, templateFile) {
-				return repo, &job
+				repoCandidates = append(repoCandidates, candidate{repo: repo, job: job})
 			}
 		}
+		sort.Slice(repoCandidates, func(i, j int) bool {
+			return repoCandidates[i].job.Name < repoCandidates[j].job.Name
+		})
+		candidates = append(candidates, repoCandidates...)
+	}
+	if len(candidates) == 0 {
+		return "", nil
 	}
-	return "", nil
+
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.Join([]string{seed, templateFile, clusterType}, "/")))
+	picked := candidates[hash.Sum32()%uint32(len(candidates))]
+	return picked.repo, &picked.job
 }
 
 func hasClusterType(job prowconfig.Presubmit, clusterType string) bool {
@@ -349,20 +696,50 @@ func replaceClusterProfiles(volumes []v1.Volume, profiles []config.ConfigMapSour
 	}
 }
 
+// ListPausedRehearsals returns the ProwJobs of the rehearsals for a PR which
+// were created paused and were not triggered yet, sorted by job name
+func ListPausedRehearsals(pjclient pj.ProwJobInterface, prNumber int) ([]pjapi.ProwJob, error) {
+	selector := labels.Set{rehearseLabel: strconv.Itoa(prNumber), RehearsalPausedLabel: "true"}.AsSelector().String()
+	list, err := pjclient.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list ProwJobs (%v)", err)
+	}
+	pjs := list.Items
+	sort.Slice(pjs, func(i, j int) bool { return pjs[i].Spec.Job < pjs[j].Spec.Job })
+	return pjs, nil
+}
+
+// defaultWatchBackoff determines how quickly a ProwJob watch is re-established
+// when it could not be created or was closed without delivering any event.
+// `Steps` is the number of consecutive failures after which we give up.
+var defaultWatchBackoff = wait.Backoff{
+	Duration: time.Second,
+	Factor:   2,
+	Jitter:   0.5,
+	Steps:    8,
+}
+
 // Executor holds all the information needed for the jobs to be executed.
 type Executor struct {
 	Metrics *ExecutionMetrics
-
-	dryRun     bool
-	rehearsals []*prowconfig.Presubmit
-	prNumber   int
-	prRepo     string
-	refs       *pjapi.Refs
-	loggers    Loggers
-	pjclient   pj.ProwJobInterface
+	// Paused makes the executor create the rehearsal ProwJobs with the
+	// RehearsalPausedLabel and not wait for them, as they only run once
+	// someone removes the label
+	Paused bool
+
+	dryRun       bool
+	rehearsals   []*prowconfig.Presubmit
+	prNumber     int
+	prRepo       string
+	refs         *pjapi.Refs
+	loggers      Loggers
+	pjclient     pj.ProwJobInterface
+	watchBackoff wait.Backoff
 }
 
 // NewExecutor creates an executor. It also confgures the rehearsal jobs as a list of presubmits.
+// Rehearsals are submitted with the provided refs, which are validated by ExecuteJobs
+// (see ValidateRefs for how they interact with rehearsals).
 func NewExecutor(rehearsals []*prowconfig.Presubmit, prNumber int, prRepo string, refs *pjapi.Refs,
 	dryRun bool, loggers Loggers, pjclient pj.ProwJobInterface) *Executor {
 	return &Executor{
@@ -375,6 +752,8 @@ func NewExecutor(rehearsals []*prowconfig.Presubmit, prNumber int, prRepo string
 		refs:       refs,
 		loggers:    loggers,
 		pjclient:   pjclient,
+
+		watchBackoff: defaultWatchBackoff,
 	}
 }
 
@@ -394,6 +773,10 @@ func printAsYaml(pjs []*pjapi.ProwJob) error {
 // is changed, giving feedback to Prow config authors on how the changes of the
 // config would affect the "production" Prow jobs run on the actual target repos
 func (e *Executor) ExecuteJobs() (bool, error) {
+	if err := ValidateRefs(e.refs); err != nil {
+		return false, fmt.Errorf("cannot submit rehearsals with invalid refs: %v", err)
+	}
+
 	submitSuccess := true
 	pjs, err := e.submitRehearsals()
 	if err != nil {
@@ -409,6 +792,14 @@ func (e *Executor) ExecuteJobs() (bool, error) {
 		return true, fmt.Errorf("failed to submit all rehearsal jobs")
 	}
 
+	if e.Paused {
+		e.loggers.Job.WithField("jobs", len(pjs)).Info("Rehearsals were created paused and will run when triggered")
+		if !submitSuccess {
+			return true, fmt.Errorf("failed to submit all rehearsal jobs")
+		}
+		return true, nil
+	}
+
 	req, err := labels.NewRequirement(rehearseLabel, selection.Equals, []string{strconv.Itoa(e.prNumber)})
 	if err != nil {
 		return false, fmt.Errorf("failed to create label selector: %v", err)
@@ -431,40 +822,77 @@ func (e *Executor) waitForJobs(jobs sets.String, selector string) (bool, error)
 		return true, nil
 	}
 	success := true
+	failures := 0
+	delay := e.watchBackoff.Duration
 	for {
 		w, err := e.pjclient.Watch(metav1.ListOptions{LabelSelector: selector})
 		if err != nil {
-			return false, fmt.Errorf("failed to create watch for ProwJobs: %v", err)
-		}
-		defer w.Stop()
-		for event := range w.ResultChan() {
-			pj, ok := event.Object.(*pjapi.ProwJob)
-			if !ok {
-				return false, fmt.Errorf("received a %T from watch", event.Object)
-			}
-			fields := pjutil.ProwJobFields(pj)
-			fields["state"] = pj.Status.State
-			e.loggers.Debug.WithFields(fields).Debug("Processing ProwJob")
-			if !jobs.Has(pj.Name) {
-				continue
-			}
-			switch pj.Status.State {
-			case pjapi.FailureState, pjapi.AbortedState, pjapi.ErrorState:
-				e.loggers.Job.WithFields(fields).Error("Job failed")
-				e.Metrics.FailedRehearsals = append(e.Metrics.FailedRehearsals, pj.Spec.Job)
-				success = false
-			case pjapi.SuccessState:
-				e.loggers.Job.WithFields(fields).Info("Job succeeded")
-				e.Metrics.PassedRehearsals = append(e.Metrics.FailedRehearsals, pj.Spec.Job)
-			default:
-				continue
+			e.loggers.Job.WithError(err).Warn("Failed to create watch for ProwJobs")
+		} else {
+			received, err := e.processWatchEvents(w, jobs, &success)
+			if err != nil {
+				return false, err
 			}
-			jobs.Delete(pj.Name)
 			if jobs.Len() == 0 {
 				return success, nil
 			}
+			if received {
+				// the watch worked and simply expired, so re-establish it right away
+				failures = 0
+				delay = e.watchBackoff.Duration
+				continue
+			}
+		}
+
+		failures++
+		if failures >= e.watchBackoff.Steps {
+			return false, fmt.Errorf("failed to watch ProwJobs: giving up after %d consecutive failures", failures)
+		}
+		sleep := delay
+		if e.watchBackoff.Jitter > 0 {
+			sleep = wait.Jitter(delay, e.watchBackoff.Jitter)
+		}
+		e.loggers.Debug.WithField("delay", sleep).Debug("Re-establishing ProwJob watch after backoff")
+		time.Sleep(sleep)
+		delay = time.Duration(float64(delay) * e.watchBackoff.Factor)
+	}
+}
+
+// processWatchEvents consumes the events from a watch until it is closed or all
+// jobs finished, removing finished jobs from `jobs` so that they are never processed
+// again when the watch is re-established. It returns whether any event was received.
+func (e *Executor) processWatchEvents(w watch.Interface, jobs sets.String, success *bool) (bool, error) {
+	defer w.Stop()
+	received := false
+	for event := range w.ResultChan() {
+		received = true
+		pj, ok := event.Object.(*pjapi.ProwJob)
+		if !ok {
+			return received, fmt.Errorf("received a %T from watch", event.Object)
+		}
+		fields := pjutil.ProwJobFields(pj)
+		fields["state"] = pj.Status.State
+		e.loggers.Debug.WithFields(fields).Debug("Processing ProwJob")
+		if !jobs.Has(pj.Name) {
+			continue
+		}
+		switch pj.Status.State {
+		case pjapi.FailureState, pjapi.AbortedState, pjapi.ErrorState:
+			e.loggers.Job.WithFields(fields).Error("Job failed")
+			e.Metrics.FailedRehearsals = append(e.Metrics.FailedRehearsals, pj.Spec.Job)
+			*success = false
+		case pjapi.SuccessState:
+			e.loggers.Job.WithFields(fields).Info("Job succeeded")
+			e.Metrics.PassedRehearsals = append(e.Metrics.PassedRehearsals, pj.Spec.Job)
+		default:
+			continue
+		}
+		jobs.Delete(pj.Name)
+		if jobs.Len() == 0 {
+			return received, nil
 		}
 	}
+	return received, nil
 }
 
 func (e *Executor) submitRehearsals() ([]*pjapi.ProwJob, error) {
@@ -474,12 +902,12 @@ func (e *Executor) submitRehearsals() ([]*pjapi.ProwJob, error) {
 	for _, job := range e.rehearsals {
 		created, err := e.submitRehearsal(job)
 		if err != nil {
-			e.loggers.Job.WithError(err).Warn("Failed to execute a rehearsal presubmit")
+			e.loggers.Job.WithError(err).WithField(logTargetRepo, job.Annotations[rehearsalRepoAnnotation]).Warn("Failed to execute a rehearsal presubmit")
 			errors = append(errors, err)
 			continue
 		}
 		e.Metrics.SubmittedRehearsals = append(e.Metrics.SubmittedRehearsals, created.Spec.Job)
-		e.loggers.Job.WithFields(pjutil.ProwJobFields(created)).Info("Submitted rehearsal prowjob")
+		e.loggers.Job.WithFields(pjutil.ProwJobFields(created)).WithField(logTargetRepo, job.Annotations[rehearsalRepoAnnotation]).Info("Submitted rehearsal prowjob")
 		pjs = append(pjs, created)
 	}
 	return pjs, kerrors.NewAggregate(errors)
@@ -490,6 +918,9 @@ func (e *Executor) submitRehearsal(job *prowconfig.Presubmit) (*pjapi.ProwJob, e
 	for k, v := range job.Labels {
 		labels[k] = v
 	}
+	if e.Paused {
+		labels[RehearsalPausedLabel] = "true"
+	}
 
 	prowJob := pjutil.NewProwJob(pjutil.PresubmitSpec(*job, *e.refs), labels)
 	e.loggers.Job.WithFields(pjutil.ProwJobFields(&prowJob)).Info("Submitting a new prowjob.")
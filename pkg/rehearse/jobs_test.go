@@ -2,11 +2,16 @@ package rehearse
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"reflect"
+	"regexp"
 	"sort"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/getlantern/deepcopy"
 	"github.com/ghodss/yaml"
@@ -24,6 +29,7 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/diff"
 	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/apimachinery/pkg/watch"
 
 	clientgo_testing "k8s.io/client-go/testing"
@@ -86,7 +92,7 @@ func TestConfigureRehearsalJobs(t *testing.T) {
 		SHA:      "85c627078710b8beee65d06d0cf157094fc46b03",
 		Filename: filepath.Join(config.ClusterProfilesPath, "changed-profile1"),
 	}}
-	ret := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, 1234, Loggers{logrus.New(), logrus.New()}, true, nil, profiles)
+	ret, _ := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, 1234, Loggers{logrus.New(), logrus.New()}, true, JobFilter{}, nil, profiles)
 	var names []string
 	for _, j := range ret {
 		if vs := j.Spec.Volumes; len(vs) == 0 {
@@ -96,9 +102,9 @@ func TestConfigureRehearsalJobs(t *testing.T) {
 		}
 	}
 	expected := []string{
-		"", config.ClusterProfilePrefix + "unchanged",
 		"rehearse-cluster-profile-changed-profile0-47f520ef",
 		"rehearse-cluster-profile-changed-profile1-85c62707",
+		"", config.ClusterProfilePrefix + "unchanged",
 	}
 	if !reflect.DeepEqual(expected, names) {
 		t.Fatal(diff.ObjectDiff(expected, names))
@@ -130,6 +136,78 @@ func makeCMReference(cmName, key string) *v1.EnvVarSource {
 	}
 }
 
+func TestConfigureRehearsalJobsMultipleRepos(t *testing.T) {
+	jobs := config.Presubmits{
+		"org/zeta": {*makeTestingPresubmit("pull-ci-org-zeta-master-unit", "ci/prow/unit", nil, "master")},
+		"org/alpha": {
+			*makeTestingPresubmit("pull-ci-org-alpha-master-unit", "ci/prow/unit", nil, "master"),
+			*makeTestingPresubmit("pull-ci-org-alpha-master-e2e", "ci/prow/e2e", nil, "master"),
+		},
+		"other/repo": {*makeTestingPresubmit("pull-ci-other-repo-release-1.0-unit", "ci/prow/unit", nil, "release-1.0")},
+	}
+	rehearsals, _ := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, 123, Loggers{logrus.New(), logrus.New()}, false, JobFilter{}, nil, nil)
+
+	type rehearsal struct{ repo, name, context string }
+	var actual []rehearsal
+	for _, job := range rehearsals {
+		actual = append(actual, rehearsal{repo: job.Annotations[rehearsalRepoAnnotation], name: job.Name, context: job.Context})
+	}
+	expected := []rehearsal{
+		{repo: "org/alpha", name: "rehearse-123-pull-ci-org-alpha-master-e2e", context: "ci/rehearse/org/alpha/master/e2e"},
+		{repo: "org/alpha", name: "rehearse-123-pull-ci-org-alpha-master-unit", context: "ci/rehearse/org/alpha/master/unit"},
+		{repo: "org/zeta", name: "rehearse-123-pull-ci-org-zeta-master-unit", context: "ci/rehearse/org/zeta/master/unit"},
+		{repo: "other/repo", name: "rehearse-123-pull-ci-other-repo-release-1.0-unit", context: "ci/rehearse/other/repo/release-1.0/unit"},
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Fatalf("unexpected rehearsals: %s", diff.ObjectReflectDiff(expected, actual))
+	}
+
+	plan := NewPlan(rehearsals, 123, nil, nil)
+	var repos []string
+	for _, planned := range plan {
+		repos = append(repos, planned.Repo)
+	}
+	expectedRepos := []string{"org/alpha", "org/alpha", "org/zeta", "other/repo"}
+	if !reflect.DeepEqual(expectedRepos, repos) {
+		t.Errorf("unexpected repos in plan: %s", diff.ObjectReflectDiff(expectedRepos, repos))
+	}
+}
+
+func TestConfigureRehearsalJobsSkipped(t *testing.T) {
+	multiBranch := makeTestingPresubmit("pull-ci-org-repo-multi-unit", "ci/prow/unit", nil, "master")
+	multiBranch.Branches = []string{"^master$", "^release-4.1$"}
+	notCiop := makeTestingPresubmit("pull-ci-org-repo-master-lint", "ci/prow/lint", nil, "master")
+	notCiop.Spec.Containers[0].Command = []string{"make"}
+	missingConfig := makeTestingPresubmit("pull-ci-org-other-master-unit", "ci/prow/unit", nil, "master")
+	missingConfig.Spec.Containers[0].Env = []v1.EnvVar{{Name: "CONFIG_SPEC", ValueFrom: makeCMReference("ci-operator-master-configs", "org-other-master.yaml")}}
+	jobs := config.Presubmits{
+		"org/repo": {
+			*makeTestingPresubmit("pull-ci-org-repo-master-unit", "ci/prow/unit", nil, "master"),
+			*makeTestingPresubmit("pull-ci-org-repo-master-e2e", "ci/prow/e2e", []string{"--git-ref=org/repo@master"}, "master"),
+			*multiBranch,
+			*notCiop,
+		},
+		"org/other":   {*missingConfig},
+		"org/opt-out": {*makeTestingPresubmit("pull-ci-org-opt-out-master-unit", "ci/prow/unit", nil, "master")},
+	}
+	filter := JobFilter{ExcludedRepos: sets.NewString("org/opt-out")}
+	rehearsals, skipped := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, 123, Loggers{logrus.New(), logrus.New()}, false, filter, nil, nil)
+
+	if len(rehearsals) != 1 || rehearsals[0].Name != "rehearse-123-pull-ci-org-repo-master-unit" {
+		t.Errorf("expected only pull-ci-org-repo-master-unit to be rehearsed, got %d rehearsals", len(rehearsals))
+	}
+	expected := SkippedJobs{
+		{Repo: "org/opt-out", Job: "pull-ci-org-opt-out-master-unit", Reason: "repository opted out of rehearsals"},
+		{Repo: "org/other", Job: "pull-ci-org-other-master-unit", Reason: "ci-operator config file org-other-master.yaml was not found"},
+		{Repo: "org/repo", Job: "pull-ci-org-repo-master-e2e", Reason: "cannot rehearse jobs that call ci-operator with '--git-ref' arg"},
+		{Repo: "org/repo", Job: "pull-ci-org-repo-master-lint", Reason: "cannot rehearse jobs that have Command different from simple 'ci-operator'"},
+		{Repo: "org/repo", Job: "pull-ci-org-repo-multi-unit", Reason: "cannot rehearse jobs that run over multiple branches"},
+	}
+	if !reflect.DeepEqual(expected, skipped) {
+		t.Errorf("unexpected skipped jobs: %s", diff.ObjectReflectDiff(expected, skipped))
+	}
+}
+
 func TestInlineCiopConfig(t *testing.T) {
 	testTargetRepo := "org/repo"
 	testCiopConfigInfo := config.Info{
@@ -251,6 +329,7 @@ func TestMakeRehearsalPresubmit(t *testing.T) {
 
 	expectedPresubmit.Name = "rehearse-123-pull-ci-org-repo-branch-test"
 	expectedPresubmit.Labels = map[string]string{rehearseLabel: "123"}
+	expectedPresubmit.Annotations = map[string]string{rehearsalRepoAnnotation: "org/repo"}
 	expectedPresubmit.Spec.Containers[0].Args = []string{"arg1", "arg2", "--git-ref=org/repo@branch"}
 	expectedPresubmit.RerunCommand = "/test pj-rehearse"
 	expectedPresubmit.Context = "ci/rehearse/org/repo/branch/test"
@@ -263,6 +342,32 @@ func TestMakeRehearsalPresubmit(t *testing.T) {
 	if !equality.Semantic.DeepEqual(expectedPresubmit, rehearsal) {
 		t.Errorf("Expected rehearsal Presubmit differs:\n%s", diff.ObjectReflectDiff(expectedPresubmit, rehearsal))
 	}
+
+	sourcePresubmit.AlwaysRun = false
+	sourcePresubmit.RunIfChanged = "^pkg/"
+	rehearsal, err = makeRehearsalPresubmit(sourcePresubmit, testRepo, testPrNumber)
+	if err != nil {
+		t.Errorf("Unexpected error in makeRehearsalPresubmit: %v", err)
+	}
+	if rehearsal.RunIfChanged != "" {
+		t.Errorf("Expected rehearsal of a run_if_changed presubmit to have no run_if_changed, got %q", rehearsal.RunIfChanged)
+	}
+}
+
+func TestOverrideCiOperatorImage(t *testing.T) {
+	ciop := makeTestingPresubmit("pull-ci-org-repo-master-unit", "ci/prow/unit", nil, "master")
+	ciop.Spec.Containers[0].Image = "ci-operator:latest"
+	other := makeTestingPresubmit("pull-ci-org-repo-master-lint", "ci/prow/lint", nil, "master")
+	other.Spec.Containers[0].Command = []string{"make", "lint"}
+	other.Spec.Containers[0].Image = "golang:1.12"
+
+	OverrideCiOperatorImage([]*prowconfig.Presubmit{ciop, other}, "ci-operator:pr-123", logrus.NewEntry(logrus.New()))
+	if image := ciop.Spec.Containers[0].Image; image != "ci-operator:pr-123" {
+		t.Errorf("expected ci-operator image to be overridden, got %q", image)
+	}
+	if image := other.Spec.Containers[0].Image; image != "golang:1.12" {
+		t.Errorf("expected image of non-ci-operator container to be kept, got %q", image)
+	}
 }
 
 func makeTestingProwJob(namespace, jobName, context string, refs *pjapi.Refs, ciopArgs []string) *pjapi.ProwJob {
@@ -378,7 +483,7 @@ func TestExecuteJobsErrors(t *testing.T) {
 				return false, nil, nil
 			})
 
-			rehearsals := ConfigureRehearsalJobs(tc.jobs, testCiopConfigs, testPrNumber, testLoggers, true, nil, nil)
+			rehearsals, _ := ConfigureRehearsalJobs(tc.jobs, testCiopConfigs, testPrNumber, testLoggers, true, JobFilter{}, nil, nil)
 			executor := NewExecutor(rehearsals, testPrNumber, testRepoPath, testRefs, true, testLoggers, fakeclient)
 			_, err = executor.ExecuteJobs()
 
@@ -447,7 +552,7 @@ func TestExecuteJobsUnsuccessful(t *testing.T) {
 				return true, ret, nil
 			})
 
-			rehearsals := ConfigureRehearsalJobs(tc.jobs, testCiopConfigs, testPrNumber, testLoggers, true, nil, nil)
+			rehearsals, _ := ConfigureRehearsalJobs(tc.jobs, testCiopConfigs, testPrNumber, testLoggers, true, JobFilter{}, nil, nil)
 			executor := NewExecutor(rehearsals, testPrNumber, testRepoPath, testRefs, false, testLoggers, fakeclient)
 			success, _ := executor.ExecuteJobs()
 
@@ -552,7 +657,7 @@ func TestExecuteJobsPositive(t *testing.T) {
 			}
 			fakecs.Fake.PrependWatchReactor("prowjobs", makeSuccessfulFinishReactor(watcher, tc.jobs))
 
-			rehearsals := ConfigureRehearsalJobs(tc.jobs, testCiopConfigs, testPrNumber, testLoggers, true, nil, nil)
+			rehearsals, _ := ConfigureRehearsalJobs(tc.jobs, testCiopConfigs, testPrNumber, testLoggers, true, JobFilter{}, nil, nil)
 			executor := NewExecutor(rehearsals, testPrNumber, testRepoPath, testRefs, true, testLoggers, fakeclient)
 			success, err := executor.ExecuteJobs()
 
@@ -587,6 +692,117 @@ func TestExecuteJobsPositive(t *testing.T) {
 	}
 }
 
+func TestExecuteJobsPaused(t *testing.T) {
+	testPrNumber, testNamespace, testRepoPath, testRefs := makeTestData()
+	jobs := map[string][]prowconfig.Presubmit{"targetOrg/targetRepo": {
+		*makeTestingPresubmit("job1", "ci/prow/job1", []string{"arg1"}, "master"),
+		*makeTestingPresubmit("job2", "ci/prow/job2", []string{"arg1"}, "master"),
+	}}
+
+	testLoggers := Loggers{logrus.New(), logrus.New()}
+	fakecs := fake.NewSimpleClientset()
+	fakeclient := fakecs.ProwV1().ProwJobs(testNamespace)
+	rehearsals, _ := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, testPrNumber, testLoggers, true, JobFilter{}, nil, nil)
+	// paused rehearsals never finish, so waiting for them would not return
+	executor := NewExecutor(rehearsals, testPrNumber, testRepoPath, testRefs, false, testLoggers, fakeclient)
+	executor.Paused = true
+	success, err := executor.ExecuteJobs()
+	if err != nil || !success {
+		t.Fatalf("Expected ExecuteJobs() to succeed, got success=%t and error %v", success, err)
+	}
+
+	// a rehearsal which was already triggered and one for another PR are not listed
+	triggered := pjapi.ProwJob{ObjectMeta: metav1.ObjectMeta{Name: "triggered", Labels: map[string]string{rehearseLabel: strconv.Itoa(testPrNumber)}}}
+	otherPR := pjapi.ProwJob{ObjectMeta: metav1.ObjectMeta{Name: "other-pr", Labels: map[string]string{rehearseLabel: "456", RehearsalPausedLabel: "true"}}}
+	for _, pj := range []pjapi.ProwJob{triggered, otherPR} {
+		if _, err := fakeclient.Create(&pj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	paused, err := ListPausedRehearsals(fakeclient, testPrNumber)
+	if err != nil {
+		t.Fatalf("Expected ListPausedRehearsals() to not return error, returned %v", err)
+	}
+	var names []string
+	for _, pj := range paused {
+		names = append(names, pj.Spec.Job)
+	}
+	expected := []string{"rehearse-123-job1", "rehearse-123-job2"}
+	if !reflect.DeepEqual(expected, names) {
+		t.Errorf("Unexpected paused rehearsals: %s", diff.ObjectReflectDiff(expected, names))
+	}
+}
+
+func TestExecuteJobsMultiPullRefs(t *testing.T) {
+	testPrNumber, testNamespace, testRepoPath, _ := makeTestData()
+	targetRepo := "targetOrg/targetRepo"
+	batchRefs := &pjapi.Refs{
+		Org:     "openshift",
+		Repo:    "release",
+		BaseRef: "master",
+		BaseSHA: "baseSHA",
+		Pulls: []pjapi.Pull{
+			{Number: testPrNumber, Author: "author", SHA: "firstSHA"},
+			{Number: 456, Author: "other", SHA: "secondSHA"},
+		},
+	}
+	jobs := map[string][]prowconfig.Presubmit{targetRepo: {
+		*makeTestingPresubmit("job1", "ci/prow/job1", []string{"arg1"}, "master"),
+	}}
+
+	testLoggers := Loggers{logrus.New(), logrus.New()}
+	fakecs := fake.NewSimpleClientset()
+	fakeclient := fakecs.ProwV1().ProwJobs(testNamespace)
+	rehearsals, _ := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, testPrNumber, testLoggers, true, JobFilter{}, nil, nil)
+	executor := NewExecutor(rehearsals, testPrNumber, testRepoPath, batchRefs, true, testLoggers, fakeclient)
+	if _, err := executor.ExecuteJobs(); err != nil {
+		t.Fatalf("Expected ExecuteJobs() to not return error, returned %v", err)
+	}
+
+	createdJobs, err := fakeclient.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get expected ProwJobs from fake client")
+	}
+	expected := []pjapi.ProwJobSpec{makeTestingProwJob(testNamespace,
+		"rehearse-123-job1",
+		fmt.Sprintf("ci/rehearse/%s/master/job1", targetRepo),
+		batchRefs,
+		// all pulls are in the refs, but the rehearsal still tests one branch of the target repo
+		[]string{"arg1", fmt.Sprintf("--git-ref=%s@master", targetRepo)},
+	).Spec}
+	var created []pjapi.ProwJobSpec
+	for _, job := range createdJobs.Items {
+		created = append(created, job.Spec)
+	}
+	if !equality.Semantic.DeepEqual(expected, created) {
+		t.Errorf("Created ProwJobs differ from expected:\n%s", diff.ObjectReflectDiff(expected, created))
+	}
+}
+
+func TestExecuteJobsInvalidRefs(t *testing.T) {
+	testPrNumber, testNamespace, testRepoPath, _ := makeTestData()
+	invalidRefs := &pjapi.Refs{Org: "openshift", Repo: "release"}
+	jobs := map[string][]prowconfig.Presubmit{"targetOrg/targetRepo": {
+		*makeTestingPresubmit("job1", "ci/prow/job1", []string{"arg1"}, "master"),
+	}}
+
+	testLoggers := Loggers{logrus.New(), logrus.New()}
+	fakeclient := fake.NewSimpleClientset().ProwV1().ProwJobs(testNamespace)
+	rehearsals, _ := ConfigureRehearsalJobs(jobs, config.CompoundCiopConfig{}, testPrNumber, testLoggers, true, JobFilter{}, nil, nil)
+	executor := NewExecutor(rehearsals, testPrNumber, testRepoPath, invalidRefs, true, testLoggers, fakeclient)
+	if _, err := executor.ExecuteJobs(); err == nil {
+		t.Fatalf("Expected ExecuteJobs() to return error for invalid refs")
+	}
+
+	createdJobs, err := fakeclient.List(metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list ProwJobs from fake client")
+	}
+	if len(createdJobs.Items) != 0 {
+		t.Errorf("Expected no ProwJobs to be created, got %d", len(createdJobs.Items))
+	}
+}
+
 func TestWaitForJobs(t *testing.T) {
 	loggers := Loggers{logrus.New(), logrus.New()}
 	pjSuccess0 := pjapi.ProwJob{
@@ -703,6 +919,7 @@ func TestWaitForJobsRetries(t *testing.T) {
 	})
 
 	executor := NewExecutor(nil, 0, "", &pjapi.Refs{}, true, Loggers{logrus.New(), logrus.New()}, cs.ProwV1().ProwJobs("test"))
+	executor.watchBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Steps: 3}
 	success, err := executor.waitForJobs(sets.String{"j": {}}, "")
 	if err != nil {
 		t.Fatal(err)
@@ -712,6 +929,97 @@ func TestWaitForJobsRetries(t *testing.T) {
 	}
 }
 
+func TestWaitForJobsBackoff(t *testing.T) {
+	finished := func(name string, state pjapi.ProwJobState) *pjapi.ProwJob {
+		return &pjapi.ProwJob{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       pjapi.ProwJobSpec{Job: name},
+			Status:     pjapi.ProwJobStatus{State: state},
+		}
+	}
+	withEvents := func(pjs ...*pjapi.ProwJob) watch.Interface {
+		w := watch.NewFakeWithChanSize(len(pjs), true)
+		for _, pj := range pjs {
+			w.Modify(pj)
+		}
+		w.Stop()
+		return w
+	}
+	testCases := []struct {
+		id             string
+		watches        []func() (watch.Interface, error)
+		expectedErr    bool
+		expectedPass   bool
+		expectedCalls  int
+		expectedFail   []string
+		expectedPassed []string
+	}{{
+		id: "give up after consecutive failures",
+		watches: []func() (watch.Interface, error){
+			func() (watch.Interface, error) { return nil, fmt.Errorf("hiccup") },
+			func() (watch.Interface, error) { return watch.NewEmptyWatch(), nil },
+			func() (watch.Interface, error) { return nil, fmt.Errorf("hiccup") },
+		},
+		expectedErr:   true,
+		expectedCalls: 3,
+	}, {
+		id: "events reset the failure counter",
+		watches: []func() (watch.Interface, error){
+			func() (watch.Interface, error) { return nil, fmt.Errorf("hiccup") },
+			func() (watch.Interface, error) { return nil, fmt.Errorf("hiccup") },
+			func() (watch.Interface, error) { return withEvents(finished("a", pjapi.PendingState)), nil },
+			func() (watch.Interface, error) { return nil, fmt.Errorf("hiccup") },
+			func() (watch.Interface, error) { return nil, fmt.Errorf("hiccup") },
+			func() (watch.Interface, error) {
+				return withEvents(finished("a", pjapi.SuccessState), finished("b", pjapi.SuccessState)), nil
+			},
+		},
+		expectedPass:   true,
+		expectedCalls:  6,
+		expectedPassed: []string{"a", "b"},
+	}, {
+		id: "finished jobs are not processed again after reconnect",
+		watches: []func() (watch.Interface, error){
+			func() (watch.Interface, error) { return withEvents(finished("a", pjapi.FailureState)), nil },
+			func() (watch.Interface, error) {
+				return withEvents(finished("a", pjapi.FailureState), finished("b", pjapi.SuccessState)), nil
+			},
+		},
+		expectedCalls:  2,
+		expectedFail:   []string{"a"},
+		expectedPassed: []string{"b"},
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.id, func(t *testing.T) {
+			calls := 0
+			cs := fake.NewSimpleClientset()
+			cs.Fake.PrependWatchReactor("prowjobs", func(clientgo_testing.Action) (bool, watch.Interface, error) {
+				w, err := tc.watches[calls]()
+				calls++
+				return true, w, err
+			})
+			executor := NewExecutor(nil, 0, "", &pjapi.Refs{}, true, Loggers{logrus.New(), logrus.New()}, cs.ProwV1().ProwJobs("test"))
+			executor.watchBackoff = wait.Backoff{Duration: time.Millisecond, Factor: 2, Jitter: 0.1, Steps: 3}
+			success, err := executor.waitForJobs(sets.NewString("a", "b"), "")
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %t, got: %v", tc.expectedErr, err)
+			}
+			if calls != tc.expectedCalls {
+				t.Errorf("expected %d watch calls, got %d", tc.expectedCalls, calls)
+			}
+			if success != tc.expectedPass {
+				t.Errorf("expected success: %t, got %t", tc.expectedPass, success)
+			}
+			if !reflect.DeepEqual(tc.expectedFail, executor.Metrics.FailedRehearsals) {
+				t.Errorf("unexpected failed rehearsals: %s", diff.ObjectReflectDiff(tc.expectedFail, executor.Metrics.FailedRehearsals))
+			}
+			if !reflect.DeepEqual(tc.expectedPassed, executor.Metrics.PassedRehearsals) {
+				t.Errorf("unexpected passed rehearsals: %s", diff.ObjectReflectDiff(tc.expectedPassed, executor.Metrics.PassedRehearsals))
+			}
+		})
+	}
+}
+
 func TestWaitForJobsLog(t *testing.T) {
 	jobLogger, jobHook := logrustest.NewNullLogger()
 	dbgLogger, dbgHook := logrustest.NewNullLogger()
@@ -757,6 +1065,7 @@ func TestFilterJob(t *testing.T) {
 	testCases := []struct {
 		description    string
 		volumesAllowed bool
+		filter         JobFilter
 		valid          bool
 		crippleFunc    func(*prowconfig.Presubmit) *prowconfig.Presubmit
 	}{
@@ -774,6 +1083,55 @@ func TestFilterJob(t *testing.T) {
 				return j
 			},
 		},
+		{
+			description: "ci-operator job with a custom artifact dir",
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].Args = append(j.Spec.Containers[0].Args, "--artifact-dir=$(TEST_ARTIFACTS)")
+				return j
+			},
+		},
+		{
+			description: "ci-operator job not giving the PR author access to the namespace",
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].Args = append(j.Spec.Containers[0].Args, "--give-pr-author-access-to-namespace=false")
+				return j
+			},
+		},
+		{
+			description: "ci-operator job acquiring leases",
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].Args = append(j.Spec.Containers[0].Args, "--lease-server=http://boskos", "--lease-server-username=ci", "--lease-server-password-file=/etc/boskos/password")
+				j.Spec.Containers[0].VolumeMounts = append(j.Spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: "boskos", MountPath: "/etc/boskos", ReadOnly: true})
+				j.Spec.Volumes = append(j.Spec.Volumes, v1.Volume{Name: "boskos", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "boskos-credentials"}}})
+				return j
+			},
+		},
+		{
+			description: "ci-operator job mounting the pull secret of its build cluster",
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].VolumeMounts = append(j.Spec.Containers[0].VolumeMounts, v1.VolumeMount{Name: "pull-secret", MountPath: "/etc/pull-secret", ReadOnly: true})
+				j.Spec.Volumes = append(j.Spec.Volumes, v1.Volume{Name: "pull-secret", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: "registry-pull-credentials"}}})
+				return j
+			},
+		},
+		{
+			description: "ci-operator job mounting another volume named like a generated secret",
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Volumes = append(j.Spec.Volumes, v1.Volume{Name: "boskos", VolumeSource: v1.VolumeSource{ConfigMap: &v1.ConfigMapVolumeSource{}}})
+				return j
+			},
+		},
+		{
+			description: "ci-operator job with a custom artifact dir already using --git-ref",
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].Args = append(j.Spec.Containers[0].Args, "--artifact-dir=$(TEST_ARTIFACTS)", "--git-ref", "organization/repo@master")
+				return j
+			},
+		},
 		{
 			description: "jobs running over multiple branches",
 			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
@@ -781,6 +1139,13 @@ func TestFilterJob(t *testing.T) {
 				return j
 			},
 		},
+		{
+			description: "jobs running over all branches matching a glob",
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Brancher.Branches = []string{"release-4.*"}
+				return j
+			},
+		},
 		{
 			description: "jobs that need additional volumes mounted, not allowed",
 			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
@@ -797,15 +1162,68 @@ func TestFilterJob(t *testing.T) {
 				return j
 			},
 		},
+		{
+			description: "jobs with a node selector and tolerations",
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.NodeSelector = map[string]string{"pool": "heavy"}
+				j.Spec.Tolerations = []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpExists}}
+				return j
+			},
+		},
+		{
+			description: "jobs for an excluded branch",
+			filter:      JobFilter{ExcludedBranches: []*regexp.Regexp{regexp.MustCompile(`^release-3\.`)}},
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Brancher.Branches = []string{"^release-3.11$"}
+				return j
+			},
+		},
+		{
+			description: "jobs for a branch not matching any excluded pattern",
+			filter:      JobFilter{ExcludedBranches: []*regexp.Regexp{regexp.MustCompile(`^release-3\.`)}},
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Brancher.Branches = []string{"^release-4.1$"}
+				return j
+			},
+		},
+		{
+			description: "jobs for the cluster type rehearsals are restricted to",
+			filter:      JobFilter{ClusterType: "aws"},
+			valid:       true,
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].Env = []v1.EnvVar{{Name: "CLUSTER_TYPE", Value: "aws"}}
+				return j
+			},
+		},
+		{
+			description: "jobs for another cluster type than rehearsals are restricted to",
+			filter:      JobFilter{ClusterType: "aws"},
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				j.Spec.Containers[0].Env = []v1.EnvVar{{Name: "CLUSTER_TYPE", Value: "gcp"}}
+				return j
+			},
+		},
+		{
+			description: "jobs without a cluster type when rehearsals are restricted to one",
+			filter:      JobFilter{ClusterType: "aws"},
+			crippleFunc: func(j *prowconfig.Presubmit) *prowconfig.Presubmit {
+				return j
+			},
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.description, func(t *testing.T) {
 			basePresubmit := makeBasePresubmit()
 			tc.crippleFunc(basePresubmit)
-			err := filterJob(basePresubmit, tc.volumesAllowed)
+			err := filterJob(basePresubmit, tc.volumesAllowed, tc.filter)
 			if err == nil && !tc.valid {
 				t.Errorf("Expected filterJob() to return error")
 			}
+			if err != nil && tc.valid {
+				t.Errorf("Expected filterJob() to return no error, got %v", err)
+			}
 		})
 
 	}
@@ -961,3 +1379,333 @@ func createVolumesHelper(name, key string) []v1.Volume {
 
 	return volumes
 }
+
+func TestFilterJobsExcludedRepos(t *testing.T) {
+	job := func(name string) prowconfig.Presubmit {
+		return prowconfig.Presubmit{
+			JobBase: prowconfig.JobBase{
+				Name: name,
+				Spec: &v1.PodSpec{Containers: []v1.Container{{Command: []string{"ci-operator"}}}},
+			},
+			Brancher: prowconfig.Brancher{Branches: []string{"^master$"}},
+		}
+	}
+	changed := map[string][]prowconfig.Presubmit{
+		"org/repo":    {job("pull-ci-org-repo-master-unit")},
+		"org/opt-out": {job("pull-ci-org-opt-out-master-unit"), job("pull-ci-org-opt-out-master-e2e")},
+	}
+	filter := JobFilter{ExcludedRepos: sets.NewString("org/opt-out")}
+
+	filtered, skipped := filterJobs(changed, false, filter, logrus.New())
+	expected := config.Presubmits{"org/repo": {job("pull-ci-org-repo-master-unit")}}
+	if !equality.Semantic.DeepEqual(expected, filtered) {
+		t.Errorf("unexpected filtered jobs: %s", diff.ObjectReflectDiff(expected, filtered))
+	}
+	skipped.sort()
+	expectedSkipped := SkippedJobs{
+		{Repo: "org/opt-out", Job: "pull-ci-org-opt-out-master-e2e", Reason: "repository opted out of rehearsals"},
+		{Repo: "org/opt-out", Job: "pull-ci-org-opt-out-master-unit", Reason: "repository opted out of rehearsals"},
+	}
+	if !reflect.DeepEqual(expectedSkipped, skipped) {
+		t.Errorf("unexpected skipped jobs: %s", diff.ObjectReflectDiff(expectedSkipped, skipped))
+	}
+}
+
+func TestLoadExcludedRepos(t *testing.T) {
+	testCases := []struct {
+		description string
+		content     string
+		expected    sets.String
+		expectedErr bool
+	}{{
+		description: "names, comments and empty lines",
+		content:     "# migrating in\norg/repo\n\n  org/other  \n",
+		expected:    sets.NewString("org/repo", "org/other"),
+	}, {
+		description: "empty file",
+		expected:    sets.NewString(),
+	}, {
+		description: "org without repo",
+		content:     "org\n",
+		expectedErr: true,
+	}, {
+		description: "too many path elements",
+		content:     "org/repo/branch\n",
+		expectedErr: true,
+	}}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "excluded-repos")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "excluded")
+			if err := ioutil.WriteFile(path, []byte(tc.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			repos, err := LoadExcludedRepos(path)
+			if tc.expectedErr != (err != nil) {
+				t.Fatalf("expected error: %t, got: %v", tc.expectedErr, err)
+			}
+			if !tc.expectedErr && !reflect.DeepEqual(tc.expected, repos) {
+				t.Errorf("unexpected repos: %s", diff.ObjectReflectDiff(tc.expected, repos))
+			}
+		})
+	}
+}
+
+func TestTargetJobFields(t *testing.T) {
+	withConfig := makeBasePresubmit()
+	withConfig.Spec.Containers[0].Env = []v1.EnvVar{
+		{Name: "CONFIG_SPEC", ValueFrom: makeCMReference("ci-operator-master-configs", "org-repo-master.yaml")},
+	}
+	multipleBranches := makeBasePresubmit()
+	multipleBranches.Branches = []string{"master", "release-.*"}
+
+	testCases := []struct {
+		description string
+		job         *prowconfig.Presubmit
+		expected    logrus.Fields
+	}{
+		{
+			description: "job without ci-operator config ConfigMap",
+			job:         makeBasePresubmit(),
+			expected:    logrus.Fields{logTargetRepo: "org/repo", logTargetBranch: "master", logTargetJob: "pull-ci-organization-repo-master-test"},
+		},
+		{
+			description: "job with ci-operator config ConfigMap",
+			job:         withConfig,
+			expected:    logrus.Fields{logTargetRepo: "org/repo", logTargetBranch: "master", logTargetJob: "pull-ci-organization-repo-master-test", logCiopConfigFile: "org-repo-master.yaml"},
+		},
+		{
+			description: "job with multiple branches",
+			job:         multipleBranches,
+			expected:    logrus.Fields{logTargetRepo: "org/repo", logTargetBranch: "master,release-.*", logTargetJob: "pull-ci-organization-repo-master-test"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			if fields := targetJobFields("org/repo", tc.job); !equality.Semantic.DeepEqual(tc.expected, fields) {
+				t.Errorf("unexpected log fields:\n%s", diff.ObjectReflectDiff(tc.expected, fields))
+			}
+		})
+	}
+}
+
+func makeTemplatePresubmit(name, clusterType, templateFile string) prowconfig.Presubmit {
+	job := makeBasePresubmit()
+	job.Name = name
+	job.Spec.Containers[0].Env = []v1.EnvVar{{Name: clusterTypeEnvName, Value: clusterType}}
+	job.Spec.Containers[0].VolumeMounts = []v1.VolumeMount{{Name: "job-definition", MountPath: "/tmp/" + templateFile, SubPath: templateFile}}
+	return *job
+}
+
+func TestPickTemplateJob(t *testing.T) {
+	presubmits := map[string][]prowconfig.Presubmit{
+		"org/b": {
+			makeTemplatePresubmit("b-2", "aws", "template.yaml"),
+			makeTemplatePresubmit("b-1", "aws", "template.yaml"),
+			makeTemplatePresubmit("b-gcp", "gcp", "template.yaml"),
+		},
+		"org/a": {
+			makeTemplatePresubmit("a-1", "aws", "template.yaml"),
+			makeTemplatePresubmit("a-other", "aws", "other.yaml"),
+		},
+	}
+	reordered := map[string][]prowconfig.Presubmit{
+		"org/b": {presubmits["org/b"][1], presubmits["org/b"][2], presubmits["org/b"][0]},
+		"org/a": {presubmits["org/a"][1], presubmits["org/a"][0]},
+	}
+	candidates := sets.NewString("a-1", "b-1", "b-2")
+
+	picked := sets.NewString()
+	for i := 0; i < 30; i++ {
+		seed := strconv.Itoa(i)
+		repo, job := pickTemplateJob(presubmits, "template.yaml", "aws", seed)
+		if job == nil {
+			t.Fatalf("seed %s: expected a job to be picked", seed)
+		}
+		if !candidates.Has(job.Name) {
+			t.Errorf("seed %s: picked job %s which does not use the template with the cluster type", seed, job.Name)
+		}
+		if repo != "org/"+job.Name[:1] {
+			t.Errorf("seed %s: picked job %s with wrong repo %s", seed, job.Name, repo)
+		}
+		if _, again := pickTemplateJob(reordered, "template.yaml", "aws", seed); again == nil || again.Name != job.Name {
+			t.Errorf("seed %s: picked a different job when the jobs were ordered differently", seed)
+		}
+		picked.Insert(job.Name)
+	}
+	if !picked.Equal(candidates) {
+		t.Errorf("expected different seeds to pick all candidates, picked only %v", picked.List())
+	}
+
+	if repo, job := pickTemplateJob(presubmits, "missing.yaml", "aws", "1"); job != nil {
+		t.Errorf("expected no job to be picked, got %s/%s", repo, job.Name)
+	}
+}
+
+func TestSampleIdenticalChanges(t *testing.T) {
+	makeJob := func(name, clusterType string) prowconfig.Presubmit {
+		return makeTemplatePresubmit(name, clusterType, "template.yaml")
+	}
+	toBeRehearsed := config.Presubmits{
+		"org/a": {makeJob("a-aws-1", "aws"), makeJob("a-aws-2", "aws"), makeJob("a-gcp", "gcp"), makeJob("a-other", "aws")},
+		"org/b": {makeJob("b-aws-1", "aws"), makeJob("b-aws-2", "aws"), makeJob("b-unknown", "")},
+	}
+	bump := []string{".Spec.Containers[0].Resources.Requests['cpu']: a: '10m' b: '100m'"}
+	details := map[string][]string{
+		"a-aws-1": bump, "a-aws-2": bump, "a-gcp": bump,
+		"b-aws-1": bump, "b-aws-2": bump,
+		"a-other": {"ci-operator config org-a-master.yaml changed"},
+	}
+	loggers := Loggers{logrus.New(), logrus.New()}
+
+	jobNames := func(presubmits config.Presubmits) sets.String {
+		names := sets.NewString()
+		for _, jobs := range presubmits {
+			for _, job := range jobs {
+				names.Insert(job.Name)
+			}
+		}
+		return names
+	}
+
+	sampled, skipped := SampleIdenticalChanges(toBeRehearsed, details, 5, nil, false, JobFilter{}, loggers, 123, "")
+	if names := jobNames(sampled); !names.Equal(jobNames(toBeRehearsed)) || len(skipped) != 0 {
+		t.Errorf("expected no sampling under the threshold, got %v and skipped %v", names.List(), skipped)
+	}
+
+	picked := map[string]sets.String{}
+	for i := 0; i < 30; i++ {
+		seed := strconv.Itoa(i)
+		sampled, skipped := SampleIdenticalChanges(toBeRehearsed, details, 4, nil, false, JobFilter{}, loggers, 123, seed)
+		names := jobNames(sampled)
+		// one job for each repository and cluster type, and all jobs changed differently
+		for _, always := range []string{"a-gcp", "a-other", "b-unknown"} {
+			if !names.Has(always) {
+				t.Errorf("seed %s: expected %s to be kept, got %v", seed, always, names.List())
+			}
+		}
+		if names.Len() != 5 || len(skipped) != 2 {
+			t.Errorf("seed %s: expected 5 jobs to be kept and 2 skipped, got %v and %v", seed, names.List(), skipped)
+		}
+		for _, job := range skipped {
+			if names.Has(job.Job) {
+				t.Errorf("seed %s: job %s was both kept and skipped", seed, job.Job)
+			}
+		}
+		again, _ := SampleIdenticalChanges(toBeRehearsed, details, 4, nil, false, JobFilter{}, loggers, 123, seed)
+		if !reflect.DeepEqual(sampled, again) {
+			t.Errorf("seed %s: picked different jobs with the same seed", seed)
+		}
+		for _, repo := range []string{"a", "b"} {
+			if picked[repo] == nil {
+				picked[repo] = sets.NewString()
+			}
+			picked[repo].Insert(names.Intersection(sets.NewString(repo+"-aws-1", repo+"-aws-2")).List()...)
+		}
+	}
+	for _, repo := range []string{"a", "b"} {
+		if picked[repo].Len() != 2 {
+			t.Errorf("expected different seeds to pick all candidates in org/%s, picked only %v", repo, picked[repo].List())
+		}
+	}
+
+	// jobs which are not rehearsed are never picked, but kept for ConfigureRehearsalJobs to report
+	excluded := JobFilter{ExcludedBranches: []*regexp.Regexp{regexp.MustCompile("^excluded$")}}
+	unrehearsable := makeJob("b-aws-0", "aws")
+	unrehearsable.Branches = []string{"excluded"}
+	withUnrehearsable := config.Presubmits{"org/b": append([]prowconfig.Presubmit{unrehearsable}, toBeRehearsed["org/b"]...)}
+	details["b-aws-0"] = bump
+	for i := 0; i < 30; i++ {
+		seed := strconv.Itoa(i)
+		sampled, skipped := SampleIdenticalChanges(withUnrehearsable, details, 1, nil, false, excluded, loggers, 123, seed)
+		names := jobNames(sampled)
+		if !names.Has("b-aws-0") || names.Intersection(sets.NewString("b-aws-1", "b-aws-2")).Len() != 1 {
+			t.Errorf("seed %s: expected the unrehearsable job and one rehearsable job to be kept, got %v", seed, names.List())
+		}
+		for _, job := range skipped {
+			if strings.Contains(job.Reason, "b-aws-0") {
+				t.Errorf("seed %s: job %s reported as represented by the unrehearsable job", seed, job.Job)
+			}
+		}
+	}
+}
+
+func TestUncoveredTemplateClusterTypes(t *testing.T) {
+	templates := []config.ConfigMapSource{{Filename: "templates/template.yaml"}}
+	presubmits := map[string][]prowconfig.Presubmit{
+		"org/a": {
+			makeTemplatePresubmit("a-aws", "aws", "template.yaml"),
+			makeTemplatePresubmit("a-azure", "azure4", "template.yaml"),
+			makeTemplatePresubmit("a-ovirt", "ovirt", "other.yaml"),
+		},
+		"org/b": {
+			makeTemplatePresubmit("b-ovirt", "ovirt", "template.yaml"),
+		},
+	}
+
+	expected := []string{"azure4 (template template.yaml)", "ovirt (template template.yaml)"}
+	if uncovered := UncoveredTemplateClusterTypes(templates, config.Presubmits{}, presubmits); !reflect.DeepEqual(expected, uncovered) {
+		t.Errorf("unexpected uncovered cluster types: %s", diff.ObjectReflectDiff(expected, uncovered))
+	}
+
+	// jobs selected for other reasons cover their cluster type
+	toBeRehearsed := config.Presubmits{"org/b": presubmits["org/b"]}
+	expected = []string{"azure4 (template template.yaml)"}
+	if uncovered := UncoveredTemplateClusterTypes(templates, toBeRehearsed, presubmits); !reflect.DeepEqual(expected, uncovered) {
+		t.Errorf("unexpected uncovered cluster types: %s", diff.ObjectReflectDiff(expected, uncovered))
+	}
+
+	if uncovered := UncoveredTemplateClusterTypes(nil, config.Presubmits{}, presubmits); len(uncovered) != 0 {
+		t.Errorf("expected no uncovered cluster types without changed templates, got %v", uncovered)
+	}
+}
+
+func TestRehearsalName(t *testing.T) {
+	testCases := []struct {
+		description       string
+		source            string
+		expected          string
+		expectedTruncated bool
+	}{
+		{
+			description: "short name is only prefixed",
+			source:      "pull-ci-org-repo-branch-test",
+			expected:    "rehearse-123-pull-ci-org-repo-branch-test",
+		},
+		{
+			description: "name of maximal length is only prefixed",
+			source:      "pull-ci-org-repo-branch-" + strings.Repeat("x", 26),
+			expected:    "rehearse-123-pull-ci-org-repo-branch-" + strings.Repeat("x", 26),
+		},
+		{
+			description:       "long name is truncated and hashed",
+			source:            "pull-ci-org-repo-branch-" + strings.Repeat("x", 27),
+			expected:          "rehearse-123-pull-ci-org-repo-branch-" + strings.Repeat("x", 17) + "-153d99fa",
+			expectedTruncated: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			name, truncated := rehearsalName(tc.source, 123)
+			if name != tc.expected {
+				t.Errorf("expected name %q, got %q", tc.expected, name)
+			}
+			if truncated != tc.expectedTruncated {
+				t.Errorf("expected truncated to be %t, got %t", tc.expectedTruncated, truncated)
+			}
+			if len(name) > 63 {
+				t.Errorf("name %q is longer than 63 characters", name)
+			}
+		})
+	}
+
+	first, _ := rehearsalName("pull-ci-org-repo-branch-"+strings.Repeat("x", 40)+"-aws", 123)
+	second, _ := rehearsalName("pull-ci-org-repo-branch-"+strings.Repeat("x", 40)+"-gcp", 123)
+	if first == second {
+		t.Errorf("expected names differing past the limit to be truncated differently, both are %q", first)
+	}
+}
@@ -0,0 +1,210 @@
+package rehearse
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+// reasonDescriptions maps reasons recorded as rehearsal opportunities to
+// descriptions readable by humans
+var reasonDescriptions = map[string]string{
+	"direct-change":             "changed job",
+	"ci-operator-config-change": "changed ci-operator config",
+	"templates-change":          "changed template",
+	"cluster-profile-change":    "changed cluster profile",
+}
+
+// PlannedRehearsal describes a single job that would be rehearsed
+type PlannedRehearsal struct {
+	// Repo is the org/repo the rehearsed job targets
+	Repo string
+	// Source is the name of the rehearsed job
+	Source string
+	// Reasons holds why the job was selected for rehearsal
+	Reasons []string
+	// Details describes the changes for which the job was selected
+	Details []string
+	// Name is the name of the rehearsal job
+	Name string
+	// Context is the context the rehearsal job reports to
+	Context string
+}
+
+// Plan describes all jobs that would be rehearsed for a PR, sorted by the
+// repository they target and then by name
+type Plan []PlannedRehearsal
+
+// NewPlan creates a plan from configured rehearsal jobs, the reasons for
+// which the source jobs were selected and the descriptions of the changes
+// that selected them, both keyed by source job names
+func NewPlan(rehearsals []*prowconfig.Presubmit, prNumber int, opportunities, details map[string][]string) Plan {
+	prefix := fmt.Sprintf("rehearse-%d-", prNumber)
+	plan := Plan{}
+	for _, rehearsal := range rehearsals {
+		source := strings.TrimPrefix(rehearsal.Name, prefix)
+		if name, truncated := rehearsal.Annotations[rehearsalSourceAnnotation]; truncated {
+			source = name
+		}
+		var reasons []string
+		seen := map[string]bool{}
+		for _, reason := range opportunities[source] {
+			if seen[reason] {
+				continue
+			}
+			seen[reason] = true
+			if description, ok := reasonDescriptions[reason]; ok {
+				reason = description
+			}
+			reasons = append(reasons, reason)
+		}
+		plan = append(plan, PlannedRehearsal{
+			Repo:    rehearsal.Annotations[rehearsalRepoAnnotation],
+			Source:  source,
+			Reasons: reasons,
+			Details: details[source],
+			Name:    rehearsal.Name,
+			Context: rehearsal.Context,
+		})
+	}
+	sort.Slice(plan, func(i, j int) bool {
+		if plan[i].Repo != plan[j].Repo {
+			return plan[i].Repo < plan[j].Repo
+		}
+		return plan[i].Source < plan[j].Source
+	})
+	return plan
+}
+
+// byRepo splits the plan into parts for single repositories
+func (p Plan) byRepo() []Plan {
+	var parts []Plan
+	for i, planned := range p {
+		if i == 0 || planned.Repo != p[i-1].Repo {
+			parts = append(parts, Plan{})
+		}
+		parts[len(parts)-1] = append(parts[len(parts)-1], planned)
+	}
+	return parts
+}
+
+// Markdown renders the plan as Markdown tables for each repository, suitable
+// for a PR comment
+func (p Plan) Markdown() string {
+	if len(p) == 0 {
+		return "No jobs would be rehearsed.\n"
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "The following %d jobs would be rehearsed:\n", len(p))
+	for _, part := range p.byRepo() {
+		fmt.Fprintf(&out, "\n#### %s\n\n", part[0].Repo)
+		fmt.Fprintln(&out, "| Job | Reason | Rehearsal | Context |")
+		fmt.Fprintln(&out, "| --- | --- | --- | --- |")
+		for _, planned := range part {
+			fmt.Fprintf(&out, "| `%s` | %s | `%s` | `%s` |\n", planned.Source, strings.Join(planned.Reasons, ", "), planned.Name, planned.Context)
+		}
+		part.detailsMarkdown(&out)
+	}
+	return out.String()
+}
+
+// detailsMarkdown renders the changes for which the jobs in the plan were
+// selected as a Markdown list, if there are any
+func (p Plan) detailsMarkdown(out *bytes.Buffer) {
+	var lines []string
+	for _, planned := range p {
+		for _, detail := range planned.Details {
+			lines = append(lines, fmt.Sprintf("- `%s`: `%s`", planned.Source, strings.Replace(detail, "`", "'", -1)))
+		}
+	}
+	if len(lines) == 0 {
+		return
+	}
+	fmt.Fprint(out, "\nChanges:\n\n")
+	fmt.Fprintln(out, strings.Join(lines, "\n"))
+}
+
+// ResultsMarkdown renders the results of executing the plan as Markdown
+// tables for each repository, suitable for a PR comment
+func (p Plan) ResultsMarkdown(execution *ExecutionMetrics) string {
+	if len(p) == 0 {
+		return "No jobs were rehearsed.\n"
+	}
+	submitted := sets.NewString(execution.SubmittedRehearsals...)
+	passed := sets.NewString(execution.PassedRehearsals...)
+	failed := sets.NewString(execution.FailedRehearsals...)
+	var out bytes.Buffer
+	for i, part := range p.byRepo() {
+		if i > 0 {
+			fmt.Fprintln(&out)
+		}
+		fmt.Fprintf(&out, "#### %s\n\n", part[0].Repo)
+		fmt.Fprintln(&out, "| Job | Result |")
+		fmt.Fprintln(&out, "| --- | --- |")
+		for _, planned := range part {
+			result := "not submitted"
+			switch {
+			case failed.Has(planned.Name):
+				result = "failed"
+			case passed.Has(planned.Name):
+				result = "passed"
+			case submitted.Has(planned.Name):
+				result = "unfinished"
+			}
+			fmt.Fprintf(&out, "| `%s` | %s |\n", planned.Source, result)
+		}
+	}
+	return out.String()
+}
+
+// SkippedJob describes a job that was selected for rehearsal, but could not
+// be rehearsed
+type SkippedJob struct {
+	// Repo is the org/repo the job targets
+	Repo string
+	// Job is the name of the job
+	Job string
+	// Reason explains why the job was not rehearsed
+	Reason string
+}
+
+// SkippedJobs holds the jobs that were not rehearsed, sorted by the
+// repository they target and then by name
+type SkippedJobs []SkippedJob
+
+func (s SkippedJobs) sort() {
+	sort.SliceStable(s, func(i, j int) bool {
+		if s[i].Repo != s[j].Repo {
+			return s[i].Repo < s[j].Repo
+		}
+		return s[i].Job < s[j].Job
+	})
+}
+
+// Add returns the skipped jobs together with other skipped jobs, sorted
+func (s SkippedJobs) Add(other SkippedJobs) SkippedJobs {
+	all := append(append(SkippedJobs{}, s...), other...)
+	all.sort()
+	return all
+}
+
+// Markdown renders the skipped jobs as a Markdown table, suitable for a PR
+// comment, so that authors know which of their changed jobs were not
+// rehearsed. There is nothing to render when no jobs were skipped.
+func (s SkippedJobs) Markdown() string {
+	if len(s) == 0 {
+		return ""
+	}
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "The following %d changed jobs were NOT rehearsed:\n\n", len(s))
+	fmt.Fprintln(&out, "| Repository | Job | Reason |")
+	fmt.Fprintln(&out, "| --- | --- | --- |")
+	for _, skipped := range s {
+		fmt.Fprintf(&out, "| %s | `%s` | %s |\n", skipped.Repo, skipped.Job, skipped.Reason)
+	}
+	return out.String()
+}
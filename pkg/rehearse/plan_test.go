@@ -0,0 +1,164 @@
+package rehearse
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+)
+
+func TestPlan(t *testing.T) {
+	rehearsals := []*prowconfig.Presubmit{{
+		JobBase:  prowconfig.JobBase{Name: "rehearse-123-pull-ci-org-repo-master-unit", Annotations: map[string]string{rehearsalRepoAnnotation: "org/repo"}},
+		Reporter: prowconfig.Reporter{Context: "ci/rehearse/org/repo/master/unit"},
+	}, {
+		JobBase:  prowconfig.JobBase{Name: "rehearse-123-pull-ci-org-other-master-unit", Annotations: map[string]string{rehearsalRepoAnnotation: "org/other"}},
+		Reporter: prowconfig.Reporter{Context: "ci/rehearse/org/other/master/unit"},
+	}, {
+		JobBase:  prowconfig.JobBase{Name: "rehearse-123-pull-ci-org-repo-master-e2e", Annotations: map[string]string{rehearsalRepoAnnotation: "org/repo"}},
+		Reporter: prowconfig.Reporter{Context: "ci/rehearse/org/repo/master/e2e"},
+	}}
+	opportunities := map[string][]string{
+		"pull-ci-org-other-master-unit": {"direct-change"},
+		"pull-ci-org-repo-master-unit":  {"direct-change", "ci-operator-config-change", "direct-change"},
+		"pull-ci-org-repo-master-e2e":   {"templates-change", "unknown-change"},
+		"pull-ci-org-repo-master-lint":  {"direct-change"},
+		"rehearse-123-pull-ci-org-repo": {"direct-change"},
+	}
+
+	details := map[string][]string{
+		"pull-ci-org-repo-master-unit": {".Spec.Containers[0].Args[0]: a: '--target=unit' b: '--target=`unit`'", "ci-operator config org-repo-master.yaml changed"},
+		"pull-ci-org-repo-master-lint": {"ci-operator config org-repo-master.yaml changed"},
+	}
+
+	plan := NewPlan(rehearsals, 123, opportunities, details)
+	expected := Plan{{
+		Repo:    "org/other",
+		Source:  "pull-ci-org-other-master-unit",
+		Reasons: []string{"changed job"},
+		Name:    "rehearse-123-pull-ci-org-other-master-unit",
+		Context: "ci/rehearse/org/other/master/unit",
+	}, {
+		Repo:    "org/repo",
+		Source:  "pull-ci-org-repo-master-e2e",
+		Reasons: []string{"changed template", "unknown-change"},
+		Name:    "rehearse-123-pull-ci-org-repo-master-e2e",
+		Context: "ci/rehearse/org/repo/master/e2e",
+	}, {
+		Repo:    "org/repo",
+		Source:  "pull-ci-org-repo-master-unit",
+		Reasons: []string{"changed job", "changed ci-operator config"},
+		Details: details["pull-ci-org-repo-master-unit"],
+		Name:    "rehearse-123-pull-ci-org-repo-master-unit",
+		Context: "ci/rehearse/org/repo/master/unit",
+	}}
+	if !reflect.DeepEqual(expected, plan) {
+		t.Fatalf("unexpected plan: %s", diff.ObjectReflectDiff(expected, plan))
+	}
+
+	expectedMarkdown := "The following 3 jobs would be rehearsed:\n\n" +
+		"#### org/other\n\n" +
+		"| Job | Reason | Rehearsal | Context |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| `pull-ci-org-other-master-unit` | changed job | `rehearse-123-pull-ci-org-other-master-unit` | `ci/rehearse/org/other/master/unit` |\n" +
+		"\n#### org/repo\n\n" +
+		"| Job | Reason | Rehearsal | Context |\n" +
+		"| --- | --- | --- | --- |\n" +
+		"| `pull-ci-org-repo-master-e2e` | changed template, unknown-change | `rehearse-123-pull-ci-org-repo-master-e2e` | `ci/rehearse/org/repo/master/e2e` |\n" +
+		"| `pull-ci-org-repo-master-unit` | changed job, changed ci-operator config | `rehearse-123-pull-ci-org-repo-master-unit` | `ci/rehearse/org/repo/master/unit` |\n" +
+		"\nChanges:\n\n" +
+		"- `pull-ci-org-repo-master-unit`: `.Spec.Containers[0].Args[0]: a: '--target=unit' b: '--target='unit''`\n" +
+		"- `pull-ci-org-repo-master-unit`: `ci-operator config org-repo-master.yaml changed`\n"
+	if markdown := plan.Markdown(); markdown != expectedMarkdown {
+		t.Errorf("unexpected markdown: %s", diff.StringDiff(expectedMarkdown, markdown))
+	}
+
+	if markdown := NewPlan(nil, 123, opportunities, nil).Markdown(); markdown != "No jobs would be rehearsed.\n" {
+		t.Errorf("unexpected markdown for empty plan: %q", markdown)
+	}
+}
+
+func TestPlanResultsMarkdown(t *testing.T) {
+	plan := Plan{
+		{Repo: "org/other", Source: "pull-ci-org-other-master-unit", Name: "rehearse-123-pull-ci-org-other-master-unit"},
+		{Repo: "org/repo", Source: "pull-ci-org-repo-master-e2e", Name: "rehearse-123-pull-ci-org-repo-master-e2e"},
+		{Repo: "org/repo", Source: "pull-ci-org-repo-master-lint", Name: "rehearse-123-pull-ci-org-repo-master-lint"},
+		{Repo: "org/repo", Source: "pull-ci-org-repo-master-unit", Name: "rehearse-123-pull-ci-org-repo-master-unit"},
+		{Repo: "other/repo", Source: "pull-ci-other-repo-master-unit", Name: "rehearse-123-pull-ci-other-repo-master-unit"},
+	}
+	execution := &ExecutionMetrics{
+		SubmittedRehearsals: []string{
+			"rehearse-123-pull-ci-org-other-master-unit",
+			"rehearse-123-pull-ci-org-repo-master-e2e",
+			"rehearse-123-pull-ci-org-repo-master-unit",
+			"rehearse-123-pull-ci-other-repo-master-unit",
+		},
+		FailedRehearsals: []string{"rehearse-123-pull-ci-org-repo-master-e2e"},
+		PassedRehearsals: []string{"rehearse-123-pull-ci-org-other-master-unit", "rehearse-123-pull-ci-org-repo-master-unit"},
+	}
+	expected := "#### org/other\n\n" +
+		"| Job | Result |\n" +
+		"| --- | --- |\n" +
+		"| `pull-ci-org-other-master-unit` | passed |\n" +
+		"\n#### org/repo\n\n" +
+		"| Job | Result |\n" +
+		"| --- | --- |\n" +
+		"| `pull-ci-org-repo-master-e2e` | failed |\n" +
+		"| `pull-ci-org-repo-master-lint` | not submitted |\n" +
+		"| `pull-ci-org-repo-master-unit` | passed |\n" +
+		"\n#### other/repo\n\n" +
+		"| Job | Result |\n" +
+		"| --- | --- |\n" +
+		"| `pull-ci-other-repo-master-unit` | unfinished |\n"
+	if markdown := plan.ResultsMarkdown(execution); markdown != expected {
+		t.Errorf("unexpected markdown: %s", diff.StringDiff(expected, markdown))
+	}
+}
+
+func TestPlanTruncatedNames(t *testing.T) {
+	source := "pull-ci-organization-repository-master-e2e-aws-with-a-very-long-name"
+	name, _ := rehearsalName(source, 123)
+	rehearsals := []*prowconfig.Presubmit{{
+		JobBase: prowconfig.JobBase{
+			Name:        name,
+			Annotations: map[string]string{rehearsalSourceAnnotation: source},
+		},
+	}}
+
+	plan := NewPlan(rehearsals, 123, map[string][]string{source: {"direct-change"}}, nil)
+	expected := Plan{{Source: source, Reasons: []string{"changed job"}, Name: name}}
+	if !reflect.DeepEqual(expected, plan) {
+		t.Errorf("unexpected plan: %s", diff.ObjectReflectDiff(expected, plan))
+	}
+}
+
+func TestSkippedJobsMarkdown(t *testing.T) {
+	skipped := SkippedJobs{
+		{Repo: "org/other", Job: "pull-ci-org-other-master-unit", Reason: "repository opted out of rehearsals"},
+		{Repo: "org/repo", Job: "pull-ci-org-repo-multi-unit", Reason: "cannot rehearse jobs that run over multiple branches"},
+	}
+	expected := "The following 2 changed jobs were NOT rehearsed:\n\n" +
+		"| Repository | Job | Reason |\n" +
+		"| --- | --- | --- |\n" +
+		"| org/other | `pull-ci-org-other-master-unit` | repository opted out of rehearsals |\n" +
+		"| org/repo | `pull-ci-org-repo-multi-unit` | cannot rehearse jobs that run over multiple branches |\n"
+	if markdown := skipped.Markdown(); markdown != expected {
+		t.Errorf("unexpected markdown: %s", diff.StringDiff(expected, markdown))
+	}
+	if markdown := SkippedJobs(nil).Markdown(); markdown != "" {
+		t.Errorf("unexpected markdown without skipped jobs: %q", markdown)
+	}
+}
+
+func TestSkippedJobsAdd(t *testing.T) {
+	skipped := SkippedJobs{{Repo: "org/repo", Job: "b"}, {Repo: "org/repo", Job: "d"}}
+	all := skipped.Add(SkippedJobs{{Repo: "org/repo", Job: "c"}, {Repo: "org/other", Job: "a"}})
+	expected := SkippedJobs{{Repo: "org/other", Job: "a"}, {Repo: "org/repo", Job: "b"}, {Repo: "org/repo", Job: "c"}, {Repo: "org/repo", Job: "d"}}
+	if !reflect.DeepEqual(expected, all) {
+		t.Errorf("unexpected skipped jobs: %s", diff.ObjectReflectDiff(expected, all))
+	}
+	if len(skipped) != 2 {
+		t.Errorf("expected the original skipped jobs to stay unchanged, got %v", skipped)
+	}
+}
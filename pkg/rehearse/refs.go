@@ -0,0 +1,63 @@
+package rehearse
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ghodss/yaml"
+
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+)
+
+// ValidateRefs checks that refs can be used to submit rehearsals: they need to
+// identify the repository, the base ref and its revision, which changes are
+// compared against, and each pull needs a revision and a unique number. Refs
+// without pulls are valid, which allows rehearsing against a branch, a tag or
+// a specific revision.
+//
+// Rehearsals of jobs for other repositories are pointed at their target
+// branch with `--git-ref`, which ci-operator uses instead of these refs. With
+// batch refs holding multiple pulls, all of them are merged into the checkout
+// of the release repository, but `--git-ref` still names one branch of the
+// target repository, never the pulls.
+func ValidateRefs(refs *pjapi.Refs) error {
+	if refs == nil {
+		return fmt.Errorf("refs must be provided")
+	}
+	if refs.Org == "" || refs.Repo == "" {
+		return fmt.Errorf("refs must specify both org and repo, got %q and %q", refs.Org, refs.Repo)
+	}
+	if refs.BaseRef == "" {
+		return fmt.Errorf("refs must specify the base ref")
+	}
+	if refs.BaseSHA == "" {
+		return fmt.Errorf("refs must specify the revision of the base ref")
+	}
+	seen := map[int]bool{}
+	for _, pull := range refs.Pulls {
+		if pull.SHA == "" {
+			return fmt.Errorf("pull %d does not specify a revision", pull.Number)
+		}
+		if seen[pull.Number] {
+			return fmt.Errorf("pull %d is specified more than once", pull.Number)
+		}
+		seen[pull.Number] = true
+	}
+	return nil
+}
+
+// LoadRefs reads refs from a YAML or JSON file and validates them
+func LoadRefs(path string) (*pjapi.Refs, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read refs (%v)", err)
+	}
+	var refs *pjapi.Refs
+	if err := yaml.Unmarshal(data, &refs); err != nil {
+		return nil, fmt.Errorf("failed to load refs (%v)", err)
+	}
+	if err := ValidateRefs(refs); err != nil {
+		return nil, fmt.Errorf("invalid refs: %v", err)
+	}
+	return refs, nil
+}
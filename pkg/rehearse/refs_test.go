@@ -0,0 +1,102 @@
+package rehearse
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	pjapi "k8s.io/test-infra/prow/apis/prowjobs/v1"
+)
+
+func TestValidateRefs(t *testing.T) {
+	testCases := []struct {
+		description string
+		refs        *pjapi.Refs
+		expectedErr bool
+	}{{
+		description: "single pull",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseRef: "master", BaseSHA: "base", Pulls: []pjapi.Pull{{Number: 1, SHA: "a"}}},
+	}, {
+		description: "multiple pulls",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseRef: "master", BaseSHA: "base", Pulls: []pjapi.Pull{{Number: 1, SHA: "a"}, {Number: 2, SHA: "b"}}},
+	}, {
+		description: "tag without pulls",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseRef: "v4.0.0", BaseSHA: "base"},
+	}, {
+		description: "nil refs",
+		expectedErr: true,
+	}, {
+		description: "missing repo",
+		refs:        &pjapi.Refs{Org: "org", BaseRef: "master", BaseSHA: "base"},
+		expectedErr: true,
+	}, {
+		description: "missing base ref",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseSHA: "a"},
+		expectedErr: true,
+	}, {
+		description: "missing base revision",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseRef: "master", Pulls: []pjapi.Pull{{Number: 1, SHA: "a"}}},
+		expectedErr: true,
+	}, {
+		description: "pull without revision",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseRef: "master", BaseSHA: "base", Pulls: []pjapi.Pull{{Number: 1}}},
+		expectedErr: true,
+	}, {
+		description: "duplicate pulls",
+		refs:        &pjapi.Refs{Org: "org", Repo: "repo", BaseRef: "master", BaseSHA: "base", Pulls: []pjapi.Pull{{Number: 1, SHA: "a"}, {Number: 1, SHA: "b"}}},
+		expectedErr: true,
+	}}
+	for _, tc := range testCases {
+		if err := ValidateRefs(tc.refs); tc.expectedErr != (err != nil) {
+			t.Errorf("%s: expected error: %t, got: %v", tc.description, tc.expectedErr, err)
+		}
+	}
+}
+
+func TestLoadRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "refs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	valid := filepath.Join(dir, "valid.yaml")
+	if err := ioutil.WriteFile(valid, []byte(`org: openshift
+repo: release
+base_ref: master
+base_sha: abc
+pulls:
+- number: 1
+  sha: def
+- number: 2
+  sha: ghi
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := &pjapi.Refs{
+		Org:     "openshift",
+		Repo:    "release",
+		BaseRef: "master",
+		BaseSHA: "abc",
+		Pulls:   []pjapi.Pull{{Number: 1, SHA: "def"}, {Number: 2, SHA: "ghi"}},
+	}
+	refs, err := LoadRefs(valid)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !equality.Semantic.DeepEqual(expected, refs) {
+		t.Errorf("unexpected refs: %s", diff.ObjectReflectDiff(expected, refs))
+	}
+
+	invalid := filepath.Join(dir, "invalid.yaml")
+	if err := ioutil.WriteFile(invalid, []byte("org: openshift\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadRefs(invalid); err == nil {
+		t.Errorf("expected an error for invalid refs")
+	}
+}